@@ -11,6 +11,14 @@ import (
 // ErrNotImplemented Does exactly as its says
 var ErrNotImplemented = errors.New("Not yet implemented")
 
+// ErrMisconfigured is returned when a manager is used
+// before a valid configuration has been loaded into it.
+var ErrMisconfigured = errors.New("Manager has not been configured")
+
+// ErrConflict is returned when a change could not be applied as the
+// resource kept being changed by someone else at the same time.
+var ErrConflict = errors.New("Resource was changed concurrently")
+
 // IamManagerConfig defines the minimal required information
 // that a IamManager would require and also works as an expando object.
 // Allowing you to store additional information into it.
@@ -26,6 +34,7 @@ type IamManagerConfig struct {
 // to ensure that IAM management is enforced.
 // When an implemented IPolicyManger is created, it is expected that
 // it is able to interact with the provider out of the box.
+// All methods must be safe to be called concurrently.
 type IamPolicyManager interface {
 
 	// LoadConfiguration loads a configuration object
@@ -41,20 +50,24 @@ type IamPolicyManager interface {
 
 	// ListModifiedResources returns the resources
 	// that don't match what we currently have stored
+	// This includes resources that have been added or changed on the provider,
+	// and the stored version of any resource that has been removed from it.
 	// This is useful for services that want to be notified
 	// of changes.
 	// Ie.
 	// 	 - A Cron that alerts if changes have been made
 	//
 	// This should only return an error if it was unable
-	// to communicate with the provider
+	// to communicate with the provider, or ErrMisconfigured
+	// if no configuration has been loaded.
 	ListModifiedResources() ([]IamResource, error)
 
 	// Resync should apply the stored
 	// configuration to the resources/config currently managed.
 	// It should return a list of resources that were updated/changed.
 	// This should only report an error if the provider is unable to be
-	// contacted
+	// contacted, ErrMisconfigured if no configuration has been loaded, or
+	// ErrConflict if a change kept conflicting with changes made by someone else.
 	Resync() ([]IamResource, error)
 }
 
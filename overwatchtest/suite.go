@@ -0,0 +1,213 @@
+// Package overwatchtest provides a conformance suite that any
+// overwatch.IamPolicyManager implementation can be run against.
+// The provider supplies a fake backend that the suite drives to
+// check the manager loads its configuration, reports drift,
+// resyncs idempotently, classifies its errors and is safe for concurrent use.
+package overwatchtest
+
+import (
+	"sync"
+	"testing"
+
+	overwatch "github.com/SeedJobs/devops-go-overwatch"
+)
+
+// Backend allows the suite to change the state of a fake provider
+// that the manager under test is configured to talk to.
+type Backend interface {
+	// Seed creates count resources on the provider and records the same
+	// resources in the manager's store, returning the resource names.
+	// It is called before the configuration is loaded into the manager.
+	Seed(t *testing.T, count int) []string
+
+	// Add creates a resource on the provider that is not in the store
+	// and returns its name.
+	Add(t *testing.T) string
+
+	// Modify changes a seeded resource on the provider so that it no
+	// longer matches what is stored.
+	Modify(t *testing.T, name string)
+
+	// Remove deletes a seeded resource from the provider.
+	Remove(t *testing.T, name string)
+
+	// Stop makes the provider unreachable.
+	Stop()
+}
+
+// Fixture is everything the suite requires to test a single manager
+type Fixture struct {
+	// Manager is a newly created manager that has not yet been configured
+	Manager overwatch.IamPolicyManager
+	// Config is loaded into the Manager and must point it at the Backend,
+	// enforcing the store so that Resync corrects the drift it finds.
+	Config overwatch.IamManagerConfig
+	// Backend controls the fake provider
+	Backend Backend
+	// Cleanup is called once the suite has finished with the fixture
+	Cleanup func()
+}
+
+// Factory creates a fresh fixture for each part of the suite
+type Factory func(t *testing.T) Fixture
+
+// concurrency is the number of goroutines used to check
+// that a manager is safe for concurrent use.
+const concurrency = 8
+
+// Run executes the whole conformance suite against the managers created by factory.
+func Run(t *testing.T, factory Factory) {
+	t.Run("LoadConfiguration", func(t *testing.T) { testLoadConfiguration(t, factory) })
+	t.Run("Drift", func(t *testing.T) { testDrift(t, factory) })
+	t.Run("ResyncIdempotent", func(t *testing.T) { testResyncIdempotent(t, factory) })
+	t.Run("Errors", func(t *testing.T) { testErrors(t, factory) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, factory) })
+}
+
+// load seeds the backend and configures the manager from the fixture
+func load(t *testing.T, f Fixture, count int) []string {
+	seeded := f.Backend.Seed(t, count)
+	if err := f.Manager.LoadConfiguration(f.Config); err != nil {
+		t.Fatal("Unable to load configuration:", err)
+	}
+	return seeded
+}
+
+func names(resources []overwatch.IamResource) map[string]bool {
+	set := map[string]bool{}
+	for _, r := range resources {
+		set[r.GetName()] = true
+	}
+	return set
+}
+
+func checkImplemented(t *testing.T, err error, method string) {
+	if err == overwatch.ErrNotImplemented {
+		t.Fatal("Failed to implement", method)
+	}
+}
+
+func testLoadConfiguration(t *testing.T, factory Factory) {
+	f := factory(t)
+	defer f.Cleanup()
+	if len(f.Manager.Resources()) != 0 {
+		t.Fatal("Should not have loaded any resources before being configured")
+	}
+	err := f.Manager.LoadConfiguration(overwatch.IamManagerConfig{})
+	checkImplemented(t, err, "LoadConfiguration")
+	if err == nil {
+		t.Fatal("Expected an error when loading an empty configuration")
+	}
+	seeded := load(t, f, 3)
+	loaded := names(f.Manager.Resources())
+	for _, name := range seeded {
+		if !loaded[name] {
+			t.Error("Stored resource was not loaded:", name)
+		}
+	}
+	modified, err := f.Manager.ListModifiedResources()
+	checkImplemented(t, err, "ListModifiedResources")
+	if err != nil {
+		t.Fatal("Unable to list modified resources:", err)
+	}
+	if len(modified) != 0 {
+		t.Fatal("Expected no drift between the store and provider, got", modified)
+	}
+}
+
+func testDrift(t *testing.T, factory Factory) {
+	f := factory(t)
+	defer f.Cleanup()
+	seeded := load(t, f, 3)
+	unchanged, modified, removed := seeded[0], seeded[1], seeded[2]
+	added := f.Backend.Add(t)
+	f.Backend.Modify(t, modified)
+	f.Backend.Remove(t, removed)
+	drift, err := f.Manager.ListModifiedResources()
+	if err != nil {
+		t.Fatal("Unable to list modified resources:", err)
+	}
+	reported := names(drift)
+	for kind, name := range map[string]string{"added": added, "modified": modified, "removed": removed} {
+		if !reported[name] {
+			t.Errorf("Expected %s resource %s to be reported", kind, name)
+		}
+	}
+	if reported[unchanged] {
+		t.Error("Unchanged resource was reported as modified:", unchanged)
+	}
+}
+
+func testResyncIdempotent(t *testing.T, factory Factory) {
+	f := factory(t)
+	defer f.Cleanup()
+	seeded := load(t, f, 2)
+	f.Backend.Add(t)
+	f.Backend.Modify(t, seeded[1])
+	_, err := f.Manager.Resync()
+	checkImplemented(t, err, "Resync")
+	if err != nil {
+		t.Fatal("Unable to resync:", err)
+	}
+	second, err := f.Manager.Resync()
+	if err != nil {
+		t.Fatal("Unable to resync a second time:", err)
+	}
+	if len(second) != 0 {
+		t.Error("Expected a second resync to change nothing, got", second)
+	}
+	drift, err := f.Manager.ListModifiedResources()
+	if err != nil {
+		t.Fatal("Unable to list modified resources after resync:", err)
+	}
+	if len(drift) != 0 {
+		t.Error("Expected no drift after resyncing, got", drift)
+	}
+}
+
+func testErrors(t *testing.T, factory Factory) {
+	f := factory(t)
+	defer f.Cleanup()
+	if _, err := f.Manager.ListModifiedResources(); err != overwatch.ErrMisconfigured {
+		t.Error("Expected ListModifiedResources to report ErrMisconfigured, got", err)
+	}
+	if _, err := f.Manager.Resync(); err != overwatch.ErrMisconfigured {
+		t.Error("Expected Resync to report ErrMisconfigured, got", err)
+	}
+	load(t, f, 1)
+	f.Backend.Stop()
+	_, err := f.Manager.ListModifiedResources()
+	switch err {
+	case nil:
+		t.Fatal("Expected an error when the provider is unreachable")
+	case overwatch.ErrNotImplemented, overwatch.ErrMisconfigured:
+		t.Fatal("Unreachable provider was reported as", err)
+	}
+}
+
+func testConcurrency(t *testing.T, factory Factory) {
+	f := factory(t)
+	defer f.Cleanup()
+	seeded := load(t, f, 2)
+	f.Backend.Modify(t, seeded[0])
+	var wg sync.WaitGroup
+	errs := make(chan error, concurrency*2)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.Manager.Resources()
+			if _, err := f.Manager.ListModifiedResources(); err != nil {
+				errs <- err
+			}
+			if _, err := f.Manager.Resync(); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error("Concurrent call failed:", err)
+	}
+}
@@ -0,0 +1,124 @@
+package github
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"sort"
+	"strings"
+
+	gogithub "github.com/google/go-github/github"
+)
+
+// deployKey is an SSH key that has been given access to a single repo
+type deployKey struct {
+	Title string `json:"Title" yaml:"Title"`
+	// Fingerprint is the SHA256 fingerprint of the key, as shown by ssh-keygen -l
+	Fingerprint string `json:"Fingerprint" yaml:"Fingerprint"`
+	ReadOnly    bool   `json:"ReadOnly" yaml:"ReadOnly"`
+}
+
+// webhook is where Github sends the events of a repo. Only the host
+// of the url is kept as the path and query can hold secrets.
+type webhook struct {
+	Host   string   `json:"Host" yaml:"Host"`
+	Events []string `json:"Events" yaml:"Events"`
+	Active bool     `json:"Active" yaml:"Active"`
+}
+
+// fingerprint returns the SHA256 fingerprint of an authorized_keys formatted public key
+func fingerprint(key string) string {
+	fields := strings.Fields(key)
+	if len(fields) < 2 {
+		return ""
+	}
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// hookHost returns the host of the url a webhook delivers to
+func hookHost(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+func sortDeployKeys(keys []deployKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Fingerprint != keys[j].Fingerprint {
+			return keys[i].Fingerprint < keys[j].Fingerprint
+		}
+		return keys[i].Title < keys[j].Title
+	})
+}
+
+func sortWebhooks(hooks []webhook) {
+	for _, hook := range hooks {
+		sort.Strings(hook.Events)
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		if hooks[i].Host != hooks[j].Host {
+			return hooks[i].Host < hooks[j].Host
+		}
+		return strings.Join(hooks[i].Events, ",") < strings.Join(hooks[j].Events, ",")
+	})
+}
+
+// fetchDeployKeys lists the deploy keys of the repo
+func (m *manager) fetchDeployKeys(ctx context.Context, owner, repo string) ([]deployKey, error) {
+	keys := []deployKey{}
+	opt := &gogithub.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := m.client.Repositories.ListKeys(ctx, owner, repo, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range page {
+			keys = append(keys, deployKey{
+				Title:       key.GetTitle(),
+				Fingerprint: fingerprint(key.GetKey()),
+				ReadOnly:    key.GetReadOnly(),
+			})
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	sortDeployKeys(keys)
+	return keys, nil
+}
+
+// fetchWebhooks lists the webhooks of the repo
+func (m *manager) fetchWebhooks(ctx context.Context, owner, repo string) ([]webhook, error) {
+	hooks := []webhook{}
+	opt := &gogithub.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := m.client.Repositories.ListHooks(ctx, owner, repo, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, hook := range page {
+			address, _ := hook.Config["url"].(string)
+			events := append([]string{}, hook.Events...)
+			hooks = append(hooks, webhook{
+				Host:   hookHost(address),
+				Events: events,
+				Active: hook.GetActive(),
+			})
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	sortWebhooks(hooks)
+	return hooks, nil
+}
@@ -0,0 +1,228 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	overwatch "github.com/SeedJobs/devops-go-overwatch"
+	gogithub "github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// appTokenLifetime is how long the JWTs the app signs are valid for,
+	// Github rejects anything longer than ten minutes.
+	appTokenLifetime = 9 * time.Minute
+	// installationRefresh is how long before an installation token
+	// expires that it is replaced, so requests in flight never use an expired token.
+	installationRefresh = 5 * time.Minute
+)
+
+// appTokenSource authenticates as a Github App installation.
+// It signs a JWT with the private key of the app, uses it to find the
+// installation for the organisation and exchanges it for an installation token.
+type appTokenSource struct {
+	appID        int64
+	key          *rsa.PrivateKey
+	organisation string
+	// client sends requests authenticated as the app rather than the installation
+	client *gogithub.Client
+	now    func() time.Time
+
+	mu           sync.Mutex
+	installation int64
+}
+
+// readAppConfig loads the Github App settings from the additional map.
+// It reports false when no app has been configured.
+func readAppConfig(additional map[string]interface{}) (int64, *rsa.PrivateKey, int64, bool, error) {
+	appID, isApp, err := readID(additional, "GITHUB_APP_ID")
+	if err != nil || !isApp {
+		return 0, nil, 0, false, err
+	}
+	var buff []byte
+	switch key := additional["GITHUB_APP_PRIVATE_KEY"].(type) {
+	case string:
+		buff = []byte(key)
+	case []byte:
+		buff = key
+	default:
+		file, ok := additional["GITHUB_APP_PRIVATE_KEY_FILE"].(string)
+		if !ok {
+			return 0, nil, 0, false, fmt.Errorf("%w, GITHUB_APP_PRIVATE_KEY or GITHUB_APP_PRIVATE_KEY_FILE was not defined in conf additional map", overwatch.ErrMisconfigured)
+		}
+		read, err := ioutil.ReadFile(file)
+		if err != nil {
+			return 0, nil, 0, false, err
+		}
+		buff = read
+	}
+	key, err := parsePrivateKey(buff)
+	if err != nil {
+		return 0, nil, 0, false, err
+	}
+	installation, _, err := readID(additional, "GITHUB_APP_INSTALLATION_ID")
+	if err != nil {
+		return 0, nil, 0, false, err
+	}
+	return appID, key, installation, true, nil
+}
+
+// readID reads an ID from the additional map that is either a number or a string
+// of digits, environment variables are only able to provide the latter.
+// It reports false when the ID has not been defined.
+func readID(additional map[string]interface{}, name string) (int64, bool, error) {
+	switch id := additional[name].(type) {
+	case nil:
+		return 0, false, nil
+	case int:
+		return int64(id), true, nil
+	case int64:
+		return id, true, nil
+	case string:
+		parsed, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("%w, %s %s is not a number", overwatch.ErrMisconfigured, name, id)
+		}
+		return parsed, true, nil
+	default:
+		return 0, false, fmt.Errorf("%w, %s must be a number, got %T", overwatch.ErrMisconfigured, name, id)
+	}
+}
+
+// parsePrivateKey reads the PEM encoded key Github generates for an app
+func parsePrivateKey(buff []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(buff)
+	if block == nil {
+		return nil, fmt.Errorf("Unable to find a PEM encoded private key for the Github App")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse the private key of the Github App: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("The private key of the Github App must be an RSA key")
+	}
+	return key, nil
+}
+
+func newAppTokenSource(appID int64, key *rsa.PrivateKey, installation int64, organisation string, httpClient *http.Client, base, upload *url.URL) *appTokenSource {
+	source := &appTokenSource{
+		appID:        appID,
+		key:          key,
+		organisation: organisation,
+		installation: installation,
+		now:          time.Now,
+	}
+	source.client = newGithubClient(&http.Client{
+		Transport: &appTransport{base: httpClient.Transport, source: source},
+	}, base, upload)
+	return source
+}
+
+// jwt returns a token signed by the app that Github accepts for the app endpoints
+func (a *appTokenSource) jwt() (string, error) {
+	// Issued in the past to allow for clock drift between us and Github
+	now := a.now().Add(-time.Minute)
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Unix(),
+		"exp": now.Add(appTokenLifetime).Unix(),
+		"iss": a.appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// installationID returns the installation of the app inside the organisation,
+// looking it up the first time it is needed.
+func (a *appTokenSource) installationID(ctx context.Context) (int64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.installation != 0 {
+		return a.installation, nil
+	}
+	req, err := a.client.NewRequest("GET", fmt.Sprintf("orgs/%v/installation", a.organisation), nil)
+	if err != nil {
+		return 0, err
+	}
+	var installation gogithub.Installation
+	if _, err := a.client.Do(ctx, req, &installation); err != nil {
+		return 0, fmt.Errorf("Unable to find the Github App installation for %s: %v", a.organisation, err)
+	}
+	a.installation = installation.GetID()
+	return a.installation, nil
+}
+
+// Token exchanges the app JWT for a new installation token. The expiry is brought
+// forward so that oauth2.ReuseTokenSource replaces the token before Github expires it.
+func (a *appTokenSource) Token() (*oauth2.Token, error) {
+	ctx := context.Background()
+	id, err := a.installationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := a.client.NewRequest("POST", fmt.Sprintf("app/installations/%v/access_tokens", id), nil)
+	if err != nil {
+		return nil, err
+	}
+	var token gogithub.InstallationToken
+	if _, err := a.client.Do(ctx, req, &token); err != nil {
+		return nil, fmt.Errorf("Unable to create a Github App installation token: %v", err)
+	}
+	return &oauth2.Token{
+		AccessToken: token.GetToken(),
+		TokenType:   "token",
+		Expiry:      token.GetExpiresAt().Add(-installationRefresh),
+	}, nil
+}
+
+// appTransport authenticates each request as the app using a freshly signed JWT
+type appTransport struct {
+	base   http.RoundTripper
+	source *appTokenSource
+}
+
+func (t *appTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.source.jwt()
+	if err != nil {
+		return nil, err
+	}
+	out := req.WithContext(req.Context())
+	out.Header = cloneHeader(req.Header)
+	out.Header.Set("Authorization", "Bearer "+token)
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(out)
+}
@@ -0,0 +1,201 @@
+package github
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path"
+	"sort"
+	"sync"
+)
+
+// defaultCacheEntries bounds the cache when CacheMaxEntries is not defined in the additional map
+const defaultCacheEntries = 10000
+
+// cacheTransport makes conditional requests to Github using the ETag of
+// the previous response for the same url. Github does not count a
+// 304 Not Modified against the rate limit, so unchanged pages are free.
+// Responses are kept in memory and, when a directory is given, on disk
+// so the cache survives restarts.
+type cacheTransport struct {
+	base http.RoundTripper
+	dir  string
+	// identity stands in for the credentials in cache keys, so entries survive
+	// the token being refreshed while different credentials are kept apart.
+	identity string
+	// maxEntries bounds the cache, the least recently used entries are evicted first
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order has the most recently used entry at the front
+	order *list.List
+}
+
+// cacheEntry is a cached response, dump is nil until an entry
+// that was found on disk is first used.
+type cacheEntry struct {
+	key  string
+	dump []byte
+}
+
+func newCacheTransport(base http.RoundTripper, dir, identity string, maxEntries int) *cacheTransport {
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheEntries
+	}
+	c := &cacheTransport{
+		base:       base,
+		dir:        dir,
+		identity:   identity,
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+	}
+	c.index()
+	return c
+}
+
+// index tracks the entries left on disk by a previous run, oldest first,
+// so that they are evicted along with everything else.
+func (c *cacheTransport) index() {
+	if c.dir == "" {
+		return
+	}
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, f := range files {
+		if !f.IsDir() {
+			c.add(f.Name(), nil)
+		}
+	}
+}
+
+func (c *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return c.base.RoundTrip(req)
+	}
+	key := c.cacheKey(req)
+	cached := c.load(key, req)
+	out := req
+	if cached != nil {
+		out = req.WithContext(req.Context())
+		out.Header = cloneHeader(req.Header)
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			out.Header.Set("If-None-Match", etag)
+		}
+		if modified := cached.Header.Get("Last-Modified"); modified != "" {
+			out.Header.Set("If-Modified-Since", modified)
+		}
+	}
+	resp, err := c.base.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		// Keep the fresh headers, such as the rate limit, with the cached body
+		for name, values := range resp.Header {
+			cached.Header[name] = values
+		}
+		cached.StatusCode, cached.Status = http.StatusOK, "200 OK"
+		cached.Request = req
+		resp.Body.Close()
+		return cached, nil
+	case resp.StatusCode == http.StatusOK && (resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""):
+		dump, err := httputil.DumpResponse(resp, true)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		c.store(key, dump)
+	}
+	return resp, nil
+}
+
+// cacheKey separates entries by the credentials used as different credentials
+// are able to see different data. The identity of the credentials is used rather
+// than the token, as installation tokens are replaced every hour.
+func (c *cacheTransport) cacheKey(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.Method + " " + req.URL.String() + "\n" + c.identity + "\n" + req.Header.Get("Accept")))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *cacheTransport) load(key string, req *http.Request) *http.Response {
+	c.mu.Lock()
+	var dump []byte
+	elem, exist := c.entries[key]
+	if exist {
+		c.order.MoveToFront(elem)
+		dump = elem.Value.(*cacheEntry).dump
+	}
+	c.mu.Unlock()
+	if exist && dump == nil && c.dir != "" {
+		buff, err := ioutil.ReadFile(path.Join(c.dir, key))
+		if err != nil {
+			return nil
+		}
+		dump = buff
+		c.mu.Lock()
+		c.add(key, dump)
+		c.mu.Unlock()
+	}
+	if dump == nil {
+		return nil
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(dump)), req)
+	if err != nil {
+		return nil
+	}
+	return resp
+}
+
+func (c *cacheTransport) store(key string, dump []byte) {
+	c.mu.Lock()
+	c.add(key, dump)
+	c.mu.Unlock()
+	if c.dir == "" {
+		return
+	}
+	// The cache is only an optimisation so failing to persist it is not fatal
+	if err := os.MkdirAll(c.dir, os.ModePerm); err != nil {
+		return
+	}
+	ioutil.WriteFile(path.Join(c.dir, key), dump, 0600)
+}
+
+// add makes the entry the most recently used and evicts the least recently
+// used entries past maxEntries, it must be called with mu held.
+func (c *cacheTransport) add(key string, dump []byte) {
+	if elem, exist := c.entries[key]; exist {
+		elem.Value.(*cacheEntry).dump = dump
+		c.order.MoveToFront(elem)
+	} else {
+		c.entries[key] = c.order.PushFront(&cacheEntry{key: key, dump: dump})
+	}
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Remove(c.order.Back()).(*cacheEntry)
+		delete(c.entries, oldest.key)
+		if c.dir != "" {
+			os.Remove(path.Join(c.dir, oldest.key))
+		}
+	}
+}
+
+func cloneHeader(h http.Header) http.Header {
+	cp := http.Header{}
+	for name, values := range h {
+		cp[name] = append([]string{}, values...)
+	}
+	return cp
+}
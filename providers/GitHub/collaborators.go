@@ -0,0 +1,140 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	overwatch "github.com/SeedJobs/devops-go-overwatch"
+	"github.com/SeedJobs/devops-go-overwatch/providers/default"
+	gogithub "github.com/google/go-github/github"
+)
+
+// collaborator is someone granted access to a repo directly rather than through a team
+type collaborator struct {
+	Login      string `json:"Login" yaml:"Login"`
+	Permission string `json:"Permission" yaml:"Permission"`
+	// OutsideTeams flags an admin grant to someone who is not in any team of
+	// the organisation. It is reported as drift until it is stored as well.
+	OutsideTeams bool `json:"OutsideTeams" yaml:"OutsideTeams"`
+}
+
+func (c collaborator) GetName() string {
+	return c.Login
+}
+
+func (c collaborator) String() string {
+	return c.Login + ":" + c.Permission
+}
+
+// permissionOrder lists the repo permissions from the most to the least access,
+// the v3 API reports a flag for each permission the collaborator holds.
+var permissionOrder = []string{"admin", "maintain", "push", "triage", "pull"}
+
+func sortCollaborators(collaborators []collaborator) {
+	sort.Slice(collaborators, func(i, j int) bool {
+		return collaborators[i].Login < collaborators[j].Login
+	})
+}
+
+// fetchCollaborators lists the users given access to the repo directly
+func (m *manager) fetchCollaborators(ctx context.Context, owner, repo string) ([]collaborator, error) {
+	collaborators := []collaborator{}
+	opt := &gogithub.ListCollaboratorsOptions{
+		Affiliation: "direct",
+		ListOptions: gogithub.ListOptions{PerPage: 100},
+	}
+	for {
+		users, resp, err := m.client.Repositories.ListCollaborators(ctx, owner, repo, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			c := collaborator{Login: user.GetLogin(), Permission: permissionUnknown}
+			if user.Permissions != nil {
+				for _, permission := range permissionOrder {
+					if (*user.Permissions)[permission] {
+						c.Permission = permission
+						break
+					}
+				}
+			}
+			collaborators = append(collaborators, c)
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	sortCollaborators(collaborators)
+	return collaborators, nil
+}
+
+// rememberTeams keeps the members of each team so that admins can be flagged
+// when a single repo is rechecked without listing every team again.
+func (m *manager) rememberTeams(teams []overwatch.IamResource) {
+	m.teamLogins = map[string][]teamMember{}
+	for _, resource := range teams {
+		t := resource.(team)
+		m.teamLogins[t.Slug] = t.Members
+	}
+}
+
+// inTeam returns the login of everyone inside one of the teams that were last seen
+func (m *manager) inTeam() map[string]bool {
+	members := map[string]bool{}
+	for _, team := range m.teamLogins {
+		for _, member := range team {
+			members[member.Login] = true
+		}
+	}
+	return members
+}
+
+// flagAdmins marks the collaborators of the repo that have admin
+// access without being a member of any team in the organisation.
+func flagAdmins(repo project, inTeam map[string]bool) project {
+	collaborators := make([]collaborator, len(repo.Collaborators))
+	for i, c := range repo.Collaborators {
+		c.OutsideTeams = c.Permission == "admin" && !inTeam[c.Login]
+		collaborators[i] = c
+	}
+	repo.Collaborators = collaborators
+	return repo
+}
+
+// planCollaborators adds the changes that bring the collaborators of the repo from
+// actual to desired. Collaborators missing from desired have their access removed,
+// once the collaborators of the repo have been stored.
+func (m *manager) planCollaborators(plan *abstract.Plan, desired, actual project) {
+	if desired.Collaborators == nil {
+		return
+	}
+	current := map[string]string{}
+	for _, c := range actual.Collaborators {
+		current[c.Login] = c.Permission
+	}
+	wanted := map[string]bool{}
+	for _, c := range desired.Collaborators {
+		wanted[c.Login] = true
+		if permission, exist := current[c.Login]; c.Permission == permissionUnknown || exist && permission == c.Permission {
+			continue
+		}
+		c := c
+		plan.Add(desired, fmt.Sprintf("grant %s %s access", c.Login, c.Permission), func(ctx context.Context) error {
+			opt := &gogithub.RepositoryAddCollaboratorOptions{Permission: c.Permission}
+			_, err := m.client.Repositories.AddCollaborator(ctx, m.organisation, desired.Name, c.Login, opt)
+			return err
+		})
+	}
+	for _, c := range actual.Collaborators {
+		if wanted[c.Login] {
+			continue
+		}
+		login := c.Login
+		plan.Add(desired, fmt.Sprintf("revoke access of %s", login), func(ctx context.Context) error {
+			_, err := m.client.Repositories.RemoveCollaborator(ctx, m.organisation, desired.Name, login)
+			return err
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	overwatch "github.com/SeedJobs/devops-go-overwatch"
+	"github.com/SeedJobs/devops-go-overwatch/providers/default"
+	gogithub "github.com/google/go-github/github"
+)
+
+// plan works out the changes that apply the stored configuration of each
+// modified resource to Github. Visibility, branch protection, team access and
+// collaborators of repos can be enforced, changes to anything else, such as deploy keys
+// and webhooks, are only reported.
+func (m *manager) plan(modified []overwatch.IamResource) *abstract.Plan {
+	plan := &abstract.Plan{}
+	ids := m.teamIDLookup()
+	for _, item := range modified {
+		actual, ok := item.(project)
+		if !ok {
+			continue
+		}
+		desired := m.resources[item.GetType()][item.GetName()].(project).fillUnset(actual)
+		if desired.Public != actual.Public {
+			m.planVisibility(plan, desired)
+		}
+		m.planProtection(plan, desired, actual)
+		m.planTeamAccess(plan, desired, actual, ids)
+		m.planCollaborators(plan, desired, actual)
+	}
+	return plan
+}
+
+func (m *manager) planVisibility(plan *abstract.Plan, desired project) {
+	description := "make private"
+	if desired.Public {
+		description = "make public"
+	}
+	plan.Add(desired, description, func(ctx context.Context) error {
+		_, _, err := m.client.Repositories.Edit(ctx, m.organisation, desired.Name, &gogithub.Repository{
+			Private: gogithub.Bool(!desired.Public),
+		})
+		return err
+	})
+}
+
+// planProtection protects each branch the same way it is stored and removes
+// the protection of any branch that is not stored as protected. Branches stored
+// in the legacy format without their protection are left as they are.
+func (m *manager) planProtection(plan *abstract.Plan, desired, actual project) {
+	current := map[string]branchProtection{}
+	for _, protection := range actual.Protected {
+		current[protection.Branch] = protection
+	}
+	wanted := map[string]bool{}
+	for _, protection := range desired.Protected {
+		wanted[protection.Branch] = true
+		if protection.legacy {
+			continue
+		}
+		if existing, exist := current[protection.Branch]; exist && reflect.DeepEqual(existing, protection) {
+			continue
+		}
+		protection := protection
+		plan.Add(desired, fmt.Sprintf("protect branch %s", protection.Branch), func(ctx context.Context) error {
+			return m.updateBranchProtection(ctx, desired.Name, protection)
+		})
+	}
+	for _, protection := range actual.Protected {
+		if wanted[protection.Branch] {
+			continue
+		}
+		branch := protection.Branch
+		plan.Add(desired, fmt.Sprintf("remove protection from branch %s", branch), func(ctx context.Context) error {
+			return m.removeBranchProtection(ctx, desired.Name, branch)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package github
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	gogithub "github.com/google/go-github/github"
+)
+
+// enterpriseURLs reads the API endpoints of a Github Enterprise Server from
+// GITHUB_BASE_URL and GITHUB_UPLOAD_URL, both are empty when using github.com.
+// The base url may be given as just the host, in which case the
+// /api/v3/ path is added along with the default upload url.
+func enterpriseURLs(additional map[string]interface{}) (*url.URL, *url.URL, error) {
+	baseURL, exist := additional["GITHUB_BASE_URL"].(string)
+	if !exist || baseURL == "" {
+		return nil, nil, nil
+	}
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if base.Scheme == "" || base.Host == "" {
+		return nil, nil, fmt.Errorf("GITHUB_BASE_URL %s must be an absolute url", baseURL)
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	if !strings.HasSuffix(base.Path, "/api/v3/") {
+		base.Path += "api/v3/"
+	}
+	upload := &url.URL{Scheme: base.Scheme, Host: base.Host, Path: "/api/uploads/"}
+	if uploadURL, exist := additional["GITHUB_UPLOAD_URL"].(string); exist && uploadURL != "" {
+		if upload, err = url.Parse(uploadURL); err != nil {
+			return nil, nil, err
+		}
+		if !strings.HasSuffix(upload.Path, "/") {
+			upload.Path += "/"
+		}
+	}
+	return base, upload, nil
+}
+
+// newGithubClient creates a client for github.com, or for the
+// Github Enterprise Server at base when it is not nil.
+func newGithubClient(httpClient *http.Client, base, upload *url.URL) *gogithub.Client {
+	client := gogithub.NewClient(httpClient)
+	if base != nil {
+		client.BaseURL, client.UploadURL = base, upload
+	}
+	return client
+}
+
+// graphqlEndpoint returns where the GraphQL API is served relative to the v3 API,
+// Github Enterprise Server serves it from /api/graphql rather than /api/v3/graphql.
+func graphqlEndpoint(base *url.URL) string {
+	if base == nil {
+		return "graphql"
+	}
+	return base.ResolveReference(&url.URL{Path: "../graphql"}).String()
+}
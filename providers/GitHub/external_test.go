@@ -1,12 +1,29 @@
 package github_test
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	overwatch "github.com/SeedJobs/devops-go-overwatch"
+	"github.com/SeedJobs/devops-go-overwatch/overwatchtest"
 	github "github.com/SeedJobs/devops-go-overwatch/providers/GitHub"
+	"github.com/SeedJobs/devops-go-overwatch/providers/GitHub/fake"
 )
 
 var (
@@ -16,32 +33,124 @@ var (
 )
 
 func init() {
-	if GithubOrg = os.Getenv("GITHUB_ORG"); GithubOrg == "" {
-		fmt.Println("Unable to determine GITHUB_ORG from env")
-		os.Exit(1)
+	GithubOrg = os.Getenv("GITHUB_ORG")
+	GitRepository = os.Getenv("TEST_GIT_URL")
+	GitHubEnv = os.Getenv("GITHUB_TOKEN")
+}
+
+// backend drives the fake Github API for the conformance suite
+type backend struct {
+	server *fake.Server
+	org    string
+	dir    string
+	count  int
+}
+
+func (b *backend) newRepo() fake.Repo {
+	b.count++
+	return fake.Repo{
+		Name:     fmt.Sprintf("repo-%d", b.count),
+		Branches: map[string]bool{"master": true, "develop": false},
 	}
-	if GitRepository = os.Getenv("TEST_GIT_URL"); GitRepository == "" {
-		fmt.Println("Unable to determine TEST_GIT_URL from env")
-		os.Exit(1)
+}
+
+// storeDefaultSettings records the settings the fake gives a new organisation,
+// so that tests only see drift in the resources they are interested in.
+func storeDefaultSettings(t *testing.T, dir, org string) {
+	stored := fmt.Sprintf(`---
+- Organisation: %s
+  TwoFactorRequired: false
+  DefaultRepositoryPermission: read
+  MembersCanCreatePublicRepos: true
+  MembersCanCreatePrivateRepos: true
+  MembersCanForkPrivateRepos: false
+  WebCommitSignoffRequired: false
+`, org)
+	dir = path.Join(dir, "Github", org, "Settings")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
 	}
-	if GitHubEnv = os.Getenv("GITHUB_TOKEN"); GitHubEnv == "" {
-		fmt.Println("Unable to determine GITHUB_TOKEN from env")
-		os.Exit(1)
+	if err := ioutil.WriteFile(path.Join(dir, "Setting.yml"), []byte(stored), 0644); err != nil {
+		t.Fatal(err)
 	}
 }
 
-func TestInterface(t *testing.T) {
-	man, err := github.NewManager()
-	if err != nil {
-		t.Log("issue:", err)
-		t.Fatal("Unable to create manager")
+func (b *backend) Seed(t *testing.T, count int) []string {
+	storeDefaultSettings(t, b.dir, b.org)
+	stored, seeded := "---\n", []string{}
+	for i := 0; i < count; i++ {
+		repo := b.newRepo()
+		b.server.AddRepo(b.org, repo)
+		stored += fmt.Sprintf("- Name: %s\n  Protected:\n    - master\n  Public: true\n", repo.Name)
+		seeded = append(seeded, repo.Name)
 	}
-	if _, ok := man.(overwatch.IamPolicyManager); !ok {
-		t.Fatal("Does not implement the overwatch IPolicyManager")
+	dir := path.Join(b.dir, "Github", b.org, "Repos")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
 	}
+	if err := ioutil.WriteFile(path.Join(dir, "Repo.yml"), []byte(stored), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return seeded
+}
+
+func (b *backend) Add(t *testing.T) string {
+	repo := b.newRepo()
+	b.server.AddRepo(b.org, repo)
+	return repo.Name
+}
+
+func (b *backend) Modify(t *testing.T, name string) {
+	if err := b.server.UpdateRepo(b.org, name, func(r *fake.Repo) { r.Private = !r.Private }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (b *backend) Remove(t *testing.T, name string) {
+	b.server.RemoveRepo(b.org, name)
+}
+
+func (b *backend) Stop() {
+	b.server.Close()
+}
+
+func TestConformance(t *testing.T) {
+	overwatchtest.Run(t, func(t *testing.T) overwatchtest.Fixture {
+		server := fake.NewServer()
+		dir, err := ioutil.TempDir("", "overwatch")
+		if err != nil {
+			t.Fatal(err)
+		}
+		man, err := github.NewManager()
+		if err != nil {
+			t.Fatal("Unable to create manager")
+		}
+		server.AddOrg("overwatch")
+		return overwatchtest.Fixture{
+			Manager: man,
+			Config: overwatch.IamManagerConfig{
+				Additional: map[string]interface{}{
+					"GITHUB_ORG":   "overwatch",
+					"HTTPClient":   server.Client(),
+					"RetryBackoff": time.Millisecond,
+					"Synchro":      "local",
+					"Location":     dir,
+					"Enforce":      true,
+				},
+			},
+			Backend: &backend{server: server, org: "overwatch", dir: dir},
+			Cleanup: func() {
+				server.Close()
+				os.RemoveAll(dir)
+			},
+		}
+	})
 }
 
 func TestQuerryingProjects(t *testing.T) {
+	if GithubOrg == "" || GitRepository == "" || GitHubEnv == "" {
+		t.Skip("GITHUB_ORG, TEST_GIT_URL and GITHUB_TOKEN need to be set")
+	}
 	man, err := github.NewManager()
 	if err != nil {
 		t.Log("issue:", err)
@@ -76,3 +185,1388 @@ func TestQuerryingProjects(t *testing.T) {
 		t.Logf("\tResource used: %+v", resource)
 	}
 }
+
+func TestConcurrentScanKeepsOrder(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "overwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storeDefaultSettings(t, dir, "overwatch")
+	server.SetRateLimit(5000, 5000, time.Now().Add(time.Hour))
+	expected := []string{}
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("repo-%03d", i)
+		server.AddRepo("overwatch", fake.Repo{Name: name, Branches: map[string]bool{"master": true}})
+		expected = append(expected, name)
+	}
+	man, err := github.NewManager()
+	if err != nil {
+		t.Fatal("Unable to create manager")
+	}
+	err = man.LoadConfiguration(overwatch.IamManagerConfig{
+		Additional: map[string]interface{}{
+			"Concurrency": 16,
+			"GITHUB_ORG":  "overwatch",
+			"HTTPClient":  server.Client(),
+			"Synchro":     "local",
+			"Location":    dir,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources, err := man.ListModifiedResources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resources) != len(expected) {
+		t.Fatal("Expected", len(expected), "resources, got", len(resources))
+	}
+	for i, resource := range resources {
+		if resource.GetName() != expected[i] {
+			t.Fatal("Expected", expected[i], "at position", i, "got", resource.GetName())
+		}
+	}
+	limiter, ok := man.(github.RateLimiter)
+	if !ok {
+		t.Fatal("Manager does not report its rate limit")
+	}
+	if rate := limiter.RateLimit(); rate.Remaining != 5000-server.Calls() {
+		t.Fatal("Expected the remaining budget to be reported, got", rate)
+	}
+}
+
+func scanWithBackend(t *testing.T, server *fake.Server, org, backend string) ([]overwatch.IamResource, error) {
+	dir, err := ioutil.TempDir("", "overwatch-github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storeDefaultSettings(t, dir, org)
+	man, err := github.NewManager()
+	if err != nil {
+		t.Fatal("Unable to create manager")
+	}
+	err = man.LoadConfiguration(overwatch.IamManagerConfig{
+		Additional: map[string]interface{}{
+			"Backend":    backend,
+			"GITHUB_ORG": org,
+			"HTTPClient": server.Client(),
+			"MaxRetries": 0,
+			"Synchro":    "local",
+			"Location":   dir,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return man.ListModifiedResources()
+}
+
+const deployKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGrRLygOD05jnn93/zOXQewSVsBqqUyV84llHyfyQBsK deploy"
+
+func TestGraphQLMatchesREST(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	// Enough repos and team access to need more than one page of each connection
+	platform := fake.Team{Name: "Platform", Slug: "platform", Repos: map[string]string{}}
+	for i := 0; i < 150; i++ {
+		repo := fake.Repo{
+			Name:       fmt.Sprintf("repo-%03d", i),
+			Private:    i%3 == 0,
+			Branches:   map[string]bool{"master": i%2 == 0, "develop": i%5 == 0},
+			DeployKeys: []fake.DeployKey{{Title: "ci", Key: deployKey, ReadOnly: i%2 == 0}},
+			Hooks:      []fake.Hook{{URL: "https://hooks.example.com/events?secret=x", Events: []string{"push", "create"}, Active: i%4 != 0}},
+			Collaborators: map[string]string{
+				"contractor": []string{"pull", "triage", "push", "maintain", "admin"}[i%5],
+			},
+			Protection: map[string]fake.Protection{
+				"master": {
+					RequiredReviews:      i % 3,
+					DismissStaleReviews:  i%4 == 0,
+					RequiredStatusChecks: []string{"ci/build"},
+					EnforceAdmins:        i%6 == 0,
+					RestrictPushes:       i%8 == 0,
+					PushUsers:            []string{"release-bot"},
+					PushTeams:            []string{"platform"},
+					AllowDeletions:       i%10 == 0,
+				},
+			},
+		}
+		server.AddRepo("overwatch", repo)
+		if i < 120 {
+			platform.Repos[repo.Name] = "push"
+		}
+	}
+	// A repository with more of each nested connection than fits in the first page,
+	// push allowances and collaborators need more than one page to be followed up.
+	crowded := fake.Repo{
+		Name:          "crowded",
+		Branches:      map[string]bool{},
+		Protection:    map[string]fake.Protection{},
+		Collaborators: map[string]string{},
+	}
+	for i := 0; i < 130; i++ {
+		crowded.Collaborators[fmt.Sprintf("user-%03d", i)] = "push"
+	}
+	for i := 0; i < 30; i++ {
+		branch := fmt.Sprintf("release-%02d", i)
+		crowded.Branches[branch] = true
+		crowded.Protection[branch] = fake.Protection{RestrictPushes: true, PushUsers: []string{"release-bot"}}
+		crowded.DeployKeys = append(crowded.DeployKeys, fake.DeployKey{Title: branch, Key: deployKey})
+	}
+	protection := crowded.Protection["release-00"]
+	for i := 0; i < 130; i++ {
+		protection.PushUsers = append(protection.PushUsers, fmt.Sprintf("user-%03d", i))
+	}
+	crowded.Protection["release-00"] = protection
+	server.AddRepo("overwatch", crowded)
+	server.AddTeam("overwatch", platform)
+	server.AddTeam("overwatch", fake.Team{
+		Name:  "Security",
+		Slug:  "security",
+		Repos: map[string]string{"repo-000": "admin", "repo-149": "pull"},
+	})
+	rest, err := scanWithBackend(t, server, "overwatch", github.BackendREST)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graphql, err := scanWithBackend(t, server, "overwatch", github.BackendGraphQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rest, graphql) {
+		t.Fatal("Expected both backends to find the same resources")
+	}
+}
+
+func TestGraphQLReportsErrors(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	if _, err := scanWithBackend(t, server, "missing", github.BackendGraphQL); err == nil {
+		t.Fatal("Expected an error for an organisation that does not exist")
+	}
+}
+
+func TestResyncEnforcesTeamAccess(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "overwatch-github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storeDefaultSettings(t, dir, "overwatch")
+	server.AddRepo("overwatch", fake.Repo{Name: "service", Branches: map[string]bool{"master": true}})
+	server.AddTeam("overwatch", fake.Team{Name: "Platform", Slug: "platform", Repos: map[string]string{"service": "admin"}})
+	server.AddTeam("overwatch", fake.Team{Name: "Security", Slug: "security", Repos: map[string]string{"service": "pull"}})
+	server.AddTeam("overwatch", fake.Team{Name: "Support", Slug: "support"})
+	// Only the slug of the release team is stored so its permission is left alone
+	server.AddTeam("overwatch", fake.Team{Name: "Release", Slug: "release", Repos: map[string]string{"service": "maintain"}})
+	stored := path.Join(dir, "Github", "overwatch", "Repos")
+	if err := os.MkdirAll(stored, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path.Join(stored, "Repo.yml"), []byte(`---
+- Name: service
+  Protected:
+    - master
+  Public: true
+  Teams:
+    - Slug: platform
+      Permission: push
+    - support
+    - release
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	teams := path.Join(dir, "Github", "overwatch", "Teams")
+	if err := os.MkdirAll(teams, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path.Join(teams, "Team.yml"), []byte(`---
+- Slug: platform
+  Name: Platform
+  Privacy: secret
+- Slug: security
+  Name: Security
+  Privacy: secret
+- Slug: support
+  Name: Support
+  Privacy: secret
+- Slug: release
+  Name: Release
+  Privacy: secret
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	man, err := github.NewManager()
+	if err != nil {
+		t.Fatal("Unable to create manager")
+	}
+	err = man.LoadConfiguration(overwatch.IamManagerConfig{
+		Additional: map[string]interface{}{
+			"Enforce":    true,
+			"GITHUB_ORG": "overwatch",
+			"HTTPClient": server.Client(),
+			"Synchro":    "local",
+			"Location":   dir,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified, err := man.ListModifiedResources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modified) != 1 || modified[0].GetName() != "service" {
+		t.Fatal("Expected the change in team access to be reported, got", modified)
+	}
+	if _, err := man.Resync(); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"platform": "push", "security": "", "support": "pull", "release": "maintain"}
+	for slug, permission := range expected {
+		team, _ := server.Team("overwatch", slug)
+		if team.Repos["service"] != permission {
+			t.Fatal("Expected", slug, "to have", permission, "access, got", team.Repos["service"])
+		}
+	}
+	if modified, err = man.ListModifiedResources(); err != nil || len(modified) != 0 {
+		t.Fatal("Expected no drift once the team access was enforced, got", modified, err)
+	}
+}
+
+func TestUnstoredTeamAccessIsKept(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "overwatch-github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storeDefaultSettings(t, dir, "overwatch")
+	server.AddRepo("overwatch", fake.Repo{Name: "service", Private: true})
+	server.AddTeam("overwatch", fake.Team{Name: "Platform", Slug: "platform", Repos: map[string]string{"service": "admin"}})
+	stored := path.Join(dir, "Github", "overwatch")
+	for kind, content := range map[string]string{
+		"Repo": "---\n- Name: service\n  Public: true\n",
+		"Team": "---\n- Slug: platform\n  Name: Platform\n  Privacy: secret\n",
+	} {
+		if err := os.MkdirAll(path.Join(stored, kind+"s"), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(stored, kind+"s", kind+".yml"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	man, err := github.NewManager()
+	if err != nil {
+		t.Fatal("Unable to create manager")
+	}
+	err = man.LoadConfiguration(overwatch.IamManagerConfig{
+		Additional: map[string]interface{}{
+			"Enforce":    true,
+			"GITHUB_ORG": "overwatch",
+			"HTTPClient": server.Client(),
+			"Synchro":    "local",
+			"Location":   dir,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := man.Resync(); err != nil {
+		t.Fatal(err)
+	}
+	repo, _ := server.Repo("overwatch", "service")
+	if repo.Private {
+		t.Fatal("Expected the stored visibility to be enforced")
+	}
+	if team, _ := server.Team("overwatch", "platform"); team.Repos["service"] != "admin" {
+		t.Fatal("Expected team access that was never stored to be kept, got", team.Repos)
+	}
+}
+
+func TestMemberChangesAreReported(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "overwatch-github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storeDefaultSettings(t, dir, "overwatch")
+	stored := path.Join(dir, "Github", "overwatch", "Members")
+	if err := os.MkdirAll(stored, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path.Join(stored, "Member.yml"), []byte(`---
+- Login: alice
+  Role: admin
+- Login: bob
+  Role: member
+- Login: carol
+  Role: outside_collaborator
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.AddMember("overwatch", "alice", github.RoleAdmin)
+	server.AddMember("overwatch", "bob", github.RoleAdmin)
+	server.AddOutsideCollaborator("overwatch", "dave")
+	man, err := github.NewManager()
+	if err != nil {
+		t.Fatal("Unable to create manager")
+	}
+	err = man.LoadConfiguration(overwatch.IamManagerConfig{
+		Additional: map[string]interface{}{
+			"GITHUB_ORG": "overwatch",
+			"HTTPClient": server.Client(),
+			"Synchro":    "local",
+			"Location":   dir,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified, err := man.ListModifiedResources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The new outside collaborator, the promoted owner and then the removed collaborator
+	expected := []string{"dave", "bob", "carol"}
+	if len(modified) != len(expected) {
+		t.Fatal("Expected", expected, "got", modified)
+	}
+	for i, resource := range modified {
+		if resource.GetType() != "Member" || resource.GetName() != expected[i] {
+			t.Fatal("Expected member", expected[i], "at position", i, "got", resource)
+		}
+	}
+	if _, err := man.Resync(); err != nil {
+		t.Fatal(err)
+	}
+	buff, err := ioutil.ReadFile(path.Join(stored, "Member.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(buff), "dave") {
+		t.Fatal("Expected the new outside collaborator to be stored, got", string(buff))
+	}
+}
+
+func TestLoosenedSettingsAreReported(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "overwatch-github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server.AddOrg("overwatch")
+	server.UpdateSettings("overwatch", func(s *fake.Settings) {
+		s.TwoFactorRequired = true
+		s.DefaultRepositoryPermission = "none"
+		s.MembersCanCreatePublicRepos = false
+	})
+	scan := func() []overwatch.IamResource {
+		man, err := github.NewManager()
+		if err != nil {
+			t.Fatal("Unable to create manager")
+		}
+		err = man.LoadConfiguration(overwatch.IamManagerConfig{
+			Additional: map[string]interface{}{
+				"GITHUB_ORG": "overwatch",
+				"HTTPClient": server.Client(),
+				"Synchro":    "local",
+				"Location":   dir,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		modified, err := man.ListModifiedResources()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := man.Resync(); err != nil {
+			t.Fatal(err)
+		}
+		return modified
+	}
+	if modified := scan(); len(modified) != 1 || modified[0].GetType() != "Setting" {
+		t.Fatal("Expected the settings to be reported as new, got", modified)
+	}
+	buff, err := ioutil.ReadFile(path.Join(dir, "Github", "overwatch", "Settings", "Setting.yml"))
+	if err != nil {
+		t.Fatal("Expected the settings to be stored", err)
+	}
+	if !strings.Contains(string(buff), "TwoFactorRequired: true") {
+		t.Fatal("Expected the two factor requirement to be stored, got", string(buff))
+	}
+	if modified := scan(); len(modified) != 0 {
+		t.Fatal("Unexpected drift for unchanged settings, got", modified)
+	}
+	for name, loosen := range map[string]func(*fake.Settings){
+		"two factor":         func(s *fake.Settings) { s.TwoFactorRequired = false },
+		"default permission": func(s *fake.Settings) { s.DefaultRepositoryPermission = "admin" },
+		"public repos":       func(s *fake.Settings) { s.MembersCanCreatePublicRepos = true },
+		"private forks":      func(s *fake.Settings) { s.MembersCanForkPrivateRepos = true },
+	} {
+		var previous fake.Settings
+		server.UpdateSettings("overwatch", func(s *fake.Settings) {
+			previous = *s
+			loosen(s)
+		})
+		modified := scan()
+		if len(modified) != 1 || modified[0].GetName() != "overwatch" {
+			t.Fatal("Expected loosening", name, "to be reported, got", modified)
+		}
+		server.UpdateSettings("overwatch", func(s *fake.Settings) { *s = previous })
+	}
+	// Settings that can not be seen must not look as if they were all turned off,
+	// nor stop the rest of the organisation from being scanned
+	server.HideSettings("overwatch")
+	server.AddRepo("overwatch", fake.Repo{Name: "hidden", Branches: map[string]bool{"master": true}})
+	modified := scan()
+	if len(modified) != 2 {
+		t.Fatal("Expected the new repo and the unavailable settings, got", modified)
+	}
+	for _, item := range modified {
+		switch item.GetType() {
+		case "Repo":
+			if item.GetName() != "hidden" {
+				t.Fatal("Expected the new repo to be reported, got", item)
+			}
+		case "Setting":
+			if !strings.Contains(fmt.Sprint(item), "two_factor_requirement_enabled") {
+				t.Fatal("Expected the hidden settings to be listed as unavailable, got", item)
+			}
+		default:
+			t.Fatal("Unexpected drift", item)
+		}
+	}
+	after, err := ioutil.ReadFile(path.Join(dir, "Github", "overwatch", "Settings", "Setting.yml"))
+	if err != nil {
+		t.Fatal("Expected the settings to stay stored", err)
+	}
+	if string(after) != string(buff) {
+		t.Fatal("Expected unavailable settings to leave the store alone, got", string(after))
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	scan()
+	if _, err := os.Stat(path.Join(dir, "Github", "overwatch", "Settings", "Setting.yml")); !os.IsNotExist(err) {
+		t.Fatal("Expected unavailable settings to never be stored, got", err)
+	}
+}
+
+func TestTeamMembershipChangesAreReported(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "overwatch-github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storeDefaultSettings(t, dir, "overwatch")
+	server.AddTeam("overwatch", fake.Team{Name: "Engineering", Slug: "engineering", Privacy: "closed", Members: map[string]string{"alice": github.TeamRoleMaintainer}})
+	server.AddTeam("overwatch", fake.Team{Name: "Backend", Slug: "backend", Parent: "engineering", Members: map[string]string{"bob": github.TeamRoleMember}})
+	server.AddTeam("overwatch", fake.Team{Name: "Design", Slug: "design"})
+	scan := func() []overwatch.IamResource {
+		man, err := github.NewManager()
+		if err != nil {
+			t.Fatal("Unable to create manager")
+		}
+		err = man.LoadConfiguration(overwatch.IamManagerConfig{
+			Additional: map[string]interface{}{
+				"GITHUB_ORG": "overwatch",
+				"HTTPClient": server.Client(),
+				"Synchro":    "local",
+				"Location":   dir,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		modified, err := man.ListModifiedResources()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := man.Resync(); err != nil {
+			t.Fatal(err)
+		}
+		return modified
+	}
+	if modified := scan(); len(modified) != 3 {
+		t.Fatal("Expected every team to be reported as new, got", modified)
+	}
+	buff, err := ioutil.ReadFile(path.Join(dir, "Github", "overwatch", "Teams", "Team.yml"))
+	if err != nil {
+		t.Fatal("Expected the teams to be stored", err)
+	}
+	if !strings.Contains(string(buff), "Parent: engineering") {
+		t.Fatal("Expected the nested team to be stored with its parent, got", string(buff))
+	}
+	for _, test := range []struct {
+		name     string
+		slug     string
+		change   func(*fake.Team)
+		expected []string
+	}{
+		{
+			name:     "member added to a child team",
+			slug:     "backend",
+			change:   func(team *fake.Team) { team.Members["carol"] = github.TeamRoleMember },
+			expected: []string{"backend", "engineering"},
+		},
+		{
+			name:     "member promoted to maintainer",
+			slug:     "engineering",
+			change:   func(team *fake.Team) { team.Members["bob"] = github.TeamRoleMaintainer },
+			expected: []string{"engineering"},
+		},
+		{
+			name:     "team moved under another",
+			slug:     "design",
+			change:   func(team *fake.Team) { team.Parent = "engineering" },
+			expected: []string{"design"},
+		},
+		{
+			name:     "team made visible",
+			slug:     "backend",
+			change:   func(team *fake.Team) { team.Privacy = "closed" },
+			expected: []string{"backend"},
+		},
+	} {
+		// The store is not changed by the drift, so each change is undone afterwards
+		previous, _ := server.Team("overwatch", test.slug)
+		if err := server.UpdateTeam("overwatch", test.slug, test.change); err != nil {
+			t.Fatal(err)
+		}
+		modified := scan()
+		server.AddTeam("overwatch", previous)
+		reported := []string{}
+		for _, resource := range modified {
+			if resource.GetType() != "Team" {
+				t.Fatal("Expected only teams to be reported for", test.name, "got", resource)
+			}
+			reported = append(reported, resource.GetName())
+		}
+		if !reflect.DeepEqual(reported, test.expected) {
+			t.Fatal("Expected", test.expected, "to be reported for", test.name, "got", reported)
+		}
+	}
+}
+
+func TestWebhookTriggersRecheck(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "overwatch-github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	server.AddRepo("overwatch", fake.Repo{Name: "service", Branches: map[string]bool{"master": true}})
+	server.AddRepo("overwatch", fake.Repo{Name: "website", Branches: map[string]bool{"master": true}})
+	server.AddTeam("overwatch", fake.Team{Name: "Engineering", Slug: "engineering"})
+	server.AddTeam("overwatch", fake.Team{Name: "Backend", Slug: "backend", Parent: "engineering"})
+	server.AddMember("overwatch", "alice", github.RoleAdmin)
+	man, err := github.NewManager()
+	if err != nil {
+		t.Fatal("Unable to create manager")
+	}
+	err = man.LoadConfiguration(overwatch.IamManagerConfig{
+		Additional: map[string]interface{}{
+			"GITHUB_ORG": "overwatch",
+			"HTTPClient": server.Client(),
+			"MaxRetries": 0,
+			"Synchro":    "local",
+			"Location":   dir,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Store everything as it is now
+	if _, err := man.Resync(); err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("It's a Secret to Everybody")
+	var (
+		alerted []overwatch.IamResource
+		failed  []error
+	)
+	handler, err := github.NewWebhookHandler(man, secret, func(drift []overwatch.IamResource) {
+		alerted = append(alerted, drift...)
+	}, func(err error) {
+		failed = append(failed, err)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliver := func(event, payload string, sign bool) int {
+		req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(payload))
+		req.Header.Set("X-GitHub-Event", event)
+		if sign {
+			mac := hmac.New(sha256.New, secret)
+			mac.Write([]byte(payload))
+			req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		handler.Wait()
+		return rec.Code
+	}
+	// Both repos drift but only the one in the event is checked
+	for _, name := range []string{"service", "website"} {
+		if err := server.UpdateRepo("overwatch", name, func(r *fake.Repo) { r.Private = true }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	payload := `{"action":"privatized","repository":{"name":"service"}}`
+	if code := deliver("repository", payload, false); code != http.StatusUnauthorized || len(alerted) != 0 {
+		t.Fatal("Expected an unsigned delivery to be rejected, got", code, alerted)
+	}
+	if code := deliver("repository", payload, true); code != http.StatusAccepted {
+		t.Fatal("Expected the delivery to be accepted, got", code)
+	}
+	if len(alerted) != 1 || alerted[0].GetType() != "Repo" || alerted[0].GetName() != "service" {
+		t.Fatal("Expected only the repo in the event to be reported, got", alerted)
+	}
+	if listed := server.PathCalls("/orgs/overwatch/teams"); listed != 1 {
+		t.Fatal("Expected the teams to only be listed by the scan, got", listed)
+	}
+	// A recheck that fails is reported after the delivery has been accepted
+	alerted = nil
+	server.FailNext(1, http.StatusInternalServerError, 0)
+	if code := deliver("repository", payload, true); code != http.StatusAccepted {
+		t.Fatal("Expected the delivery to be accepted before it is rechecked, got", code)
+	}
+	if len(failed) != 1 || len(alerted) != 0 {
+		t.Fatal("Expected the failed recheck to be reported, got", failed, alerted)
+	}
+	for _, test := range []struct {
+		event    string
+		payload  string
+		change   func()
+		expected []string
+	}{
+		{
+			event:    "ping",
+			payload:  `{"zen":"Keep it logically awesome."}`,
+			change:   func() {},
+			expected: []string{},
+		},
+		{
+			event:   "membership",
+			payload: `{"action":"added","team":{"slug":"backend"},"member":{"login":"bob"}}`,
+			change: func() {
+				server.UpdateTeam("overwatch", "backend", func(team *fake.Team) { team.Members["bob"] = github.TeamRoleMember })
+			},
+			expected: []string{"Team/backend", "Team/engineering"},
+		},
+		{
+			event:    "organization",
+			payload:  `{"action":"member_added","membership":{"user":{"login":"carol"}}}`,
+			change:   func() { server.AddMember("overwatch", "carol", github.RoleMember) },
+			expected: []string{"Member/carol"},
+		},
+		{
+			event:    "organization",
+			payload:  `{"action":"member_removed","membership":{"user":{"login":"alice"}}}`,
+			change:   func() { server.RemoveMember("overwatch", "alice") },
+			expected: []string{"Member/alice"},
+		},
+		{
+			event:   "organization",
+			payload: `{"action":"renamed"}`,
+			change: func() {
+				server.UpdateSettings("overwatch", func(s *fake.Settings) { s.MembersCanForkPrivateRepos = true })
+			},
+			expected: []string{"Setting/overwatch"},
+		},
+	} {
+		alerted = nil
+		test.change()
+		if code := deliver(test.event, test.payload, true); code != http.StatusAccepted {
+			t.Fatal("Expected the", test.event, "delivery to be accepted, got", code)
+		}
+		reported := []string{}
+		for _, resource := range alerted {
+			reported = append(reported, resource.GetType()+"/"+resource.GetName())
+		}
+		if !reflect.DeepEqual(reported, test.expected) {
+			t.Fatal("Expected", test.expected, "to be reported for", test.payload, "got", reported)
+		}
+	}
+}
+
+func TestCollaboratorsAreReportedAndEnforced(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "overwatch-github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storeDefaultSettings(t, dir, "overwatch")
+	server.AddTeam("overwatch", fake.Team{Name: "Platform", Slug: "platform", Members: map[string]string{"alice": github.TeamRoleMember}})
+	server.AddRepo("overwatch", fake.Repo{
+		Name:          "service",
+		Branches:      map[string]bool{"master": false},
+		Collaborators: map[string]string{"alice": "admin", "bob": "admin", "carol": "push"},
+	})
+	load := func(enforce bool) overwatch.IamPolicyManager {
+		man, err := github.NewManager()
+		if err != nil {
+			t.Fatal("Unable to create manager")
+		}
+		err = man.LoadConfiguration(overwatch.IamManagerConfig{
+			Additional: map[string]interface{}{
+				"Enforce":    enforce,
+				"GITHUB_ORG": "overwatch",
+				"HTTPClient": server.Client(),
+				"Synchro":    "local",
+				"Location":   dir,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return man
+	}
+	// Store everything as it is now
+	if _, err := load(false).Resync(); err != nil {
+		t.Fatal(err)
+	}
+	stored := path.Join(dir, "Github", "overwatch", "Repos", "Repo.yml")
+	buff, err := ioutil.ReadFile(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the admin who is not in any team is flagged
+	if !strings.Contains(string(buff), "Login: bob\n    Permission: admin\n    OutsideTeams: true") ||
+		strings.Contains(string(buff), "Login: alice\n    Permission: admin\n    OutsideTeams: true") {
+		t.Fatal("Expected only the admin outside of every team to be flagged, got", string(buff))
+	}
+	// The stored configuration takes away the grant and adds another
+	err = ioutil.WriteFile(stored, []byte(`---
+- Name: service
+  Public: true
+  Collaborators:
+    - Login: alice
+      Permission: admin
+    - Login: bob
+      Permission: pull
+    - Login: dave
+      Permission: triage
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	man := load(true)
+	modified, err := man.ListModifiedResources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modified) != 1 || modified[0].GetName() != "service" {
+		t.Fatal("Expected the change in collaborators to be reported, got", modified)
+	}
+	if _, err := man.Resync(); err != nil {
+		t.Fatal(err)
+	}
+	repo, _ := server.Repo("overwatch", "service")
+	expected := map[string]string{"alice": "admin", "bob": "pull", "dave": "triage"}
+	if !reflect.DeepEqual(repo.Collaborators, expected) {
+		t.Fatal("Expected the stored collaborators to be enforced, got", repo.Collaborators)
+	}
+	if modified, err = man.ListModifiedResources(); err != nil || len(modified) != 0 {
+		t.Fatal("Expected no drift once the collaborators were enforced, got", modified, err)
+	}
+	// A store without collaborators keeps them, an empty list revokes everyone
+	for _, test := range []struct {
+		collaborators string
+		expected      map[string]string
+	}{
+		{"", expected},
+		{"  Collaborators: []\n", map[string]string{}},
+	} {
+		content := "---\n- Name: service\n  Public: true\n" + test.collaborators
+		if err := ioutil.WriteFile(stored, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := load(true).Resync(); err != nil {
+			t.Fatal(err)
+		}
+		if repo, _ := server.Repo("overwatch", "service"); !reflect.DeepEqual(repo.Collaborators, test.expected) {
+			t.Fatal("Expected the collaborators", test.expected, "for", content, "got", repo.Collaborators)
+		}
+	}
+}
+
+func TestWeakenedProtectionIsReported(t *testing.T) {
+	protection := fake.Protection{
+		RequiredReviews:         2,
+		DismissStaleReviews:     true,
+		RequireCodeOwnerReviews: true,
+		RequiredStatusChecks:    []string{"ci/build", "ci/test"},
+		StrictStatusChecks:      true,
+		EnforceAdmins:           true,
+		RestrictPushes:          true,
+		PushTeams:               []string{"release"},
+		RequireLinearHistory:    true,
+	}
+	stored := `---
+- Name: service
+  Public: false
+  Protected:
+    - Branch: master
+      RequiredReviews: 2
+      DismissStaleReviews: true
+      RequireCodeOwnerReviews: true
+      RequiredStatusChecks: [ci/test, ci/build]
+      StrictStatusChecks: true
+      EnforceAdmins: true
+      RestrictPushes: true
+      PushTeams: [release]
+      RequireLinearHistory: true
+      AllowForcePushes: false
+      AllowDeletions: false
+`
+	weaken := map[string]func(*fake.Protection){
+		"unchanged":          func(p *fake.Protection) {},
+		"fewer reviews":      func(p *fake.Protection) { p.RequiredReviews = 1 },
+		"stale reviews kept": func(p *fake.Protection) { p.DismissStaleReviews = false },
+		"no code owners":     func(p *fake.Protection) { p.RequireCodeOwnerReviews = false },
+		"dropped check":      func(p *fake.Protection) { p.RequiredStatusChecks = []string{"ci/build"} },
+		"admins exempt":      func(p *fake.Protection) { p.EnforceAdmins = false },
+		"anyone can push":    func(p *fake.Protection) { p.RestrictPushes = false },
+		"merge commits":      func(p *fake.Protection) { p.RequireLinearHistory = false },
+		"force pushes":       func(p *fake.Protection) { p.AllowForcePushes = true },
+		"deletions":          func(p *fake.Protection) { p.AllowDeletions = true },
+	}
+	for name, change := range weaken {
+		for _, backend := range []string{github.BackendREST, github.BackendGraphQL} {
+			server := fake.NewServer()
+			actual := protection
+			change(&actual)
+			server.AddRepo("overwatch", fake.Repo{
+				Name:       "service",
+				Private:    true,
+				Branches:   map[string]bool{"master": true},
+				Protection: map[string]fake.Protection{"master": actual},
+			})
+			dir, err := ioutil.TempDir("", "overwatch-github")
+			if err != nil {
+				t.Fatal(err)
+			}
+			storeDefaultSettings(t, dir, "overwatch")
+			repos := path.Join(dir, "Github", "overwatch", "Repos")
+			if err := os.MkdirAll(repos, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path.Join(repos, "Repo.yml"), []byte(stored), 0644); err != nil {
+				t.Fatal(err)
+			}
+			man, err := github.NewManager()
+			if err != nil {
+				t.Fatal("Unable to create manager")
+			}
+			err = man.LoadConfiguration(overwatch.IamManagerConfig{
+				Additional: map[string]interface{}{
+					"Backend":    backend,
+					"GITHUB_ORG": "overwatch",
+					"HTTPClient": server.Client(),
+					"Synchro":    "local",
+					"Location":   dir,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			modified, err := man.ListModifiedResources()
+			server.Close()
+			os.RemoveAll(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reported := len(modified) != 0; reported != (name != "unchanged") {
+				t.Fatal("Unexpected drift for", name, "using", backend, "got", modified)
+			}
+		}
+	}
+}
+
+func TestRequirementsWithoutValuesAreEnforced(t *testing.T) {
+	for _, backend := range []string{github.BackendREST, github.BackendGraphQL} {
+		server := fake.NewServer()
+		defer server.Close()
+		dir, err := ioutil.TempDir("", "overwatch-github")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		storeDefaultSettings(t, dir, "overwatch")
+		server.AddRepo("overwatch", fake.Repo{
+			Name:       "service",
+			Private:    true,
+			Branches:   map[string]bool{"master": true},
+			Protection: map[string]fake.Protection{"master": {EnforceAdmins: true}},
+		})
+		repos := path.Join(dir, "Github", "overwatch", "Repos")
+		if err := os.MkdirAll(repos, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path.Join(repos, "Repo.yml"), []byte(`---
+- Name: service
+  Public: false
+  Protected:
+    - Branch: master
+      RequirePullRequestReviews: true
+      RequiredReviews: 0
+      RequireStatusChecks: true
+      RequiredStatusChecks: []
+      EnforceAdmins: true
+`), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		man, err := github.NewManager()
+		if err != nil {
+			t.Fatal("Unable to create manager")
+		}
+		err = man.LoadConfiguration(overwatch.IamManagerConfig{
+			Additional: map[string]interface{}{
+				"Enforce":    true,
+				"Backend":    backend,
+				"GITHUB_ORG": "overwatch",
+				"HTTPClient": server.Client(),
+				"Synchro":    "local",
+				"Location":   dir,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if modified, err := man.ListModifiedResources(); err != nil || len(modified) != 1 {
+			t.Fatal("Expected the missing requirements to be reported by", backend, "got", modified, err)
+		}
+		if _, err := man.Resync(); err != nil {
+			t.Fatal(err)
+		}
+		repo, _ := server.Repo("overwatch", "service")
+		if master := repo.Protection["master"]; !master.RequireReviews || master.RequiredStatusChecks == nil {
+			t.Fatal("Expected reviews and status checks to be required, got", master)
+		}
+		if modified, err := man.ListModifiedResources(); err != nil || len(modified) != 0 {
+			t.Fatal("Expected no drift after resyncing with", backend, "got", modified, err)
+		}
+	}
+}
+
+func TestLegacyProtectionIsNotEnforced(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "overwatch-github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storeDefaultSettings(t, dir, "overwatch")
+	server.AddRepo("overwatch", fake.Repo{
+		Name:       "service",
+		Private:    true,
+		Branches:   map[string]bool{"master": true, "develop": false, "release/1.0": true},
+		Protection: map[string]fake.Protection{"master": {RequiredReviews: 2, EnforceAdmins: true}},
+	})
+	repos := path.Join(dir, "Github", "overwatch", "Repos")
+	if err := os.MkdirAll(repos, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path.Join(repos, "Repo.yml"), []byte(`---
+- Name: service
+  Public: false
+  Protected:
+    - master
+    - develop
+    - Branch: release/1.0
+      RequiredReviews: 1
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	man, err := github.NewManager()
+	if err != nil {
+		t.Fatal("Unable to create manager")
+	}
+	err = man.LoadConfiguration(overwatch.IamManagerConfig{
+		Additional: map[string]interface{}{
+			"Enforce":    true,
+			"GITHUB_ORG": "overwatch",
+			"HTTPClient": server.Client(),
+			"Synchro":    "local",
+			"Location":   dir,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := man.Resync(); err != nil {
+		t.Fatal(err)
+	}
+	repo, _ := server.Repo("overwatch", "service")
+	if master := repo.Protection["master"]; master.RequiredReviews != 2 || !master.EnforceAdmins {
+		t.Fatal("Expected the protection of a branch stored by name to be left alone, got", master)
+	}
+	if release := repo.Protection["release/1.0"]; release.RequiredReviews != 1 {
+		t.Fatal("Expected the stored protection to be applied to the release branch, got", release)
+	}
+	// Without knowing what develop was protected by it is reported rather than protected
+	if repo.Branches["develop"] {
+		t.Fatal("Expected develop to be left unprotected")
+	}
+	if modified, err := man.ListModifiedResources(); err != nil || len(modified) != 1 {
+		t.Fatal("Expected only the unprotected develop branch to be reported, got", modified, err)
+	}
+}
+
+func TestResyncFollowsEnforcementPolicy(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "overwatch-github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"service", "legacy"} {
+		server.AddRepo("overwatch", fake.Repo{
+			Name:       name,
+			Branches:   map[string]bool{"master": false, "develop": true},
+			Protection: map[string]fake.Protection{"develop": {AllowForcePushes: true}},
+		})
+	}
+	stored := path.Join(dir, "Github", "overwatch", "Repos")
+	if err := os.MkdirAll(stored, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(path.Join(stored, "Repo.yml"), []byte(`---
+- Name: service
+  Public: false
+  Protected:
+    - Branch: master
+      RequiredReviews: 2
+      RequiredStatusChecks: [ci/build]
+      EnforceAdmins: true
+- Name: legacy
+  Public: false
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := overwatch.IamManagerConfig{
+		Additional: map[string]interface{}{
+			"Enforcement": map[string]string{"Default": "enforce", "Repo/legacy": "alert"},
+			"GITHUB_ORG":  "overwatch",
+			"HTTPClient":  server.Client(),
+			"Synchro":     "local",
+			"Location":    dir,
+		},
+	}
+	man, err := github.NewManager()
+	if err != nil {
+		t.Fatal("Unable to create manager")
+	}
+	if err := man.LoadConfiguration(config); err == nil {
+		t.Fatal("Expected an unknown enforcement policy to be rejected")
+	}
+	config.Additional["Enforcement"] = map[string]string{"Default": "enforce", "Repo/legacy": "alert-only"}
+	if err := man.LoadConfiguration(config); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := man.Resync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 2 {
+		t.Fatal("Expected both repos to be reported, got", changed)
+	}
+	service, _ := server.Repo("overwatch", "service")
+	expected := fake.Protection{RequireReviews: true, RequiredReviews: 2, RequiredStatusChecks: []string{"ci/build"}, EnforceAdmins: true}
+	switch {
+	case !service.Private:
+		t.Fatal("Expected service to have been made private")
+	case !service.Branches["master"] || !reflect.DeepEqual(service.Protection["master"], expected):
+		t.Fatal("Expected master to have the stored protection, got", service.Protection["master"])
+	case service.Branches["develop"]:
+		t.Fatal("Expected the protection of develop to be removed")
+	}
+	legacy, _ := server.Repo("overwatch", "legacy")
+	if legacy.Private || !legacy.Branches["develop"] {
+		t.Fatal("Expected the alert-only repo to be left alone")
+	}
+	modified, err := man.ListModifiedResources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modified) != 1 || modified[0].GetName() != "legacy" {
+		t.Fatal("Expected only the alert-only repo to still drift, got", modified)
+	}
+}
+
+func TestAppAuthentication(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	other, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		key      *rsa.PublicKey
+		lifetime time.Duration
+		fails    bool
+		tokens   func(int) bool
+	}{
+		// Tokens are reused until they are close to expiring
+		{name: "reused", key: &key.PublicKey, lifetime: time.Hour, tokens: func(n int) bool { return n == 1 }},
+		{name: "refreshed", key: &key.PublicKey, lifetime: 5 * time.Minute, tokens: func(n int) bool { return n > 1 }},
+		{name: "wrong key", key: &other.PublicKey, lifetime: time.Hour, fails: true, tokens: func(n int) bool { return n == 0 }},
+	}
+	for _, test := range tests {
+		server := fake.NewServer()
+		server.AddRepo("overwatch", fake.Repo{Name: "service", Branches: map[string]bool{"master": true}})
+		server.EnableApp(42, test.key, test.lifetime)
+		dir, err := ioutil.TempDir("", "overwatch-github")
+		if err != nil {
+			t.Fatal(err)
+		}
+		man, err := github.NewManager()
+		if err != nil {
+			t.Fatal("Unable to create manager")
+		}
+		err = man.LoadConfiguration(overwatch.IamManagerConfig{
+			Additional: map[string]interface{}{
+				"GITHUB_APP_ID":          "42",
+				"GITHUB_APP_PRIVATE_KEY": pemKey,
+				"GITHUB_ORG":             "overwatch",
+				"HTTPClient":             server.Client(),
+				"MaxRetries":             0,
+				"Synchro":                "local",
+				"Location":               dir,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for scan := 0; scan < 2; scan++ {
+			if _, err = man.ListModifiedResources(); err != nil {
+				break
+			}
+		}
+		issued := server.TokensIssued()
+		server.Close()
+		os.RemoveAll(dir)
+		if (err != nil) != test.fails {
+			t.Fatal("Unexpected result for", test.name, "got", err)
+		}
+		if !test.tokens(issued) {
+			t.Fatal("Unexpected number of installation tokens for", test.name, "got", issued)
+		}
+	}
+}
+
+func TestInvalidConfigIsMisconfigured(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddOrg("overwatch")
+	dir, err := ioutil.TempDir("", "overwatch-github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := map[string]map[string]interface{}{
+		"app id":            {"GITHUB_APP_ID": "forty-two", "GITHUB_APP_PRIVATE_KEY": pemKey},
+		"app id type":       {"GITHUB_APP_ID": 42.0, "GITHUB_APP_PRIVATE_KEY": pemKey},
+		"installation id":   {"GITHUB_APP_ID": "42", "GITHUB_APP_PRIVATE_KEY": pemKey, "GITHUB_APP_INSTALLATION_ID": "seven"},
+		"private key":       {"GITHUB_APP_ID": "42"},
+		"rate limit policy": {"RateLimitPolicy": "sleep"},
+	}
+	for name, additional := range tests {
+		man, err := github.NewManager()
+		if err != nil {
+			t.Fatal("Unable to create manager")
+		}
+		// A token is also given to make sure it is not used instead of the app
+		config := map[string]interface{}{
+			"GITHUB_TOKEN": "token",
+			"GITHUB_ORG":   "overwatch",
+			"HTTPClient":   server.Client(),
+			"Synchro":      "local",
+			"Location":     dir,
+		}
+		for k, v := range additional {
+			config[k] = v
+		}
+		if err := man.LoadConfiguration(overwatch.IamManagerConfig{Additional: config}); !errors.Is(err, overwatch.ErrMisconfigured) {
+			t.Fatal("Expected an invalid", name, "to be misconfigured, got", err)
+		}
+		if _, err := man.ListModifiedResources(); err != overwatch.ErrMisconfigured {
+			t.Fatal("Expected the manager to stay unconfigured after an invalid", name, "got", err)
+		}
+	}
+}
+
+func TestEnterpriseServer(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "overwatch-github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// More repos than fit on a single page of the v3 API
+	for i := 0; i < 70; i++ {
+		server.AddRepo("overwatch", fake.Repo{Name: fmt.Sprintf("repo-%02d", i), Branches: map[string]bool{"master": true}})
+	}
+	// The same organisation name already stored for github.com
+	public := path.Join(dir, "Github", "overwatch", "Repos")
+	if err := os.MkdirAll(public, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(public, "Repo.yml"), []byte("---\n- Name: website\n  Public: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var man overwatch.IamPolicyManager
+	for _, backend := range []string{github.BackendREST, github.BackendGraphQL} {
+		man, err = github.NewManager()
+		if err != nil {
+			t.Fatal("Unable to create manager")
+		}
+		err = man.LoadConfiguration(overwatch.IamManagerConfig{
+			Additional: map[string]interface{}{
+				"Backend":         backend,
+				"GITHUB_BASE_URL": "https://ghe.example.com",
+				"GITHUB_ORG":      "overwatch",
+				"HTTPClient":      server.Client(),
+				"Synchro":         "local",
+				"Location":        dir,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		modified, err := man.ListModifiedResources()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Nothing from github.com should be seen as removed,
+		// only the enterprise repos and organisation settings are new
+		if len(modified) != 71 {
+			t.Fatal("Expected only the enterprise repos using", backend, "got", len(modified))
+		}
+	}
+	if _, err := man.Resync(); err != nil {
+		t.Fatal(err)
+	}
+	if hosts := server.Hosts(); !reflect.DeepEqual(hosts, []string{"ghe.example.com"}) {
+		t.Fatal("Expected every request to go to the enterprise server, got", hosts)
+	}
+	if _, err := os.Stat(path.Join(dir, "Github", "ghe.example.com", "overwatch", "Repos", "Repo.yml")); err != nil {
+		t.Fatal("Expected the enterprise repos to be stored under the host", err)
+	}
+}
+
+func TestDeployKeysAndWebhooksAreReported(t *testing.T) {
+	stored := `---
+- Name: service
+  Public: true
+  DeployKeys:
+    - Title: ci
+      Fingerprint: SHA256:TEW4I9ln01qKq24I191qLSwzMEKnZQkVEgKhm+53Ko8
+      ReadOnly: true
+  Webhooks:
+    - Host: hooks.example.com
+      Events: [push]
+      Active: true
+`
+	known := fake.Repo{
+		Name:       "service",
+		DeployKeys: []fake.DeployKey{{Title: "ci", Key: deployKey, ReadOnly: true}},
+		Hooks:      []fake.Hook{{URL: "https://hooks.example.com/overwatch", Events: []string{"push"}, Active: true}},
+	}
+	tests := map[string]func(*fake.Repo){
+		"unchanged": func(r *fake.Repo) {},
+		"write key": func(r *fake.Repo) { r.DeployKeys[0].ReadOnly = false },
+		"new key": func(r *fake.Repo) {
+			r.DeployKeys = append(r.DeployKeys, fake.DeployKey{Title: "laptop", Key: "ssh-rsa AAAAB3NzaC1yc2E= laptop"})
+		},
+		"new hook": func(r *fake.Repo) {
+			r.Hooks = append(r.Hooks, fake.Hook{URL: "https://collector.example.net/", Events: []string{"*"}, Active: true})
+		},
+		"more events": func(r *fake.Repo) { r.Hooks[0].Events = []string{"push", "repository"} },
+	}
+	for name, change := range tests {
+		for _, backend := range []string{github.BackendREST, github.BackendGraphQL} {
+			server := fake.NewServer()
+			repo := known
+			repo.DeployKeys = append([]fake.DeployKey{}, known.DeployKeys...)
+			repo.Hooks = append([]fake.Hook{}, known.Hooks...)
+			change(&repo)
+			server.AddRepo("overwatch", repo)
+			dir, err := ioutil.TempDir("", "overwatch-github")
+			if err != nil {
+				t.Fatal(err)
+			}
+			storeDefaultSettings(t, dir, "overwatch")
+			repos := path.Join(dir, "Github", "overwatch", "Repos")
+			if err := os.MkdirAll(repos, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path.Join(repos, "Repo.yml"), []byte(stored), 0644); err != nil {
+				t.Fatal(err)
+			}
+			man, err := github.NewManager()
+			if err != nil {
+				t.Fatal("Unable to create manager")
+			}
+			err = man.LoadConfiguration(overwatch.IamManagerConfig{
+				Additional: map[string]interface{}{
+					"Backend":    backend,
+					"GITHUB_ORG": "overwatch",
+					"HTTPClient": server.Client(),
+					"Synchro":    "local",
+					"Location":   dir,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			modified, err := man.ListModifiedResources()
+			server.Close()
+			os.RemoveAll(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reported := len(modified) != 0; reported != (name != "unchanged") {
+				t.Fatal("Unexpected drift for", name, "using", backend, "got", modified)
+			}
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package fake
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// app is a Github App installed in every organisation of the fake
+type app struct {
+	id       int64
+	key      *rsa.PublicKey
+	lifetime time.Duration
+	// installations maps the organisation to the installation ID
+	installations map[string]int64
+	// tokens maps the installation tokens handed out to when they expire
+	tokens map[string]time.Time
+}
+
+// EnableApp installs a Github App into every organisation. Once enabled
+// the fake only accepts requests authenticated with a JWT signed by key
+// or an unexpired installation token, which last for lifetime.
+func (s *Server) EnableApp(id int64, key *rsa.PublicKey, lifetime time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.app = &app{
+		id:            id,
+		key:           key,
+		lifetime:      lifetime,
+		installations: map[string]int64{},
+		tokens:        map[string]time.Time{},
+	}
+}
+
+// TokensIssued returns the number of installation tokens created
+func (s *Server) TokensIssued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.app == nil {
+		return 0
+	}
+	return len(s.app.tokens)
+}
+
+// authenticate checks the credentials of the request when an app is enabled,
+// the caller must hold mu. It returns false once it has written an error.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) bool {
+	if s.app == nil {
+		return true
+	}
+	scheme, credential := "", ""
+	if parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2); len(parts) == 2 {
+		scheme, credential = strings.ToLower(parts[0]), parts[1]
+	}
+	// Only the app endpoints accept a JWT
+	if strings.HasPrefix(r.URL.Path, "/app/") || strings.HasSuffix(r.URL.Path, "/installation") {
+		if scheme != "bearer" {
+			writeError(w, http.StatusUnauthorized, "A JSON web token could not be decoded")
+			return false
+		}
+		if err := s.app.verify(credential); err != nil {
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return false
+		}
+		return true
+	}
+	expires, exist := s.app.tokens[credential]
+	if (scheme != "token" && scheme != "bearer") || !exist || time.Now().After(expires) {
+		writeError(w, http.StatusUnauthorized, "Bad credentials")
+		return false
+	}
+	return true
+}
+
+// verify checks the JWT was signed by the app and is still valid
+func (a *app) verify(token string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("A JSON web token could not be decoded")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("A JSON web token could not be decoded")
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(a.key, crypto.SHA256, sum[:], signature); err != nil {
+		return fmt.Errorf("A JSON web token could not be decoded")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("A JSON web token could not be decoded")
+	}
+	var claims struct {
+		Iat int64 `json:"iat"`
+		Exp int64 `json:"exp"`
+		Iss int64 `json:"iss"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Iss != a.id {
+		return fmt.Errorf("'Issuer' claim ('iss') must be an Integer")
+	}
+	now := time.Now().Unix()
+	if claims.Exp < now || claims.Exp-claims.Iat > int64((10*time.Minute)/time.Second) {
+		return fmt.Errorf("'Expiration time' claim ('exp') is too far in the future")
+	}
+	return nil
+}
+
+// handleApp serves POST /app/installations/<id>/access_tokens
+func (s *Server) handleApp(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 4 || parts[1] != "installations" || parts[3] != "access_tokens" || r.Method != http.MethodPost {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	id, _ := strconv.ParseInt(parts[2], 10, 64)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.app == nil {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	installed := false
+	for _, installation := range s.app.installations {
+		installed = installed || installation == id
+	}
+	if !installed {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	s.nextID++
+	token := fmt.Sprintf("ghs_fake%d", s.nextID)
+	expires := time.Now().Add(s.app.lifetime)
+	s.app.tokens[token] = expires
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"token":      token,
+		"expires_at": expires.UTC().Format(time.RFC3339),
+	})
+}
+
+// getInstallation serves /orgs/<org>/installation
+func (s *Server) getInstallation(w http.ResponseWriter, r *http.Request, login string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exist := s.orgs[login]; !exist || s.app == nil {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	id, exist := s.app.installations[login]
+	if !exist {
+		s.nextID++
+		id = s.nextID
+		s.app.installations[login] = id
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":     id,
+		"app_id": s.app.id,
+		"account": map[string]interface{}{
+			"login": login,
+		},
+	})
+}
@@ -0,0 +1,79 @@
+package fake
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// permissionLevels lists the repo permissions from the least to the most access
+var permissionLevels = []string{"pull", "triage", "push", "maintain", "admin"}
+
+// permissionsJSON reports every permission included in the permission,
+// the same way Github describes the access of a collaborator.
+func permissionsJSON(permission string) map[string]bool {
+	permissions := map[string]bool{}
+	held := true
+	for _, level := range permissionLevels {
+		permissions[level] = held
+		if level == permission {
+			held = false
+		}
+	}
+	return permissions
+}
+
+// listCollaborators serves /repos/<owner>/<repo>/collaborators,
+// only the users given access directly are known to the fake.
+func (s *Server) listCollaborators(w http.ResponseWriter, r *http.Request, owner, name string) {
+	s.mu.Lock()
+	repo, exist := s.lookupRepo(owner, name)
+	if !exist {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	items := []interface{}{}
+	for _, login := range sortedKeys(repo.Collaborators) {
+		item := userJSON(login)
+		item["permissions"] = permissionsJSON(repo.Collaborators[login])
+		items = append(items, item)
+	}
+	s.mu.Unlock()
+	writePage(w, r, items)
+}
+
+// handleCollaborator serves PUT and DELETE /repos/<owner>/<repo>/collaborators/<user>,
+// users are added straight away rather than being sent an invitation.
+func (s *Server) handleCollaborator(w http.ResponseWriter, r *http.Request, owner, name, login string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, exist := s.lookupRepo(owner, name)
+	if !exist {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		var body struct {
+			Permission string `json:"permission"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Permission == "" {
+			body.Permission = "push"
+		}
+		if _, valid := graphqlPermissions[body.Permission]; !valid {
+			writeError(w, http.StatusUnprocessableEntity, "Validation Failed")
+			return
+		}
+		if repo.Collaborators == nil {
+			repo.Collaborators = map[string]string{}
+		}
+		repo.Collaborators[login] = body.Permission
+	case http.MethodDelete:
+		delete(repo.Collaborators, login)
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,198 @@
+package fake
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// graphqlPermissions converts the REST permission names into the
+// RepositoryPermission enum used by the GraphQL API.
+var graphqlPermissions = map[string]string{
+	"pull":     "READ",
+	"triage":   "TRIAGE",
+	"push":     "WRITE",
+	"maintain": "MAINTAIN",
+	"admin":    "ADMIN",
+}
+
+// handleGraphQL serves the small set of GraphQL queries the manager makes.
+// The fake does not parse GraphQL, it looks at which connection the query asks
+// for and answers using the variables organisation, slug, name, id, first, nested and cursor.
+// Queries that follow up a single connection are answered with each connection
+// of the repository or rule paged the same way, only the one asked for is read.
+func (s *Server) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+	login, _ := body.Variables["organisation"].(string)
+	cursor, _ := body.Variables["cursor"].(string)
+	first, nested := 100, 100
+	if f, ok := body.Variables["first"].(float64); ok {
+		first = int(f)
+	}
+	if n, ok := body.Variables["nested"].(float64); ok {
+		nested = int(n)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if strings.Contains(body.Query, "node(id:") {
+		id, _ := body.Variables["id"].(string)
+		var node interface{}
+		if parts := strings.SplitN(strings.TrimPrefix(id, "rule:"), "/", 3); len(parts) == 3 {
+			if o, exist := s.orgs[parts[0]]; exist {
+				if repo, exist := o.repos[parts[1]]; exist && repo.Branches[parts[2]] {
+					node = protectionRuleJSON(id, parts[2], repo.Protection[parts[2]], first, cursor)
+				}
+			}
+		}
+		if node == nil {
+			writeGraphQLError(w, "Could not resolve to a node with the global id of '"+id+"'")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"node": node}})
+		return
+	}
+	o, exist := s.orgs[login]
+	if !exist {
+		writeGraphQLError(w, "Could not resolve to an Organization with the login of '"+login+"'.")
+		return
+	}
+	var data interface{}
+	switch {
+	case strings.Contains(body.Query, "repository(owner:"):
+		name, _ := body.Variables["name"].(string)
+		repo, exist := o.repos[name]
+		if !exist {
+			writeGraphQLError(w, "Could not resolve to a Repository with the name '"+login+"/"+name+"'.")
+			return
+		}
+		data = map[string]interface{}{"repository": repositoryJSON(login, repo, first, cursor, nested)}
+	case strings.Contains(body.Query, "team(slug:"):
+		slug, _ := body.Variables["slug"].(string)
+		team, exist := o.teams[slug]
+		if !exist {
+			writeGraphQLError(w, "Could not resolve to a Team with the slug of '"+slug+"'.")
+			return
+		}
+		data = map[string]interface{}{"organization": map[string]interface{}{
+			"team": map[string]interface{}{"repositories": teamRepositories(team, first, cursor)},
+		}}
+	case strings.Contains(body.Query, "teams("):
+		slugs := sortedKeys(o.teams)
+		start, end, info := connection(len(slugs), first, cursor)
+		nodes := []interface{}{}
+		for _, slug := range slugs[start:end] {
+			nodes = append(nodes, map[string]interface{}{
+				"slug":         slug,
+				"repositories": teamRepositories(o.teams[slug], first, ""),
+			})
+		}
+		data = map[string]interface{}{"organization": map[string]interface{}{
+			"teams": map[string]interface{}{"pageInfo": info, "nodes": nodes},
+		}}
+	case strings.Contains(body.Query, "repositories("):
+		names := sortedKeys(o.repos)
+		start, end, info := connection(len(names), first, cursor)
+		nodes := []interface{}{}
+		for _, name := range names[start:end] {
+			nodes = append(nodes, repositoryJSON(login, o.repos[name], nested, "", nested))
+		}
+		data = map[string]interface{}{"organization": map[string]interface{}{
+			"repositories": map[string]interface{}{"pageInfo": info, "nodes": nodes},
+		}}
+	default:
+		writeGraphQLError(w, "Query is not supported by the fake")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+// repositoryJSON renders the repository with each of its connections paged by
+// first and cursor, the connections within branch protection rules are paged by nested.
+func repositoryJSON(login string, repo *Repo, first int, cursor string, nested int) map[string]interface{} {
+	branches := []string{}
+	for _, branch := range sortedKeys(repo.Branches) {
+		if repo.Branches[branch] {
+			branches = append(branches, branch)
+		}
+	}
+	start, end, rulesInfo := connection(len(branches), first, cursor)
+	rules := []interface{}{}
+	for _, branch := range branches[start:end] {
+		id := "rule:" + login + "/" + repo.Name + "/" + branch
+		rules = append(rules, protectionRuleJSON(id, branch, repo.Protection[branch], nested, ""))
+	}
+	start, end, keysInfo := connection(len(repo.DeployKeys), first, cursor)
+	keys := []interface{}{}
+	for _, key := range repo.DeployKeys[start:end] {
+		keys = append(keys, map[string]interface{}{
+			"title":    key.Title,
+			"key":      key.Key,
+			"readOnly": key.ReadOnly,
+		})
+	}
+	logins := sortedKeys(repo.Collaborators)
+	start, end, collaboratorsInfo := connection(len(logins), first, cursor)
+	collaborators := []interface{}{}
+	for _, login := range logins[start:end] {
+		collaborators = append(collaborators, map[string]interface{}{
+			"permission": graphqlPermissions[repo.Collaborators[login]],
+			"node":       map[string]interface{}{"login": login},
+		})
+	}
+	return map[string]interface{}{
+		"name":                  repo.Name,
+		"isPrivate":             repo.Private,
+		"branchProtectionRules": map[string]interface{}{"pageInfo": rulesInfo, "nodes": rules},
+		"deployKeys":            map[string]interface{}{"pageInfo": keysInfo, "nodes": keys},
+		"collaborators":         map[string]interface{}{"pageInfo": collaboratorsInfo, "edges": collaborators},
+	}
+}
+
+func teamRepositories(team *Team, first int, cursor string) map[string]interface{} {
+	names := sortedKeys(team.Repos)
+	start, end, info := connection(len(names), first, cursor)
+	edges := []interface{}{}
+	for _, name := range names[start:end] {
+		edges = append(edges, map[string]interface{}{
+			"permission": graphqlPermissions[team.Repos[name]],
+			"node":       map[string]interface{}{"name": name},
+		})
+	}
+	return map[string]interface{}{"pageInfo": info, "edges": edges}
+}
+
+// connection works out the slice of a connection to return and its page info,
+// cursors are the index of the first item on the following page.
+func connection(total, first int, cursor string) (int, int, map[string]interface{}) {
+	start, _ := strconv.Atoi(cursor)
+	if start > total {
+		start = total
+	}
+	end := start + first
+	if end > total {
+		end = total
+	}
+	return start, end, map[string]interface{}{
+		"hasNextPage": end < total,
+		"endCursor":   strconv.Itoa(end),
+	}
+}
+
+func writeGraphQLError(w http.ResponseWriter, message string) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":   nil,
+		"errors": []interface{}{map[string]string{"message": message}},
+	})
+}
@@ -0,0 +1,102 @@
+package fake
+
+import (
+	"net/http"
+)
+
+// AddMember adds the login to the organisation with the role, admin or member
+func (s *Server) AddMember(org, login, role string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := s.org(org)
+	delete(o.outside, login)
+	o.members[login] = role
+}
+
+// AddOutsideCollaborator grants the login access to the
+// organisation without it being a member
+func (s *Server) AddOutsideCollaborator(org, login string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := s.org(org)
+	delete(o.members, login)
+	o.outside[login] = true
+}
+
+// RemoveMember removes the login from the organisation,
+// whether it is a member or an outside collaborator.
+func (s *Server) RemoveMember(org, login string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := s.org(org)
+	delete(o.members, login)
+	delete(o.outside, login)
+}
+
+// getMembership serves /orgs/<org>/memberships/<user>,
+// outside collaborators are not members so are not found.
+func (s *Server) getMembership(w http.ResponseWriter, r *http.Request, org, login string) {
+	s.mu.Lock()
+	o, exist := s.orgs[org]
+	var role string
+	if exist {
+		role, exist = o.members[login]
+	}
+	s.mu.Unlock()
+	if !exist {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"state": "active",
+		"role":  role,
+		"user":  userJSON(login),
+	})
+}
+
+func userJSON(login string) map[string]interface{} {
+	return map[string]interface{}{
+		"login": login,
+		"type":  "User",
+	}
+}
+
+// listMembers serves /orgs/<org>/members filtered by the role query
+func (s *Server) listMembers(w http.ResponseWriter, r *http.Request, login string) {
+	role := r.URL.Query().Get("role")
+	if role == "" {
+		role = "all"
+	}
+	s.mu.Lock()
+	o, exist := s.orgs[login]
+	if !exist {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	items := []interface{}{}
+	for _, user := range sortedKeys(o.members) {
+		if role == "all" || o.members[user] == role {
+			items = append(items, userJSON(user))
+		}
+	}
+	s.mu.Unlock()
+	writePage(w, r, items)
+}
+
+// listOutsideCollaborators serves /orgs/<org>/outside_collaborators
+func (s *Server) listOutsideCollaborators(w http.ResponseWriter, r *http.Request, login string) {
+	s.mu.Lock()
+	o, exist := s.orgs[login]
+	if !exist {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	items := []interface{}{}
+	for _, user := range sortedKeys(o.outside) {
+		items = append(items, userJSON(user))
+	}
+	s.mu.Unlock()
+	writePage(w, r, items)
+}
@@ -0,0 +1,165 @@
+package fake
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+func enabled(value bool) map[string]interface{} {
+	return map[string]interface{}{"enabled": value}
+}
+
+func (p Protection) reviewsRequired() bool {
+	return p.RequireReviews || p.RequiredReviews > 0
+}
+
+// protectionJSON renders the protection the same way the v3 API does,
+// settings that are not required are left out of the response.
+func protectionJSON(p Protection) map[string]interface{} {
+	body := map[string]interface{}{
+		"enforce_admins":          enabled(p.EnforceAdmins),
+		"required_linear_history": enabled(p.RequireLinearHistory),
+		"allow_force_pushes":      enabled(p.AllowForcePushes),
+		"allow_deletions":         enabled(p.AllowDeletions),
+	}
+	if p.RequiredStatusChecks != nil {
+		body["required_status_checks"] = map[string]interface{}{
+			"strict":   p.StrictStatusChecks,
+			"contexts": p.RequiredStatusChecks,
+		}
+	}
+	if p.reviewsRequired() {
+		body["required_pull_request_reviews"] = map[string]interface{}{
+			"dismiss_stale_reviews":           p.DismissStaleReviews,
+			"require_code_owner_reviews":      p.RequireCodeOwnerReviews,
+			"required_approving_review_count": p.RequiredReviews,
+		}
+	}
+	if p.RestrictPushes {
+		users, teams := []interface{}{}, []interface{}{}
+		for _, login := range p.PushUsers {
+			users = append(users, userJSON(login))
+		}
+		for _, slug := range p.PushTeams {
+			teams = append(teams, map[string]interface{}{"slug": slug})
+		}
+		body["restrictions"] = map[string]interface{}{"users": users, "teams": teams}
+	}
+	return body
+}
+
+// protectionRuleJSON renders the protection as a GraphQL branch protection rule,
+// its matching refs and push allowances are paged by first and cursor.
+func protectionRuleJSON(id, branch string, p Protection, first int, cursor string) map[string]interface{} {
+	actors := []interface{}{}
+	for _, login := range p.PushUsers {
+		actors = append(actors, map[string]interface{}{"actor": map[string]interface{}{"login": login}})
+	}
+	for _, slug := range p.PushTeams {
+		actors = append(actors, map[string]interface{}{"actor": map[string]interface{}{"slug": slug}})
+	}
+	start, end, allowancesInfo := connection(len(actors), first, cursor)
+	refs := []interface{}{map[string]interface{}{"name": branch}}
+	refsStart, refsEnd, refsInfo := connection(len(refs), first, cursor)
+	contexts := p.RequiredStatusChecks
+	if contexts == nil {
+		contexts = []string{}
+	}
+	return map[string]interface{}{
+		"id":                           id,
+		"pattern":                      branch,
+		"matchingRefs":                 map[string]interface{}{"pageInfo": refsInfo, "nodes": refs[refsStart:refsEnd]},
+		"requiresApprovingReviews":     p.reviewsRequired(),
+		"requiredApprovingReviewCount": p.RequiredReviews,
+		"dismissesStaleReviews":        p.DismissStaleReviews,
+		"requiresCodeOwnerReviews":     p.RequireCodeOwnerReviews,
+		"requiresStatusChecks":         p.RequiredStatusChecks != nil,
+		"requiresStrictStatusChecks":   p.StrictStatusChecks,
+		"requiredStatusCheckContexts":  contexts,
+		"isAdminEnforced":              p.EnforceAdmins,
+		"restrictsPushes":              p.RestrictPushes,
+		"pushAllowances":               map[string]interface{}{"pageInfo": allowancesInfo, "nodes": actors[start:end]},
+		"requiresLinearHistory":        p.RequireLinearHistory,
+		"allowsForcePushes":            p.AllowForcePushes,
+		"allowsDeletions":              p.AllowDeletions,
+	}
+}
+
+// handleProtection serves GET, PUT and DELETE /repos/<owner>/<repo>/branches/<branch>/protection
+func (s *Server) handleProtection(w http.ResponseWriter, r *http.Request, owner, name, branch string) {
+	var body struct {
+		RequiredStatusChecks *struct {
+			Strict   bool     `json:"strict"`
+			Contexts []string `json:"contexts"`
+		} `json:"required_status_checks"`
+		EnforceAdmins   bool `json:"enforce_admins"`
+		RequiredReviews *struct {
+			DismissStaleReviews          bool `json:"dismiss_stale_reviews"`
+			RequireCodeOwnerReviews      bool `json:"require_code_owner_reviews"`
+			RequiredApprovingReviewCount int  `json:"required_approving_review_count"`
+		} `json:"required_pull_request_reviews"`
+		Restrictions *struct {
+			Users []string `json:"users"`
+			Teams []string `json:"teams"`
+		} `json:"restrictions"`
+		RequireLinearHistory bool `json:"required_linear_history"`
+		AllowForcePushes     bool `json:"allow_force_pushes"`
+		AllowDeletions       bool `json:"allow_deletions"`
+	}
+	if r.Method == http.MethodPut {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "Problems parsing JSON")
+			return
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, exist := s.lookupRepo(owner, name)
+	if !exist {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if !repo.Branches[branch] {
+			writeError(w, http.StatusNotFound, "Branch not protected")
+			return
+		}
+		writeJSON(w, http.StatusOK, protectionJSON(repo.Protection[branch]))
+	case http.MethodPut:
+		if _, exist := repo.Branches[branch]; !exist {
+			writeError(w, http.StatusNotFound, "Branch not found")
+			return
+		}
+		p := Protection{
+			EnforceAdmins:        body.EnforceAdmins,
+			RequireLinearHistory: body.RequireLinearHistory,
+			AllowForcePushes:     body.AllowForcePushes,
+			AllowDeletions:       body.AllowDeletions,
+		}
+		if checks := body.RequiredStatusChecks; checks != nil {
+			p.StrictStatusChecks = checks.Strict
+			p.RequiredStatusChecks = append([]string{}, checks.Contexts...)
+		}
+		if reviews := body.RequiredReviews; reviews != nil {
+			p.RequireReviews = true
+			p.DismissStaleReviews = reviews.DismissStaleReviews
+			p.RequireCodeOwnerReviews = reviews.RequireCodeOwnerReviews
+			p.RequiredReviews = reviews.RequiredApprovingReviewCount
+		}
+		if restrictions := body.Restrictions; restrictions != nil {
+			p.RestrictPushes = true
+			p.PushUsers = append([]string{}, restrictions.Users...)
+			p.PushTeams = append([]string{}, restrictions.Teams...)
+		}
+		repo.Branches[branch] = true
+		repo.Protection[branch] = p
+		writeJSON(w, http.StatusOK, protectionJSON(p))
+	case http.MethodDelete:
+		repo.Branches[branch] = false
+		delete(repo.Protection, branch)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+}
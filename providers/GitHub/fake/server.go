@@ -0,0 +1,687 @@
+// Package fake provides an in-process stand in for the parts of the
+// GitHub REST API that the GitHub manager uses.
+// It allows the manager to be exercised without a token or a real organisation.
+package fake
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultPerPage = 30
+
+// Repo is the state the fake holds for a single repository
+type Repo struct {
+	Name     string
+	Private  bool
+	Branches map[string]bool
+	// Protection holds the settings of protected branches,
+	// a protected branch without an entry has every setting turned off.
+	Protection map[string]Protection
+	DeployKeys []DeployKey
+	Hooks      []Hook
+	// Collaborators maps the login of each user given access directly
+	// to their permission, one of pull, triage, push, maintain or admin
+	Collaborators map[string]string
+}
+
+// DeployKey is an SSH key with access to a repository
+type DeployKey struct {
+	Title string
+	// Key is the public key in authorized_keys format
+	Key      string
+	ReadOnly bool
+}
+
+// Hook is a webhook configured on a repository
+type Hook struct {
+	URL    string
+	Events []string
+	Active bool
+}
+
+// Protection is the protection configured on a branch
+type Protection struct {
+	// RequireReviews requires pull request reviews even without any approvals,
+	// they are also required when RequiredReviews is above zero.
+	RequireReviews          bool
+	RequiredReviews         int
+	DismissStaleReviews     bool
+	RequireCodeOwnerReviews bool
+	// RequiredStatusChecks of nil means status checks are not required
+	RequiredStatusChecks []string
+	StrictStatusChecks   bool
+	EnforceAdmins        bool
+	RestrictPushes       bool
+	PushUsers            []string
+	PushTeams            []string
+	RequireLinearHistory bool
+	AllowForcePushes     bool
+	AllowDeletions       bool
+}
+
+// Team is the state the fake holds for a single team
+type Team struct {
+	// ID is assigned by the fake when the team is added
+	ID      int64
+	Name    string
+	Slug    string
+	Privacy string
+	// Parent is the slug of the parent team
+	Parent string
+	// Members maps the login to the role, member or maintainer
+	Members map[string]string
+	// Repos maps the repository name to the permission the team has,
+	// one of pull, triage, push, maintain or admin
+	Repos map[string]string
+}
+
+type org struct {
+	repos map[string]*Repo
+	teams map[string]*Team
+	// members maps the login to the role, admin or member
+	members  map[string]string
+	outside  map[string]bool
+	settings Settings
+	// hidden leaves the settings out of the organisation
+	hidden bool
+}
+
+// Server holds the state of the fake GitHub backend.
+// All methods are safe to be called concurrently.
+type Server struct {
+	// URL is the base url of the fake API
+	URL string
+
+	mu     sync.Mutex
+	orgs   map[string]*org
+	nextID int64
+	http   *httptest.Server
+	mux    *http.ServeMux
+	calls  int
+	// paths counts the requests made for each path
+	paths map[string]int
+
+	// rate limiting is only applied once SetRateLimit has been called
+	limit     int
+	remaining int
+	reset     time.Time
+	failures  []failure
+
+	notModified int
+	app         *app
+	hosts       map[string]bool
+}
+
+type failure struct {
+	status     int
+	retryAfter int
+}
+
+// NewServer starts the fake API on the loopback interface.
+// Close must be called once the server is no longer needed.
+func NewServer() *Server {
+	s := &Server{
+		orgs:  map[string]*org{},
+		hosts: map[string]bool{},
+		paths: map[string]int{},
+		mux:   http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/orgs/", s.handleOrgs)
+	s.mux.HandleFunc("/repos/", s.handleRepos)
+	s.mux.HandleFunc("/teams/", s.handleTeams)
+	s.mux.HandleFunc("/app/", s.handleApp)
+	s.mux.HandleFunc("/graphql", s.handleGraphQL)
+	s.http = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = s.http.URL + "/"
+	return s
+}
+
+// Client returns a http client that sends every request to the fake,
+// regardless of the host the request was made for.
+func (s *Server) Client() *http.Client {
+	target, _ := url.Parse(s.http.URL)
+	return &http.Client{Transport: &redirect{target: target}}
+}
+
+// Close stops the fake API
+func (s *Server) Close() {
+	s.http.Close()
+}
+
+// Hosts returns the hosts that requests were made to through Client
+func (s *Server) Hosts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedKeys(s.hosts)
+}
+
+// Calls returns the number of requests the fake has served
+func (s *Server) Calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+// PathCalls returns the number of requests the fake has served for the path
+func (s *Server) PathCalls(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paths[path]
+}
+
+// AddOrg creates an empty organisation
+func (s *Server) AddOrg(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.org(name)
+}
+
+// org returns the named organisation, creating it if needed.
+// The caller must hold mu.
+func (s *Server) org(name string) *org {
+	o, exist := s.orgs[name]
+	if !exist {
+		o = &org{
+			repos:    map[string]*Repo{},
+			teams:    map[string]*Team{},
+			members:  map[string]string{},
+			outside:  map[string]bool{},
+			settings: defaultSettings,
+		}
+		s.orgs[name] = o
+	}
+	return o
+}
+
+// AddRepo creates or replaces a repository inside the organisation.
+// Branches maps the branch name to if it is protected.
+func (s *Server) AddRepo(org string, repo Repo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := copyRepo(&repo)
+	s.org(org).repos[repo.Name] = &cp
+}
+
+// UpdateRepo applies the change to the stored repository
+func (s *Server) UpdateRepo(org, name string, change func(*Repo)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, exist := s.org(org).repos[name]
+	if !exist {
+		return fmt.Errorf("Unable to find repo %s/%s", org, name)
+	}
+	change(repo)
+	return nil
+}
+
+// AddTeam creates or replaces a team inside the organisation
+func (s *Server) AddTeam(org string, team Team) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := copyTeam(&team)
+	if cp.Privacy == "" {
+		cp.Privacy = "secret"
+	}
+	if existing, exist := s.org(org).teams[team.Slug]; exist {
+		cp.ID = existing.ID
+	} else {
+		s.nextID++
+		cp.ID = s.nextID
+	}
+	s.org(org).teams[team.Slug] = &cp
+}
+
+// UpdateTeam applies the change to the stored team
+func (s *Server) UpdateTeam(org, slug string, change func(*Team)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	team, exist := s.org(org).teams[slug]
+	if !exist {
+		return fmt.Errorf("Unable to find team %s/%s", org, slug)
+	}
+	change(team)
+	return nil
+}
+
+// RemoveRepo deletes the repository from the organisation
+func (s *Server) RemoveRepo(org, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := s.org(org)
+	delete(o.repos, name)
+	for _, team := range o.teams {
+		delete(team.Repos, name)
+	}
+}
+
+// SetRateLimit makes the fake enforce a primary rate limit,
+// once remaining reaches zero requests are rejected until reset.
+func (s *Server) SetRateLimit(limit, remaining int, reset time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit, s.remaining, s.reset = limit, remaining, reset
+}
+
+// FailNext makes the next count requests fail with the status code.
+// When retryAfter is greater than zero the Retry-After header is set
+// in the same way Github reports a secondary rate limit.
+func (s *Server) FailNext(count, status, retryAfter int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < count; i++ {
+		s.failures = append(s.failures, failure{status: status, retryAfter: retryAfter})
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	// Github Enterprise Server serves the same API under /api/v3 and /api/graphql
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/v3/"):
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/api/v3")
+		r.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, "/api/v3")
+	case r.URL.Path == "/api/graphql":
+		r.URL.Path = "/graphql"
+	}
+	s.mu.Lock()
+	s.calls++
+	s.paths[r.URL.Path]++
+	if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+		s.hosts[host] = true
+	}
+	if len(s.failures) != 0 {
+		fail := s.failures[0]
+		s.failures = s.failures[1:]
+		s.mu.Unlock()
+		if fail.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(fail.retryAfter))
+		}
+		if fail.status == http.StatusForbidden {
+			writeJSON(w, fail.status, map[string]string{
+				"message":           "You have triggered an abuse detection mechanism.",
+				"documentation_url": "https://developer.github.com/v3/#abuse-rate-limits",
+			})
+			return
+		}
+		writeError(w, fail.status, "Server Error")
+		return
+	}
+	if !s.authenticate(w, r) {
+		s.mu.Unlock()
+		return
+	}
+	if s.limit != 0 && time.Now().After(s.reset) {
+		s.remaining = s.limit
+		s.reset = time.Now().Add(time.Hour)
+	}
+	if s.limit != 0 && s.remaining == 0 {
+		s.setRateHeaders(w)
+		s.mu.Unlock()
+		writeError(w, http.StatusForbidden, "API rate limit exceeded for "+r.RemoteAddr)
+		return
+	}
+	s.mu.Unlock()
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, r)
+	body := rec.Body.Bytes()
+	etag := fmt.Sprintf(`"%x"`, sha1.Sum(body))
+	notModified := r.Method == http.MethodGet && rec.Code == http.StatusOK && r.Header.Get("If-None-Match") == etag
+	s.mu.Lock()
+	// Conditional requests that are not modified are free, the same as Github
+	if s.limit != 0 && !notModified {
+		s.remaining--
+	}
+	if notModified {
+		s.notModified++
+	}
+	s.setRateHeaders(w)
+	s.mu.Unlock()
+	for name, values := range rec.Header() {
+		w.Header()[name] = values
+	}
+	if rec.Code == http.StatusOK {
+		w.Header().Set("ETag", etag)
+	}
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(rec.Code)
+	w.Write(body)
+}
+
+func (s *Server) setRateHeaders(w http.ResponseWriter) {
+	if s.limit == 0 {
+		return
+	}
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(s.limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(s.remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(s.reset.Unix(), 10))
+}
+
+// NotModified returns the number of conditional requests that were
+// answered with 304 Not Modified.
+func (s *Server) NotModified() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.notModified
+}
+
+// handleOrgs serves /orgs/<org> along with its repos, teams, members,
+// memberships and outside_collaborators
+func (s *Server) handleOrgs(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 2 && r.Method == http.MethodGet {
+		s.getOrg(w, r, parts[1])
+		return
+	}
+	if len(parts) == 4 && parts[2] == "memberships" && r.Method == http.MethodGet {
+		s.getMembership(w, r, parts[1], parts[3])
+		return
+	}
+	if len(parts) == 3 && r.Method == http.MethodGet {
+		switch parts[2] {
+		case "teams":
+			s.listOrgTeams(w, r, parts[1])
+			return
+		case "members":
+			s.listMembers(w, r, parts[1])
+			return
+		case "outside_collaborators":
+			s.listOutsideCollaborators(w, r, parts[1])
+			return
+		case "installation":
+			s.getInstallation(w, r, parts[1])
+			return
+		}
+	}
+	if len(parts) != 3 || parts[2] != "repos" || r.Method != http.MethodGet {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	s.mu.Lock()
+	o, exist := s.orgs[parts[1]]
+	if !exist {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	items := []interface{}{}
+	for _, name := range sortedKeys(o.repos) {
+		items = append(items, repoJSON(parts[1], o.repos[name]))
+	}
+	s.mu.Unlock()
+	writePage(w, r, items)
+}
+
+// handleRepos serves GET and PATCH /repos/<owner>/<repo>, the branches, teams, keys, hooks
+// and collaborators of a repository and /repos/<owner>/<repo>/branches/<branch>/protection
+func (s *Server) handleRepos(w http.ResponseWriter, r *http.Request) {
+	// Branch names are able to contain an escaped slash
+	parts := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	for i := range parts {
+		parts[i], _ = url.PathUnescape(parts[i])
+	}
+	if len(parts) == 3 && r.Method == http.MethodPatch {
+		s.editRepo(w, r, parts[1], parts[2])
+		return
+	}
+	if len(parts) == 3 && r.Method == http.MethodGet {
+		s.mu.Lock()
+		repo, exist := s.lookupRepo(parts[1], parts[2])
+		if !exist {
+			s.mu.Unlock()
+			writeError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		item := repoJSON(parts[1], repo)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, item)
+		return
+	}
+	if len(parts) == 4 && r.Method == http.MethodGet {
+		switch parts[3] {
+		case "teams":
+			s.listRepoTeams(w, r, parts[1], parts[2])
+			return
+		case "keys", "hooks":
+			s.listRepoAccess(w, r, parts[1], parts[2], parts[3])
+			return
+		case "collaborators":
+			s.listCollaborators(w, r, parts[1], parts[2])
+			return
+		}
+	}
+	if len(parts) == 5 && parts[3] == "collaborators" {
+		s.handleCollaborator(w, r, parts[1], parts[2], parts[4])
+		return
+	}
+	if len(parts) == 6 && parts[3] == "branches" && parts[5] == "protection" {
+		s.handleProtection(w, r, parts[1], parts[2], parts[4])
+		return
+	}
+	if len(parts) != 4 || parts[3] != "branches" || r.Method != http.MethodGet {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	s.mu.Lock()
+	repo, exist := s.lookupRepo(parts[1], parts[2])
+	if !exist {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	items := []interface{}{}
+	for _, name := range sortedKeys(repo.Branches) {
+		items = append(items, map[string]interface{}{
+			"name":      name,
+			"protected": repo.Branches[name],
+		})
+	}
+	s.mu.Unlock()
+	writePage(w, r, items)
+}
+
+// editRepo changes the visibility of the repository, other fields are ignored
+func (s *Server) editRepo(w http.ResponseWriter, r *http.Request, owner, name string) {
+	var body struct {
+		Private *bool `json:"private"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+	s.mu.Lock()
+	repo, exist := s.lookupRepo(owner, name)
+	if !exist {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	if body.Private != nil {
+		repo.Private = *body.Private
+	}
+	item := repoJSON(owner, repo)
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, item)
+}
+
+// listRepoAccess serves /repos/<owner>/<repo>/keys and /repos/<owner>/<repo>/hooks
+func (s *Server) listRepoAccess(w http.ResponseWriter, r *http.Request, owner, name, kind string) {
+	s.mu.Lock()
+	repo, exist := s.lookupRepo(owner, name)
+	if !exist {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	items := []interface{}{}
+	if kind == "keys" {
+		for i, key := range repo.DeployKeys {
+			items = append(items, map[string]interface{}{
+				"id":        i + 1,
+				"title":     key.Title,
+				"key":       key.Key,
+				"read_only": key.ReadOnly,
+			})
+		}
+	} else {
+		for i, hook := range repo.Hooks {
+			items = append(items, map[string]interface{}{
+				"id":     i + 1,
+				"name":   "web",
+				"events": hook.Events,
+				"active": hook.Active,
+				"config": map[string]interface{}{"url": hook.URL, "content_type": "json"},
+			})
+		}
+	}
+	s.mu.Unlock()
+	writePage(w, r, items)
+}
+
+// Repo returns a copy of the repository, reporting false when it does not exist
+func (s *Server) Repo(org, name string) (Repo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo, exist := s.lookupRepo(org, name)
+	if !exist {
+		return Repo{}, false
+	}
+	return copyRepo(repo), true
+}
+
+func copyRepo(repo *Repo) Repo {
+	cp := *repo
+	cp.Branches = map[string]bool{}
+	for branch, protected := range repo.Branches {
+		cp.Branches[branch] = protected
+	}
+	cp.Protection = map[string]Protection{}
+	for branch, protection := range repo.Protection {
+		cp.Protection[branch] = protection
+	}
+	cp.DeployKeys = append([]DeployKey{}, repo.DeployKeys...)
+	cp.Hooks = append([]Hook{}, repo.Hooks...)
+	cp.Collaborators = map[string]string{}
+	for login, permission := range repo.Collaborators {
+		cp.Collaborators[login] = permission
+	}
+	return cp
+}
+
+func (s *Server) lookupRepo(org, name string) (*Repo, bool) {
+	o, exist := s.orgs[org]
+	if !exist {
+		return nil, false
+	}
+	repo, exist := o.repos[name]
+	return repo, exist
+}
+
+// sortedKeys returns the keys of a map keyed by strings in order,
+// so everything the fake returns is stable.
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	switch items := m.(type) {
+	case map[string]*Repo:
+		for key := range items {
+			keys = append(keys, key)
+		}
+	case map[string]*Team:
+		for key := range items {
+			keys = append(keys, key)
+		}
+	case map[string]string:
+		for key := range items {
+			keys = append(keys, key)
+		}
+	case map[string]bool:
+		for key := range items {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func repoJSON(org string, repo *Repo) map[string]interface{} {
+	return map[string]interface{}{
+		"name":      repo.Name,
+		"full_name": org + "/" + repo.Name,
+		"private":   repo.Private,
+		"owner":     map[string]interface{}{"login": org},
+	}
+}
+
+// writePage writes the requested page of items and sets the Link header
+// the same way GitHub does so clients can follow the pagination.
+func writePage(w http.ResponseWriter, r *http.Request, items []interface{}) {
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if perPage <= 0 {
+		perPage = defaultPerPage
+	}
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page <= 0 {
+		page = 1
+	}
+	start := (page - 1) * perPage
+	if start > len(items) {
+		start = len(items)
+	}
+	end := start + perPage
+	if end < len(items) {
+		// RequestURI keeps any /api/v3 prefix that was removed before routing
+		next := *r.URL
+		if original, err := url.ParseRequestURI(r.RequestURI); err == nil {
+			next.Path = original.Path
+		}
+		query := next.Query()
+		query.Set("page", strconv.Itoa(page+1))
+		next.RawQuery = query.Encode()
+		next.Scheme, next.Host = "http", r.Host
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+	} else {
+		end = len(items)
+	}
+	writeJSON(w, http.StatusOK, items[start:end])
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"message": message})
+}
+
+// redirect rewrites the scheme and host of every request to the target
+type redirect struct {
+	target *url.URL
+}
+
+func (r *redirect) RoundTrip(req *http.Request) (*http.Response, error) {
+	out := new(http.Request)
+	*out = *req
+	u := *req.URL
+	u.Scheme, u.Host = r.target.Scheme, r.target.Host
+	out.URL = &u
+	out.Host = r.target.Host
+	out.Header = http.Header{}
+	for name, values := range req.Header {
+		out.Header[name] = values
+	}
+	out.Header.Set("X-Forwarded-Host", req.URL.Host)
+	return http.DefaultTransport.RoundTrip(out)
+}
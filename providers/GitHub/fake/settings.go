@@ -0,0 +1,68 @@
+package fake
+
+import (
+	"net/http"
+)
+
+// Settings are the security settings of an organisation
+type Settings struct {
+	TwoFactorRequired bool
+	// DefaultRepositoryPermission is one of read, write, admin or none
+	DefaultRepositoryPermission  string
+	MembersCanCreatePublicRepos  bool
+	MembersCanCreatePrivateRepos bool
+	MembersCanForkPrivateRepos   bool
+	WebCommitSignoffRequired     bool
+}
+
+// defaultSettings are what Github gives a new organisation
+var defaultSettings = Settings{
+	DefaultRepositoryPermission:  "read",
+	MembersCanCreatePublicRepos:  true,
+	MembersCanCreatePrivateRepos: true,
+}
+
+// UpdateSettings applies the change to the settings of the organisation
+func (s *Server) UpdateSettings(org string, change func(*Settings)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change(&s.org(org).settings)
+}
+
+// HideSettings leaves the settings out of the organisation in the same way
+// Github does when the credentials are not an owner of the organisation.
+func (s *Server) HideSettings(org string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.org(org).hidden = true
+}
+
+// getOrg serves /orgs/<org>
+func (s *Server) getOrg(w http.ResponseWriter, r *http.Request, login string) {
+	s.mu.Lock()
+	o, exist := s.orgs[login]
+	if !exist {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	settings, hidden := o.settings, o.hidden
+	s.mu.Unlock()
+	if hidden {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"login": login,
+			"type":  "Organization",
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"login":                                   login,
+		"type":                                    "Organization",
+		"two_factor_requirement_enabled":          settings.TwoFactorRequired,
+		"default_repository_permission":           settings.DefaultRepositoryPermission,
+		"members_can_create_public_repositories":  settings.MembersCanCreatePublicRepos,
+		"members_can_create_private_repositories": settings.MembersCanCreatePrivateRepos,
+		"members_can_fork_private_repositories":   settings.MembersCanForkPrivateRepos,
+		"web_commit_signoff_required":             settings.WebCommitSignoffRequired,
+	})
+}
@@ -0,0 +1,195 @@
+package fake
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// teamJSON describes the team, parent is the team it is nested under if any.
+func teamJSON(team, parent *Team) map[string]interface{} {
+	item := map[string]interface{}{
+		"id":      team.ID,
+		"name":    team.Name,
+		"slug":    team.Slug,
+		"privacy": team.Privacy,
+	}
+	if parent != nil {
+		item["parent"] = teamJSON(parent, nil)
+	}
+	return item
+}
+
+// listOrgTeams serves /orgs/<org>/teams
+func (s *Server) listOrgTeams(w http.ResponseWriter, r *http.Request, login string) {
+	s.mu.Lock()
+	o, exist := s.orgs[login]
+	if !exist {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	items := []interface{}{}
+	for _, slug := range sortedKeys(o.teams) {
+		items = append(items, teamJSON(o.teams[slug], o.teams[o.teams[slug].Parent]))
+	}
+	s.mu.Unlock()
+	writePage(w, r, items)
+}
+
+// listRepoTeams serves /repos/<owner>/<repo>/teams,
+// each team includes the permission it has on the repo.
+func (s *Server) listRepoTeams(w http.ResponseWriter, r *http.Request, owner, name string) {
+	s.mu.Lock()
+	if _, exist := s.lookupRepo(owner, name); !exist {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	items := []interface{}{}
+	teams := s.orgs[owner].teams
+	for _, slug := range sortedKeys(teams) {
+		permission, exist := teams[slug].Repos[name]
+		if !exist {
+			continue
+		}
+		item := teamJSON(teams[slug], teams[teams[slug].Parent])
+		item["permission"] = permission
+		items = append(items, item)
+	}
+	s.mu.Unlock()
+	writePage(w, r, items)
+}
+
+// handleTeams serves GET /teams/<id>/members and
+// PUT and DELETE /teams/<id>/repos/<owner>/<repo>
+func (s *Server) handleTeams(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 3 && parts[2] == "members" && r.Method == http.MethodGet {
+		id, _ := strconv.ParseInt(parts[1], 10, 64)
+		s.listTeamMembers(w, r, id)
+		return
+	}
+	if len(parts) != 5 || parts[2] != "repos" {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	id, _ := strconv.ParseInt(parts[1], 10, 64)
+	owner, name := parts[3], parts[4]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	team := s.lookupTeam(id)
+	if _, exist := s.lookupRepo(owner, name); !exist || team == nil {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		var body struct {
+			Permission string `json:"permission"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.Permission == "" {
+			body.Permission = "pull"
+		}
+		if _, valid := graphqlPermissions[body.Permission]; !valid {
+			writeError(w, http.StatusUnprocessableEntity, "Validation Failed")
+			return
+		}
+		team.Repos[name] = body.Permission
+	case http.MethodDelete:
+		delete(team.Repos, name)
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listTeamMembers serves /teams/<id>/members. Like Github the members of
+// child teams are included, keeping the role they have in the child team.
+func (s *Server) listTeamMembers(w http.ResponseWriter, r *http.Request, id int64) {
+	s.mu.Lock()
+	team := s.lookupTeam(id)
+	if team == nil {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	members := map[string]string{}
+	s.collectMembers(team, members)
+	s.mu.Unlock()
+	role := r.URL.Query().Get("role")
+	items := []interface{}{}
+	for _, login := range sortedKeys(members) {
+		if role != "" && role != "all" && members[login] != role {
+			continue
+		}
+		items = append(items, userJSON(login))
+	}
+	writePage(w, r, items)
+}
+
+// collectMembers adds the members of the team and its descendants,
+// a direct membership wins over one inherited from a child team.
+// The caller must hold mu.
+func (s *Server) collectMembers(team *Team, members map[string]string) {
+	for login, role := range team.Members {
+		members[login] = role
+	}
+	for _, o := range s.orgs {
+		if o.teams[team.Slug] != team {
+			continue
+		}
+		for _, slug := range sortedKeys(o.teams) {
+			child := o.teams[slug]
+			if child.Parent != team.Slug {
+				continue
+			}
+			inherited := map[string]string{}
+			s.collectMembers(child, inherited)
+			for login, role := range inherited {
+				if _, direct := members[login]; !direct {
+					members[login] = role
+				}
+			}
+		}
+	}
+}
+
+// lookupTeam finds the team by ID across every organisation.
+// The caller must hold mu.
+func (s *Server) lookupTeam(id int64) *Team {
+	for _, o := range s.orgs {
+		for _, team := range o.teams {
+			if team.ID == id {
+				return team
+			}
+		}
+	}
+	return nil
+}
+
+// Team returns a copy of the team, reporting false when it does not exist
+func (s *Server) Team(org, slug string) (Team, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	team, exist := s.org(org).teams[slug]
+	if !exist {
+		return Team{}, false
+	}
+	return copyTeam(team), true
+}
+
+func copyTeam(team *Team) Team {
+	cp := *team
+	cp.Members, cp.Repos = map[string]string{}, map[string]string{}
+	for login, role := range team.Members {
+		cp.Members[login] = role
+	}
+	for repo, permission := range team.Repos {
+		cp.Repos[repo] = permission
+	}
+	return cp
+}
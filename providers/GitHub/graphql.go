@@ -0,0 +1,569 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	overwatch "github.com/SeedJobs/devops-go-overwatch"
+	"github.com/SeedJobs/devops-go-overwatch/providers/default"
+)
+
+const (
+	// BackendREST fetches resources through the v3 REST API
+	BackendREST = "rest"
+	// BackendGraphQL fetches resources through the v4 GraphQL API
+	BackendGraphQL = "graphql"
+
+	// graphqlPageSize is the number of nodes requested per page, Github
+	// does not allow more than 100 to be requested at once.
+	graphqlPageSize = 100
+	// repositoryPageSize and nestedPageSize keep the repositories query under the
+	// 500,000 nodes Github allows, as each connection multiplies the one it is in.
+	repositoryPageSize = 25
+	nestedPageSize     = 25
+)
+
+// ruleFields are the fields of a branch protection rule, matching refs and
+// push allowances past the first page are followed up through the id of the rule.
+const ruleFields = `fragment ruleFields on BranchProtectionRule {
+  id
+  pattern
+  matchingRefs(first: $nested) { pageInfo { hasNextPage endCursor } nodes { name } }
+  requiresApprovingReviews
+  requiredApprovingReviewCount
+  dismissesStaleReviews
+  requiresCodeOwnerReviews
+  requiresStatusChecks
+  requiresStrictStatusChecks
+  requiredStatusCheckContexts
+  isAdminEnforced
+  restrictsPushes
+  pushAllowances(first: $nested) {
+    pageInfo { hasNextPage endCursor }
+    nodes { actor { ... on User { login } ... on Team { slug } } }
+  }
+  requiresLinearHistory
+  allowsForcePushes
+  allowsDeletions
+}`
+
+// The nested connections of a repository that have more than one page
+// are followed up on their own with the queries below.
+const repositoriesQuery = `query($organisation: String!, $first: Int!, $nested: Int!, $cursor: String) {
+  organization(login: $organisation) {
+    repositories(first: $first, after: $cursor, orderBy: {field: NAME, direction: ASC}) {
+      pageInfo { hasNextPage endCursor }
+      nodes {
+        name
+        isPrivate
+        branchProtectionRules(first: $nested) { pageInfo { hasNextPage endCursor } nodes { ...ruleFields } }
+        deployKeys(first: $nested) { pageInfo { hasNextPage endCursor } nodes { title key readOnly } }
+        collaborators(affiliation: DIRECT, first: $nested) {
+          pageInfo { hasNextPage endCursor }
+          edges { permission node { login } }
+        }
+      }
+    }
+  }
+}
+` + ruleFields
+
+const repositoryRulesQuery = `query($organisation: String!, $name: String!, $first: Int!, $nested: Int!, $cursor: String) {
+  repository(owner: $organisation, name: $name) {
+    branchProtectionRules(first: $first, after: $cursor) { pageInfo { hasNextPage endCursor } nodes { ...ruleFields } }
+  }
+}
+` + ruleFields
+
+const repositoryDeployKeysQuery = `query($organisation: String!, $name: String!, $first: Int!, $cursor: String) {
+  repository(owner: $organisation, name: $name) {
+    deployKeys(first: $first, after: $cursor) { pageInfo { hasNextPage endCursor } nodes { title key readOnly } }
+  }
+}`
+
+const repositoryCollaboratorsQuery = `query($organisation: String!, $name: String!, $first: Int!, $cursor: String) {
+  repository(owner: $organisation, name: $name) {
+    collaborators(affiliation: DIRECT, first: $first, after: $cursor) {
+      pageInfo { hasNextPage endCursor }
+      edges { permission node { login } }
+    }
+  }
+}`
+
+const ruleRefsQuery = `query($id: ID!, $first: Int!, $cursor: String) {
+  node(id: $id) {
+    ... on BranchProtectionRule {
+      matchingRefs(first: $first, after: $cursor) { pageInfo { hasNextPage endCursor } nodes { name } }
+    }
+  }
+}`
+
+const rulePushAllowancesQuery = `query($id: ID!, $first: Int!, $cursor: String) {
+  node(id: $id) {
+    ... on BranchProtectionRule {
+      pushAllowances(first: $first, after: $cursor) {
+        pageInfo { hasNextPage endCursor }
+        nodes { actor { ... on User { login } ... on Team { slug } } }
+      }
+    }
+  }
+}`
+
+const teamsQuery = `query($organisation: String!, $first: Int!, $cursor: String) {
+  organization(login: $organisation) {
+    teams(first: $first, after: $cursor) {
+      pageInfo { hasNextPage endCursor }
+      nodes {
+        slug
+        repositories(first: $first) {
+          pageInfo { hasNextPage endCursor }
+          edges { permission node { name } }
+        }
+      }
+    }
+  }
+}`
+
+const teamRepositoriesQuery = `query($organisation: String!, $slug: String!, $first: Int!, $cursor: String) {
+  organization(login: $organisation) {
+    team(slug: $slug) {
+      repositories(first: $first, after: $cursor) {
+        pageInfo { hasNextPage endCursor }
+        edges { permission node { name } }
+      }
+    }
+  }
+}`
+
+// restPermissions converts the RepositoryPermission enum
+// into the permission names used by the v3 API and the store.
+var restPermissions = map[string]string{
+	"READ":     "pull",
+	"TRIAGE":   "triage",
+	"WRITE":    "push",
+	"MAINTAIN": "maintain",
+	"ADMIN":    "admin",
+}
+
+// restPermission converts the permission of a team, keeping any permission
+// Github adds later lowercased so that it is not mistaken for no access.
+func restPermission(permission string) string {
+	if converted, known := restPermissions[permission]; known {
+		return converted
+	}
+	return strings.ToLower(permission)
+}
+
+type pageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+type teamRepositoryConnection struct {
+	PageInfo pageInfo `json:"pageInfo"`
+	Edges    []struct {
+		Permission string `json:"permission"`
+		Node       struct {
+			Name string `json:"name"`
+		} `json:"node"`
+	} `json:"edges"`
+}
+
+type refConnection struct {
+	PageInfo pageInfo `json:"pageInfo"`
+	Nodes    []struct {
+		Name string `json:"name"`
+	} `json:"nodes"`
+}
+
+type pushAllowanceConnection struct {
+	PageInfo pageInfo `json:"pageInfo"`
+	Nodes    []struct {
+		Actor struct {
+			Login string `json:"login"`
+			Slug  string `json:"slug"`
+		} `json:"actor"`
+	} `json:"nodes"`
+}
+
+type ruleConnection struct {
+	PageInfo pageInfo         `json:"pageInfo"`
+	Nodes    []protectionRule `json:"nodes"`
+}
+
+type deployKeyConnection struct {
+	PageInfo pageInfo `json:"pageInfo"`
+	Nodes    []struct {
+		Title    string `json:"title"`
+		Key      string `json:"key"`
+		ReadOnly bool   `json:"readOnly"`
+	} `json:"nodes"`
+}
+
+type collaboratorConnection struct {
+	PageInfo pageInfo `json:"pageInfo"`
+	Edges    []struct {
+		Permission string `json:"permission"`
+		Node       struct {
+			Login string `json:"login"`
+		} `json:"node"`
+	} `json:"edges"`
+}
+
+// repositoryNode is a repository as returned by the repositories query
+type repositoryNode struct {
+	Name                  string                 `json:"name"`
+	IsPrivate             bool                   `json:"isPrivate"`
+	BranchProtectionRules ruleConnection         `json:"branchProtectionRules"`
+	DeployKeys            deployKeyConnection    `json:"deployKeys"`
+	Collaborators         collaboratorConnection `json:"collaborators"`
+}
+
+// protectionRule is a branch protection rule as returned by the GraphQL API
+type protectionRule struct {
+	ID                           string                  `json:"id"`
+	Pattern                      string                  `json:"pattern"`
+	MatchingRefs                 refConnection           `json:"matchingRefs"`
+	RequiresApprovingReviews     bool                    `json:"requiresApprovingReviews"`
+	RequiredApprovingReviewCount int                     `json:"requiredApprovingReviewCount"`
+	DismissesStaleReviews        bool                    `json:"dismissesStaleReviews"`
+	RequiresCodeOwnerReviews     bool                    `json:"requiresCodeOwnerReviews"`
+	RequiresStatusChecks         bool                    `json:"requiresStatusChecks"`
+	RequiresStrictStatusChecks   bool                    `json:"requiresStrictStatusChecks"`
+	RequiredStatusCheckContexts  []string                `json:"requiredStatusCheckContexts"`
+	IsAdminEnforced              bool                    `json:"isAdminEnforced"`
+	RestrictsPushes              bool                    `json:"restrictsPushes"`
+	PushAllowances               pushAllowanceConnection `json:"pushAllowances"`
+	RequiresLinearHistory        bool                    `json:"requiresLinearHistory"`
+	AllowsForcePushes            bool                    `json:"allowsForcePushes"`
+	AllowsDeletions              bool                    `json:"allowsDeletions"`
+}
+
+// protection converts the rule into the same form the v3 API reports for the branch.
+// Settings that are not required are reported as their zero values.
+func (r protectionRule) protection(branch string) branchProtection {
+	protection := branchProtection{
+		Branch:               branch,
+		EnforceAdmins:        r.IsAdminEnforced,
+		RequireLinearHistory: r.RequiresLinearHistory,
+		AllowForcePushes:     r.AllowsForcePushes,
+		AllowDeletions:       r.AllowsDeletions,
+	}
+	if r.RequiresApprovingReviews {
+		protection.RequirePullRequestReviews = true
+		protection.RequiredReviews = r.RequiredApprovingReviewCount
+		protection.DismissStaleReviews = r.DismissesStaleReviews
+		protection.RequireCodeOwnerReviews = r.RequiresCodeOwnerReviews
+	}
+	if r.RequiresStatusChecks {
+		protection.RequireStatusChecks = true
+		protection.StrictStatusChecks = r.RequiresStrictStatusChecks
+		protection.RequiredStatusChecks = r.RequiredStatusCheckContexts
+	}
+	if r.RestrictsPushes {
+		protection.RestrictPushes = true
+		for _, allowance := range r.PushAllowances.Nodes {
+			if allowance.Actor.Login != "" {
+				protection.PushUsers = append(protection.PushUsers, allowance.Actor.Login)
+			}
+			if allowance.Actor.Slug != "" {
+				protection.PushTeams = append(protection.PushTeams, allowance.Actor.Slug)
+			}
+		}
+	}
+	protection.normalise()
+	return protection
+}
+
+// graphql sends the query to Github and decodes the data of the response into data.
+// Errors reported inside the response body are returned as an error.
+func (m *manager) graphql(ctx context.Context, query string, variables map[string]interface{}, data interface{}) error {
+	req, err := m.client.NewRequest("POST", m.graphqlURL, map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	})
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if _, err := m.client.Do(ctx, req, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) != 0 {
+		messages := []string{}
+		for _, e := range resp.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("Github GraphQL query failed: %s", strings.Join(messages, ", "))
+	}
+	return json.Unmarshal(resp.Data, data)
+}
+
+// fetchOrgProjectsGraphQL collects the same information as fetchOrgProjects
+// but in a handful of paginated queries rather than one or more calls per repo.
+func (m *manager) fetchOrgProjectsGraphQL() ([]overwatch.IamResource, error) {
+	ctx := context.Background()
+	teams, err := m.fetchTeamAccessGraphQL(ctx)
+	if err != nil {
+		return nil, err
+	}
+	allRepos := []overwatch.IamResource{}
+	variables := map[string]interface{}{
+		"organisation": m.organisation,
+		"first":        repositoryPageSize,
+		"nested":       nestedPageSize,
+	}
+	for {
+		var data struct {
+			Organization struct {
+				Repositories struct {
+					PageInfo pageInfo         `json:"pageInfo"`
+					Nodes    []repositoryNode `json:"nodes"`
+				} `json:"repositories"`
+			} `json:"organization"`
+		}
+		if err := m.graphql(ctx, repositoriesQuery, variables, &data); err != nil {
+			return nil, err
+		}
+		repos := data.Organization.Repositories
+		for _, node := range repos.Nodes {
+			if err := m.completeRepository(ctx, &node); err != nil {
+				return nil, err
+			}
+			repo := project{
+				Name:          node.Name,
+				Public:        !node.IsPrivate,
+				Protected:     []branchProtection{},
+				Teams:         []teamAccess{},
+				Collaborators: []collaborator{},
+				DeployKeys:    []deployKey{},
+				Webhooks:      []webhook{},
+			}
+			for _, edge := range node.Collaborators.Edges {
+				permission, known := restPermissions[edge.Permission]
+				if !known {
+					permission = permissionUnknown
+				}
+				repo.Collaborators = append(repo.Collaborators, collaborator{
+					Login:      edge.Node.Login,
+					Permission: permission,
+				})
+			}
+			sortCollaborators(repo.Collaborators)
+			for _, key := range node.DeployKeys.Nodes {
+				repo.DeployKeys = append(repo.DeployKeys, deployKey{
+					Title:       key.Title,
+					Fingerprint: fingerprint(key.Key),
+					ReadOnly:    key.ReadOnly,
+				})
+			}
+			sortDeployKeys(repo.DeployKeys)
+			// A rule naming the branch exactly takes precedence over a wildcard pattern
+			rules := map[string]protectionRule{}
+			for _, rule := range node.BranchProtectionRules.Nodes {
+				for _, ref := range rule.MatchingRefs.Nodes {
+					if existing, exist := rules[ref.Name]; !exist || (rule.Pattern == ref.Name && existing.Pattern != ref.Name) {
+						rules[ref.Name] = rule
+					}
+				}
+			}
+			for branch, rule := range rules {
+				repo.Protected = append(repo.Protected, rule.protection(branch))
+			}
+			sortProtection(repo.Protected)
+			if access, exist := teams[node.Name]; exist {
+				repo.Teams = access
+			}
+			allRepos = append(allRepos, repo)
+		}
+		if !repos.PageInfo.HasNextPage {
+			break
+		}
+		variables["cursor"] = repos.PageInfo.EndCursor
+	}
+	// Webhooks are not part of the GraphQL schema so are still fetched per repo
+	err = abstract.ForEach(ctx, len(allRepos), m.concurrency, func(ctx context.Context, i int) error {
+		repo := allRepos[i].(project)
+		hooks, err := m.fetchWebhooks(ctx, m.organisation, repo.Name)
+		if err != nil {
+			return err
+		}
+		repo.Webhooks = hooks
+		allRepos[i] = repo
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return allRepos, nil
+}
+
+// completeRepository follows up the nested connections of the repository
+// that did not fit in the page they were first requested in.
+func (m *manager) completeRepository(ctx context.Context, node *repositoryNode) error {
+	variables := func(cursor string) map[string]interface{} {
+		return map[string]interface{}{
+			"organisation": m.organisation,
+			"name":         node.Name,
+			"first":        graphqlPageSize,
+			"nested":       nestedPageSize,
+			"cursor":       cursor,
+		}
+	}
+	for node.BranchProtectionRules.PageInfo.HasNextPage {
+		var data struct {
+			Repository struct {
+				BranchProtectionRules ruleConnection `json:"branchProtectionRules"`
+			} `json:"repository"`
+		}
+		if err := m.graphql(ctx, repositoryRulesQuery, variables(node.BranchProtectionRules.PageInfo.EndCursor), &data); err != nil {
+			return err
+		}
+		conn := data.Repository.BranchProtectionRules
+		node.BranchProtectionRules.Nodes = append(node.BranchProtectionRules.Nodes, conn.Nodes...)
+		node.BranchProtectionRules.PageInfo = conn.PageInfo
+	}
+	for i := range node.BranchProtectionRules.Nodes {
+		if err := m.completeRule(ctx, &node.BranchProtectionRules.Nodes[i]); err != nil {
+			return err
+		}
+	}
+	for node.DeployKeys.PageInfo.HasNextPage {
+		var data struct {
+			Repository struct {
+				DeployKeys deployKeyConnection `json:"deployKeys"`
+			} `json:"repository"`
+		}
+		if err := m.graphql(ctx, repositoryDeployKeysQuery, variables(node.DeployKeys.PageInfo.EndCursor), &data); err != nil {
+			return err
+		}
+		conn := data.Repository.DeployKeys
+		node.DeployKeys.Nodes = append(node.DeployKeys.Nodes, conn.Nodes...)
+		node.DeployKeys.PageInfo = conn.PageInfo
+	}
+	for node.Collaborators.PageInfo.HasNextPage {
+		var data struct {
+			Repository struct {
+				Collaborators collaboratorConnection `json:"collaborators"`
+			} `json:"repository"`
+		}
+		if err := m.graphql(ctx, repositoryCollaboratorsQuery, variables(node.Collaborators.PageInfo.EndCursor), &data); err != nil {
+			return err
+		}
+		conn := data.Repository.Collaborators
+		node.Collaborators.Edges = append(node.Collaborators.Edges, conn.Edges...)
+		node.Collaborators.PageInfo = conn.PageInfo
+	}
+	return nil
+}
+
+// completeRule follows up the matching refs and push allowances of the rule
+func (m *manager) completeRule(ctx context.Context, rule *protectionRule) error {
+	variables := func(cursor string) map[string]interface{} {
+		return map[string]interface{}{
+			"id":     rule.ID,
+			"first":  graphqlPageSize,
+			"cursor": cursor,
+		}
+	}
+	for rule.MatchingRefs.PageInfo.HasNextPage {
+		var data struct {
+			Node struct {
+				MatchingRefs refConnection `json:"matchingRefs"`
+			} `json:"node"`
+		}
+		if err := m.graphql(ctx, ruleRefsQuery, variables(rule.MatchingRefs.PageInfo.EndCursor), &data); err != nil {
+			return err
+		}
+		rule.MatchingRefs.Nodes = append(rule.MatchingRefs.Nodes, data.Node.MatchingRefs.Nodes...)
+		rule.MatchingRefs.PageInfo = data.Node.MatchingRefs.PageInfo
+	}
+	for rule.PushAllowances.PageInfo.HasNextPage {
+		var data struct {
+			Node struct {
+				PushAllowances pushAllowanceConnection `json:"pushAllowances"`
+			} `json:"node"`
+		}
+		if err := m.graphql(ctx, rulePushAllowancesQuery, variables(rule.PushAllowances.PageInfo.EndCursor), &data); err != nil {
+			return err
+		}
+		rule.PushAllowances.Nodes = append(rule.PushAllowances.Nodes, data.Node.PushAllowances.Nodes...)
+		rule.PushAllowances.PageInfo = data.Node.PushAllowances.PageInfo
+	}
+	return nil
+}
+
+// fetchTeamAccessGraphQL returns the team access of each repo keyed by the repo name
+func (m *manager) fetchTeamAccessGraphQL(ctx context.Context) (map[string][]teamAccess, error) {
+	access := map[string][]teamAccess{}
+	collect := func(slug string, conn teamRepositoryConnection) {
+		for _, edge := range conn.Edges {
+			access[edge.Node.Name] = append(access[edge.Node.Name], teamAccess{
+				Slug:       slug,
+				Permission: restPermission(edge.Permission),
+			})
+		}
+	}
+	variables := map[string]interface{}{
+		"organisation": m.organisation,
+		"first":        graphqlPageSize,
+	}
+	for {
+		var data struct {
+			Organization struct {
+				Teams struct {
+					PageInfo pageInfo `json:"pageInfo"`
+					Nodes    []struct {
+						Slug         string                   `json:"slug"`
+						Repositories teamRepositoryConnection `json:"repositories"`
+					} `json:"nodes"`
+				} `json:"teams"`
+			} `json:"organization"`
+		}
+		if err := m.graphql(ctx, teamsQuery, variables, &data); err != nil {
+			return nil, err
+		}
+		teams := data.Organization.Teams
+		for _, team := range teams.Nodes {
+			collect(team.Slug, team.Repositories)
+			// Teams with access to more repos than fit in a page are followed up on their own
+			cursor, more := team.Repositories.PageInfo.EndCursor, team.Repositories.PageInfo.HasNextPage
+			for more {
+				var page struct {
+					Organization struct {
+						Team struct {
+							Repositories teamRepositoryConnection `json:"repositories"`
+						} `json:"team"`
+					} `json:"organization"`
+				}
+				err := m.graphql(ctx, teamRepositoriesQuery, map[string]interface{}{
+					"organisation": m.organisation,
+					"slug":         team.Slug,
+					"first":        graphqlPageSize,
+					"cursor":       cursor,
+				}, &page)
+				if err != nil {
+					return nil, err
+				}
+				conn := page.Organization.Team.Repositories
+				collect(team.Slug, conn)
+				cursor, more = conn.PageInfo.EndCursor, conn.PageInfo.HasNextPage
+			}
+		}
+		if !teams.PageInfo.HasNextPage {
+			break
+		}
+		variables["cursor"] = teams.PageInfo.EndCursor
+	}
+	for repo := range access {
+		sortTeams(access[repo])
+	}
+	return access, nil
+}
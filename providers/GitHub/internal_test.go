@@ -1,9 +1,32 @@
 package github
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	overwatch "github.com/SeedJobs/devops-go-overwatch"
+	"github.com/SeedJobs/devops-go-overwatch/providers/GitHub/fake"
 	"github.com/SeedJobs/devops-go-overwatch/providers/default"
+	gogithub "github.com/google/go-github/github"
 )
 
 func TestLoadResourcesFromDisk(t *testing.T) {
@@ -19,3 +42,674 @@ func TestLoadResourcesFromDisk(t *testing.T) {
 		t.Logf("Got item %+v\n", item)
 	}
 }
+
+func TestTeamAccessFormats(t *testing.T) {
+	collection, err := projectTransformer([]byte(`---
+- Name: service
+  Teams:
+    - support
+    - Slug: platform
+      Permission: admin
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []teamAccess{
+		{Slug: "platform", Permission: "admin"},
+		{Slug: "support"},
+	}
+	if teams := collection[0].(project).Teams; !reflect.DeepEqual(teams, expected) {
+		t.Fatal("Expected", expected, "got", teams)
+	}
+	_, err = projectTransformer([]byte(`---
+- Name: service
+  Teams:
+    - Slug: platform
+      Permission: owner
+`))
+	if err == nil {
+		t.Fatal("Expected an unknown permission to be rejected")
+	}
+}
+
+func TestCollaboratorFormats(t *testing.T) {
+	collection, err := projectTransformer([]byte(`---
+- Name: service
+  Collaborators:
+    - Login: alice
+      Permission: admin
+    - Login: bob
+      Permission: ""
+    - Login: carol
+      Permission: unknown
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []collaborator{
+		{Login: "alice", Permission: "admin"},
+		{Login: "bob", Permission: permissionUnknown},
+		{Login: "carol", Permission: permissionUnknown},
+	}
+	if collaborators := collection[0].(project).Collaborators; !reflect.DeepEqual(collaborators, expected) {
+		t.Fatal("Expected", expected, "got", collaborators)
+	}
+}
+
+func TestTeamFormats(t *testing.T) {
+	collection, err := teamTransformer([]byte(`---
+- Slug: backend
+  Parent: engineering
+  Members:
+    - Login: carol
+    - Login: bob
+      Role: maintainer
+- Slug: design
+- Slug: empty
+  Members: []
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []teamMember{
+		{Login: "bob", Role: TeamRoleMaintainer},
+		{Login: "carol", Role: TeamRoleMember},
+	}
+	if members := collection[0].(team).Members; !reflect.DeepEqual(members, expected) {
+		t.Fatal("Expected", expected, "got", members)
+	}
+	// Members that were never stored are taken from Github rather than removed
+	actual := team{Slug: "design", Members: []teamMember{{Login: "dave", Role: TeamRoleMember}}}
+	if filled := collection[1].(team).fillUnset(actual); !reflect.DeepEqual(filled, actual) {
+		t.Fatal("Expected a team without stored members to take them from Github, got", filled.Members)
+	}
+	if members := collection[2].(team).Members; members == nil || len(members) != 0 {
+		t.Fatal("Expected a team stored without members to have an empty list, got", members)
+	}
+	_, err = teamTransformer([]byte(`---
+- Slug: backend
+  Members:
+    - Login: bob
+      Role: owner
+`))
+	if err == nil {
+		t.Fatal("Expected an unknown role to be rejected")
+	}
+}
+
+func TestProtectionFormats(t *testing.T) {
+	collection, err := projectTransformer([]byte(`---
+- Name: service
+  Protected:
+    - master
+    - Branch: develop
+      RequiredReviews: 1
+      RequiredStatusChecks: [ci/test, ci/build]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []branchProtection{
+		{
+			Branch:                    "develop",
+			RequirePullRequestReviews: true,
+			RequiredReviews:           1,
+			RequireStatusChecks:       true,
+			RequiredStatusChecks:      []string{"ci/build", "ci/test"},
+			PushUsers:                 []string{},
+			PushTeams:                 []string{},
+			stored: map[string]bool{
+				"Branch":                    true,
+				"RequirePullRequestReviews": true,
+				"RequiredReviews":           true,
+				"RequireStatusChecks":       true,
+				"RequiredStatusChecks":      true,
+			},
+		},
+		{
+			Branch:               "master",
+			RequiredStatusChecks: []string{},
+			PushUsers:            []string{},
+			PushTeams:            []string{},
+			legacy:               true,
+		},
+	}
+	if protected := collection[0].(project).Protected; !reflect.DeepEqual(protected, expected) {
+		t.Fatal("Expected", expected, "got", protected)
+	}
+}
+
+func TestUnstoredProtectionIsTakenFromGithub(t *testing.T) {
+	collection, err := projectTransformer([]byte(`---
+- Name: service
+  Protected:
+    - Branch: master
+      RequiredReviews: 2
+      AllowDeletions: false
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := project{Protected: []branchProtection{{
+		Branch:                    "master",
+		RequirePullRequestReviews: true,
+		RequiredReviews:           1,
+		RequireStatusChecks:       true,
+		RequiredStatusChecks:      []string{"ci/build"},
+		PushUsers:                 []string{},
+		PushTeams:                 []string{},
+		AllowForcePushes:          true,
+		AllowDeletions:            true,
+	}}}
+	expected := actual.Protected[0]
+	expected.RequiredReviews, expected.AllowDeletions = 2, false
+	if filled := collection[0].(project).fillUnset(actual).Protected[0]; !reflect.DeepEqual(filled, expected) {
+		t.Fatal("Expected only the stored settings to be kept, got", filled)
+	}
+}
+
+func TestUnreadableProtectionIsAnError(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddRepo("overwatch", fake.Repo{Name: "service", Branches: map[string]bool{"release/1.0": false}})
+	m := &manager{client: gogithub.NewClient(server.Client())}
+	if _, err := m.fetchBranchProtection(context.Background(), "overwatch", "service", "release/1.0"); err == nil {
+		t.Fatal("Expected protection that was not found to be an error rather than no protection")
+	}
+}
+
+func TestReadID(t *testing.T) {
+	additional := map[string]interface{}{"int": 7, "int64": int64(7), "string": "7"}
+	for name := range additional {
+		if id, ok, err := readID(additional, name); err != nil || !ok || id != 7 {
+			t.Fatal("Expected", name, "to be read as 7, got", id, ok, err)
+		}
+	}
+	if _, ok, err := readID(additional, "missing"); ok || err != nil {
+		t.Fatal("Expected a missing ID to not be defined, got", ok, err)
+	}
+}
+
+func TestDeployKeyFingerprint(t *testing.T) {
+	// Matches the output of ssh-keygen -l for the same key
+	key := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGrRLygOD05jnn93/zOXQewSVsBqqUyV84llHyfyQBsK deploy"
+	if got := fingerprint(key); got != "SHA256:TEW4I9ln01qKq24I191qLSwzMEKnZQkVEgKhm+53Ko8" {
+		t.Fatal("Unexpected fingerprint", got)
+	}
+	if got := fingerprint("not a key"); got != "" {
+		t.Fatal("Expected no fingerprint for an invalid key, got", got)
+	}
+}
+
+func TestForEachBoundsWorkers(t *testing.T) {
+	var (
+		mu            sync.Mutex
+		running, peak int
+	)
+	results := make([]int, 50)
+	err := abstract.ForEach(context.Background(), len(results), 4, func(ctx context.Context, i int) error {
+		mu.Lock()
+		if running++; running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		results[i] = i * i
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peak > 4 {
+		t.Fatal("Expected at most 4 workers, had", peak)
+	}
+	for i, v := range results {
+		if v != i*i {
+			t.Fatal("Result was not stored in order at index", i)
+		}
+	}
+}
+
+func TestForEachStopsOnFirstError(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		called int
+	)
+	err := abstract.ForEach(context.Background(), 1000, 2, func(ctx context.Context, i int) error {
+		mu.Lock()
+		called++
+		mu.Unlock()
+		if i == 3 {
+			return fmt.Errorf("failed on %d", i)
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "failed on 3" {
+		t.Fatal("Expected the worker error to be returned, got", err)
+	}
+	if called == 1000 {
+		t.Fatal("Expected the remaining work to be skipped after an error")
+	}
+}
+
+// newTestLimiter creates a transport against the fake server that
+// records how long it would have slept instead of sleeping.
+func newTestLimiter(server *fake.Server, additional map[string]interface{}) (*rateLimitTransport, *[]time.Duration) {
+	slept := &[]time.Duration{}
+	limiter := newRateLimitTransport(server.Client().Transport, additional)
+	limiter.sleep = func(ctx context.Context, d time.Duration) error {
+		*slept = append(*slept, d)
+		return nil
+	}
+	return limiter, slept
+}
+
+func listRepos(limiter *rateLimitTransport) error {
+	client := gogithub.NewClient(&http.Client{Transport: limiter})
+	_, _, err := client.Repositories.ListByOrg(context.Background(), "overwatch", nil)
+	return err
+}
+
+func TestRetriesServerErrors(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddOrg("overwatch")
+	server.FailNext(2, http.StatusBadGateway, 0)
+	limiter, slept := newTestLimiter(server, map[string]interface{}{})
+	if err := listRepos(limiter); err != nil {
+		t.Fatal("Expected the request to succeed after retrying, got", err)
+	}
+	if len(*slept) != 2 || (*slept)[0] != defaultRetryBackoff || (*slept)[1] != 2*defaultRetryBackoff {
+		t.Fatal("Expected exponential backoff between retries, got", *slept)
+	}
+}
+
+func TestGivesUpAfterMaxRetries(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddOrg("overwatch")
+	server.FailNext(5, http.StatusServiceUnavailable, 0)
+	limiter, _ := newTestLimiter(server, map[string]interface{}{"MaxRetries": 2})
+	if err := listRepos(limiter); err == nil {
+		t.Fatal("Expected an error once retries were exhausted")
+	}
+	if server.Calls() != 3 {
+		t.Fatal("Expected 3 attempts to be made, got", server.Calls())
+	}
+}
+
+func TestHonoursRetryAfter(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddOrg("overwatch")
+	server.FailNext(1, http.StatusForbidden, 7)
+	limiter, slept := newTestLimiter(server, map[string]interface{}{})
+	if err := listRepos(limiter); err != nil {
+		t.Fatal(err)
+	}
+	if len(*slept) != 1 || (*slept)[0] != 7*time.Second {
+		t.Fatal("Expected to wait for the Retry-After duration, got", *slept)
+	}
+}
+
+func TestWaitsForRateLimitReset(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddOrg("overwatch")
+	server.SetRateLimit(5000, 0, time.Now().Add(30*time.Second))
+	limiter, slept := newTestLimiter(server, map[string]interface{}{})
+	limiter.sleep = func(ctx context.Context, d time.Duration) error {
+		*slept = append(*slept, d)
+		// Waiting restores the budget
+		server.SetRateLimit(5000, 5000, time.Now().Add(time.Hour))
+		return nil
+	}
+	if err := listRepos(limiter); err != nil {
+		t.Fatal(err)
+	}
+	if len(*slept) != 1 || (*slept)[0] < 29*time.Second || (*slept)[0] > 32*time.Second {
+		t.Fatal("Expected to wait until the rate limit reset, got", *slept)
+	}
+	if rate := limiter.Rate(); rate.Limit != 5000 || rate.Remaining != 4999 {
+		t.Fatal("Expected the remaining budget to be recorded, got", rate)
+	}
+}
+
+func TestAbortsOnRateLimit(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddOrg("overwatch")
+	server.SetRateLimit(5000, 0, time.Now().Add(30*time.Second))
+	limiter, slept := newTestLimiter(server, map[string]interface{}{"RateLimitPolicy": PolicyAbort})
+	err := listRepos(limiter)
+	if _, ok := err.(*gogithub.RateLimitError); !ok {
+		t.Fatal("Expected a rate limit error, got", err)
+	}
+	if len(*slept) != 0 {
+		t.Fatal("Should not have waited when aborting, waited", *slept)
+	}
+}
+
+func TestDoesNotRetryUnsafeMethods(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddOrg("overwatch")
+	server.FailNext(1, http.StatusBadGateway, 0)
+	limiter, slept := newTestLimiter(server, map[string]interface{}{})
+	req, err := http.NewRequest(http.MethodPost, server.URL+"orgs/overwatch/repos", strings.NewReader(`{"name":"new"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := limiter.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || server.Calls() != 1 || len(*slept) != 0 {
+		t.Fatal("Expected the POST to be sent once, got", resp.StatusCode, "after", server.Calls(), "calls")
+	}
+}
+
+func TestRewindRequiresGetBody(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPut, "https://api.github.com/repos/o/r", ioutil.NopCloser(strings.NewReader("{}")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rewind(req, 1); err == nil {
+		t.Fatal("Expected a body that can not be recreated to fail the retry")
+	}
+}
+
+func TestRateLimitsPerResource(t *testing.T) {
+	limiter := newRateLimitTransport(nil, map[string]interface{}{})
+	respond := func(resource string, remaining int, reset time.Time) *http.Response {
+		header := http.Header{}
+		header.Set("X-RateLimit-Resource", resource)
+		header.Set("X-RateLimit-Limit", "5000")
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		header.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		return &http.Response{Header: header}
+	}
+	limiter.record(respond("core", 4000, time.Now().Add(time.Hour)), "core")
+	limiter.record(respond("graphql", 0, time.Now().Add(time.Hour)), "core")
+	if limiter.exhausted("core") || !limiter.exhausted("graphql") {
+		t.Fatal("Expected only the graphql budget to be exhausted")
+	}
+	if rate := limiter.Rate(); rate.Resource != "graphql" || rate.Remaining != 0 {
+		t.Fatal("Expected the lowest budget to be reported, got", rate)
+	}
+	if _, ok := limiter.untilReset(context.Background(), "graphql"); ok {
+		t.Fatal("Expected a wait beyond MaxRateLimitWait to be refused")
+	}
+	limiter.record(respond("graphql", 0, time.Now().Add(time.Minute)), "graphql")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, ok := limiter.untilReset(ctx, "graphql"); ok {
+		t.Fatal("Expected a wait beyond the deadline of the context to be refused")
+	}
+	if _, ok := limiter.untilReset(context.Background(), "graphql"); !ok {
+		t.Fatal("Expected a short wait to be allowed")
+	}
+}
+
+func TestCachedPagesDoNotUseRateLimit(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddRepo("overwatch", fake.Repo{Name: "cached"})
+	server.SetRateLimit(5000, 5000, time.Now().Add(time.Hour))
+	dir, err := ioutil.TempDir("", "overwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	list := func(transport http.RoundTripper) []*gogithub.Repository {
+		client := gogithub.NewClient(&http.Client{Transport: transport})
+		repos, _, err := client.Repositories.ListByOrg(context.Background(), "overwatch", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repos
+	}
+	limiter := newRateLimitTransport(server.Client().Transport, map[string]interface{}{})
+	list(newCacheTransport(limiter, dir, "", 0))
+	// A new transport reading the same directory simulates a restart
+	repos := list(newCacheTransport(limiter, dir, "", 0))
+	if len(repos) != 1 || repos[0].GetName() != "cached" {
+		t.Fatal("Expected the cached response to be returned, got", repos)
+	}
+	if server.NotModified() != 1 {
+		t.Fatal("Expected the second request to be conditional")
+	}
+	if rate := limiter.Rate(); rate.Remaining != 4999 {
+		t.Fatal("Expected the not modified response to be free, remaining is", rate.Remaining)
+	}
+	server.AddRepo("overwatch", fake.Repo{Name: "changed"})
+	if repos := list(newCacheTransport(limiter, dir, "", 0)); len(repos) != 2 {
+		t.Fatal("Expected the changed page to be fetched again, got", repos)
+	}
+}
+
+func TestCacheKeyIgnoresRotatingTokens(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddRepo("overwatch", fake.Repo{Name: "cached"})
+	cache := newCacheTransport(server.Client().Transport, "", "app:1/2", 0)
+	for _, token := range []string{"first", "refreshed"} {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"orgs/overwatch/repos", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "token "+token)
+		resp, err := cache.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		discard(resp)
+	}
+	if server.NotModified() != 1 {
+		t.Fatal("Expected the request with the refreshed token to be conditional")
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	for _, name := range []string{"a", "b", "c"} {
+		server.AddRepo("overwatch", fake.Repo{Name: name})
+	}
+	dir, err := ioutil.TempDir("", "overwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	get := func(cache *cacheTransport, name string) {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"repos/overwatch/"+name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := cache.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		discard(resp)
+	}
+	files := func() int {
+		found, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(found)
+	}
+	cache := newCacheTransport(server.Client().Transport, dir, "", 2)
+	get(cache, "a")
+	get(cache, "b")
+	get(cache, "a")
+	get(cache, "c")
+	if len(cache.entries) != 2 || files() != 2 {
+		t.Fatal("Expected the cache to be bounded to two entries, have", len(cache.entries), "in memory and", files(), "on disk")
+	}
+	get(cache, "a")
+	if server.NotModified() != 2 {
+		t.Fatal("Expected the recently used entry to be kept, not modified", server.NotModified())
+	}
+	// A restart with a smaller bound evicts what was left on disk
+	newCacheTransport(server.Client().Transport, dir, "", 1)
+	if files() != 1 {
+		t.Fatal("Expected the entries on disk to be evicted on start, have", files())
+	}
+}
+
+func TestWebhookSignatures(t *testing.T) {
+	handler := &WebhookHandler{secret: []byte("secret")}
+	payload := []byte(`{"zen":"Design for failure."}`)
+	for _, test := range []struct {
+		header    string
+		signature string
+		valid     bool
+	}{
+		{"X-Hub-Signature-256", "sha256=6ca9b7a2bd00f1ba3c6abd8df7ff1eee3cd1a8e0bc3fa6d1b8c7a32bbd16d1d5", false},
+		{"X-Hub-Signature-256", "sha256=not-hex", false},
+		{"X-Hub-Signature", "sha1=" + sign(sha1.New, "secret", payload), true},
+		{"X-Hub-Signature-256", "sha256=" + sign(sha256.New, "secret", payload), true},
+		{"X-Hub-Signature-256", "sha256=" + sign(sha256.New, "other", payload), false},
+	} {
+		header := http.Header{}
+		header.Set(test.header, test.signature)
+		if valid := handler.verify(header, payload); valid != test.valid {
+			t.Fatal("Expected", test.header, test.signature, "to be valid", test.valid, "got", valid)
+		}
+	}
+	if handler.verify(http.Header{}, payload) {
+		t.Fatal("Expected an unsigned payload to be rejected")
+	}
+}
+
+// blockingRechecker records each recheck and holds the first until released
+type blockingRechecker struct {
+	mu       sync.Mutex
+	checked  []string
+	running  int
+	parallel int
+	started  chan struct{}
+	release  chan struct{}
+}
+
+func (b *blockingRechecker) Recheck(kind, name string) ([]overwatch.IamResource, error) {
+	b.mu.Lock()
+	b.checked = append(b.checked, kind+"/"+name)
+	first := len(b.checked) == 1
+	b.running++
+	if b.running > b.parallel {
+		b.parallel = b.running
+	}
+	b.mu.Unlock()
+	if first {
+		close(b.started)
+		<-b.release
+	}
+	b.mu.Lock()
+	b.running--
+	b.mu.Unlock()
+	return nil, nil
+}
+
+func TestWebhookDeliveriesAreCoalesced(t *testing.T) {
+	rechecker := &blockingRechecker{started: make(chan struct{}), release: make(chan struct{})}
+	handler := &WebhookHandler{rechecker: rechecker, secret: []byte("secret")}
+	deliver := func(payload string) {
+		req, err := http.NewRequest(http.MethodPost, "/webhook", strings.NewReader(payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-GitHub-Event", "repository")
+		req.Header.Set("X-Hub-Signature-256", "sha256="+sign(sha256.New, "secret", []byte(payload)))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusAccepted {
+			t.Fatal("Expected the delivery to be accepted, got", rec.Code)
+		}
+	}
+	deliver(`{"repository":{"name":"service"}}`)
+	<-rechecker.started
+	// Deliveries while a recheck is running are queued once per resource
+	for i := 0; i < 10; i++ {
+		deliver(`{"repository":{"name":"service"}}`)
+		deliver(`{"repository":{"name":"website"}}`)
+	}
+	close(rechecker.release)
+	handler.Wait()
+	expected := []string{"Repo/service", "Repo/service", "Repo/website"}
+	if !reflect.DeepEqual(rechecker.checked, expected) {
+		t.Fatal("Expected the queued deliveries to be coalesced into", expected, "got", rechecker.checked)
+	}
+	if rechecker.parallel != 1 {
+		t.Fatal("Expected a single recheck at a time, got", rechecker.parallel)
+	}
+	// The worker starts again for deliveries after the queue was drained
+	deliver(`{"repository":{"name":"website"}}`)
+	handler.Wait()
+	if len(rechecker.checked) != 4 {
+		t.Fatal("Expected a later delivery to be rechecked, got", rechecker.checked)
+	}
+}
+
+func sign(hasher func() hash.Hash, secret string, payload []byte) string {
+	mac := hmac.New(hasher, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestReloadResetsSettings(t *testing.T) {
+	server := fake.NewServer()
+	defer server.Close()
+	server.AddOrg("overwatch")
+	dir, err := ioutil.TempDir("", "overwatch-github")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	additional := map[string]interface{}{
+		"GITHUB_TOKEN": "token",
+		"GITHUB_ORG":   "overwatch",
+		"HTTPClient":   server.Client(),
+		"Synchro":      "local",
+		"Location":     dir,
+		"Backend":      BackendGraphQL,
+		"Concurrency":  9,
+	}
+	man, _ := NewManager()
+	m := man.(*manager)
+	if err := m.LoadConfiguration(overwatch.IamManagerConfig{Additional: additional}); err != nil {
+		t.Fatal("Unable to LoadConfigurations due to", err)
+	}
+	delete(additional, "Backend")
+	delete(additional, "Concurrency")
+	if err := m.LoadConfiguration(overwatch.IamManagerConfig{Additional: additional}); err != nil {
+		t.Fatal("Unable to LoadConfigurations due to", err)
+	}
+	if m.backend != BackendREST || m.concurrency != defaultConcurrency {
+		t.Fatal("Expected the settings of the previous configuration to be dropped, got", m.backend, m.concurrency)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	additional["GITHUB_APP_ID"] = 42
+	additional["GITHUB_APP_PRIVATE_KEY"] = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := m.LoadConfiguration(overwatch.IamManagerConfig{Additional: additional}); err == nil {
+		t.Fatal("Expected an error configuring both a token and an app")
+	}
+	if m.client != nil {
+		t.Fatal("Expected the manager to be left unconfigured")
+	}
+}
+
+func TestRestPermission(t *testing.T) {
+	for permission, expected := range map[string]string{"WRITE": "push", "ADMIN": "admin", "AUDIT": "audit"} {
+		if got := restPermission(permission); got != expected {
+			t.Errorf("Expected %s to be converted to %s, got %s", permission, expected, got)
+		}
+	}
+}
@@ -2,12 +2,16 @@ package github
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
 	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	overwatch "github.com/SeedJobs/devops-go-overwatch"
@@ -17,43 +21,145 @@ import (
 	yaml "gopkg.in/yaml.v2"
 )
 
+// defaultConcurrency is the number of repos that are inspected at once
+// when Concurrency is not defined in the additional map.
+const defaultConcurrency = 8
+
+// transformers reads the store of each resource type
+var transformers = map[string]func([]byte) ([]overwatch.IamResource, error){
+	"Repo":    projectTransformer,
+	"Member":  memberTransformer,
+	"Setting": settingsTransformer,
+	"Team":    teamTransformer,
+}
+
 type manager struct {
+	// mu guards all fields as the manager can be used concurrently
+	mu           sync.Mutex
 	base         *abstract.Manager
 	organisation string
 	client       *gogithub.Client
 	resources    map[string]map[string]overwatch.IamResource
+	// teamLogins are the members of each team when they were last fetched
+	teamLogins map[string][]teamMember
+	// concurrency limits how many repos are inspected at once
+	concurrency int
+	// backend selects which Github API resources are fetched with
+	backend string
+	// host is set to the Github Enterprise Server being managed,
+	// it is empty for github.com
+	host       string
+	graphqlURL string
+	// policy decides which drift is corrected on Resync
+	policy abstract.Policy
+	// limiter is guarded by its own lock so the budget can be
+	// read while a scan is holding mu
+	limitMu sync.RWMutex
+	limiter *rateLimitTransport
 }
 
 func NewManager() (overwatch.IamPolicyManager, error) {
 	return &manager{
-		base:      abstract.DefaultManager(),
-		resources: map[string]map[string]overwatch.IamResource{},
+		base:        abstract.DefaultManager(),
+		resources:   map[string]map[string]overwatch.IamResource{},
+		concurrency: defaultConcurrency,
+		backend:     BackendREST,
 	}, nil
 }
 
+// LoadConfiguration builds the new configuration before replacing the current one,
+// any error leaves the manager unconfigured rather than partly configured.
 func (m *manager) LoadConfiguration(conf overwatch.IamManagerConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.client = nil
 	// Read all the Manager default configurations
 	if err := m.base.Readconfig(conf); err != nil {
 		return err
 	}
-	// Configure and store resources that are needed for the Client
-	var authclient *http.Client = nil
-	if token, exist := conf.Additional["GITHUB_TOKEN"].(string); exist {
-		ts := oauth2.StaticTokenSource(
+	org, exist := conf.Additional["GITHUB_ORG"].(string)
+	if !exist {
+		return fmt.Errorf("GITHUB_ORG was not defined in conf additional map")
+	}
+	concurrency := defaultConcurrency
+	if c, exist := conf.Additional["Concurrency"].(int); exist && c > 0 {
+		concurrency = c
+	}
+	backend := BackendREST
+	if b, exist := conf.Additional["Backend"].(string); exist {
+		switch strings.ToLower(b) {
+		case BackendREST, BackendGraphQL:
+			backend = strings.ToLower(b)
+		default:
+			return fmt.Errorf("Unknown Backend %s, expected %s or %s", b, BackendREST, BackendGraphQL)
+		}
+	}
+	policy, err := abstract.ReadPolicy(conf.Additional)
+	if err != nil {
+		return err
+	}
+	if _, err := readRateLimitPolicy(conf.Additional); err != nil {
+		return err
+	}
+	// Configure and store resources that are needed for the Client,
+	// HTTPClient allows the transport used to talk to Github to be replaced
+	var base http.RoundTripper
+	if client, ok := conf.Additional["HTTPClient"].(*http.Client); ok {
+		base = client.Transport
+	}
+	limiter := newRateLimitTransport(base, conf.Additional)
+	apiURL, uploadURL, err := enterpriseURLs(conf.Additional)
+	if err != nil {
+		return err
+	}
+	// The manager stays unconfigured rather than using any token it was given
+	appID, key, installation, isApp, err := readAppConfig(conf.Additional)
+	if err != nil {
+		return err
+	}
+	token, hasToken := conf.Additional["GITHUB_TOKEN"].(string)
+	if isApp && hasToken {
+		return fmt.Errorf("Only one of GITHUB_TOKEN or GITHUB_APP_ID can be defined in conf additional map")
+	}
+	// Unchanged pages are answered from the cache without using the rate limit,
+	// CacheDir allows the cache to persist between restarts
+	cacheDir, _ := conf.Additional["CacheDir"].(string)
+	maxEntries, _ := conf.Additional["CacheMaxEntries"].(int)
+	cache := newCacheTransport(limiter, cacheDir, credentialIdentity(appID, installation, token), maxEntries)
+	authclient := &http.Client{Transport: cache}
+	var ts oauth2.TokenSource
+	switch {
+	case isApp:
+		// Installation tokens only last an hour so are refreshed as they expire
+		ts = oauth2.ReuseTokenSource(nil, newAppTokenSource(appID, key, installation, org, authclient, apiURL, uploadURL))
+	case hasToken:
+		ts = oauth2.StaticTokenSource(
 			&oauth2.Token{AccessToken: token},
 		)
-		authclient = oauth2.NewClient(context.Background(), ts)
 	}
-	m.client = gogithub.NewClient(authclient)
-	if org, exist := conf.Additional["GITHUB_ORG"].(string); exist {
-		m.organisation = org
-	} else {
-		return fmt.Errorf("GITHUB_ORG was not defined in conf additional map")
+	if ts != nil {
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, authclient)
+		authclient = oauth2.NewClient(ctx, ts)
+	}
+	m.limitMu.Lock()
+	m.limiter = limiter
+	m.limitMu.Unlock()
+	m.host, m.graphqlURL = "", graphqlEndpoint(apiURL)
+	if apiURL != nil {
+		m.host = apiURL.Host
 	}
+	m.teamLogins = nil
+	m.organisation = org
+	m.concurrency = concurrency
+	m.backend = backend
+	m.policy = policy
+	m.client = newGithubClient(authclient, apiURL, uploadURL)
 	return m.readFromDisk()
 }
 
 func (m *manager) Resources() []overwatch.IamResource {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	collection := []overwatch.IamResource{}
 	for _, dict := range m.resources {
 		for _, obj := range dict {
@@ -63,25 +169,60 @@ func (m *manager) Resources() []overwatch.IamResource {
 	return collection
 }
 
+// credentialIdentity names the credentials without changing as installation tokens are refreshed
+func credentialIdentity(appID, installation int64, token string) string {
+	if appID != 0 {
+		return fmt.Sprintf("app:%d/%d", appID, installation)
+	}
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:])
+}
+
+// RateLimit returns the request budget with the fewest requests remaining
+func (m *manager) RateLimit() Rate {
+	m.limitMu.RLock()
+	defer m.limitMu.RUnlock()
+	if m.limiter == nil {
+		return Rate{}
+	}
+	return m.limiter.Rate()
+}
+
 // ListModifiedResources will examine resources loaded from Github and check
 // them against the expected store configuration.
 func (m *manager) ListModifiedResources() ([]overwatch.IamResource, error) {
-	collection, err := m.fetchOrgProjects()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.client == nil {
+		return nil, overwatch.ErrMisconfigured
+	}
+	collection, err := m.fetchResources()
 	if err != nil {
 		return nil, err
 	}
-	notcached, modified := m.seperateLists(collection)
-	return append(notcached, modified...), nil
+	notcached, modified, removed := m.seperateLists(collection)
+	return append(append(notcached, modified...), removed...), nil
 }
 
+// Resync stores any resources that have not been seen before and returns the
+// resources that differ from the store. The drift of each resource the
+// Enforcement policy enforces is corrected on Github, see plan.
 func (m *manager) Resync() ([]overwatch.IamResource, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.client == nil {
+		return nil, overwatch.ErrMisconfigured
+	}
 	items := []overwatch.IamResource{}
 	if time.Now().After(m.base.Expire) {
-		collection, err := m.fetchOrgProjects()
+		collection, err := m.fetchResources()
 		if err != nil {
 			return nil, err
 		}
-		notcached, modified := m.seperateLists(collection)
+		notcached, modified, _ := m.seperateLists(collection)
 		for _, item := range notcached {
 			if _, exist := m.resources[item.GetType()]; !exist {
 				m.resources[item.GetType()] = map[string]overwatch.IamResource{}
@@ -91,52 +232,84 @@ func (m *manager) Resync() ([]overwatch.IamResource, error) {
 		if err := m.writeToDisk(); err != nil {
 			return nil, err
 		}
+		if _, _, err := m.plan(modified).Apply(context.Background(), m.policy); err != nil {
+			return nil, err
+		}
 		items = append(items, modified...)
 		m.base.Expire = time.Now().Add(m.base.Conf.TimeOut)
 	}
 	return items, nil
 }
 
+// fetchResources returns every resource the manager looks after
+func (m *manager) fetchResources() ([]overwatch.IamResource, error) {
+	ctx := context.Background()
+	resources, err := m.fetchOrgProjects()
+	if err != nil {
+		return nil, err
+	}
+	members, err := m.fetchOrgMembers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	teams, err := m.fetchOrgTeams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := m.fetchOrgSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.rememberTeams(teams)
+	inTeam := m.inTeam()
+	for i, resource := range resources {
+		resources[i] = flagAdmins(resource.(project), inTeam)
+	}
+	resources = append(resources, members...)
+	resources = append(resources, teams...)
+	return append(resources, settings), nil
+}
+
+// fetchOrgProjects lists every repo inside the organisation and then inspects
+// each repo concurrently, the returned resources keep the order Github listed them in.
 func (m *manager) fetchOrgProjects() ([]overwatch.IamResource, error) {
+	if m.backend == BackendGraphQL {
+		return m.fetchOrgProjectsGraphQL()
+	}
+	ctx := context.Background()
+	repos, err := m.listOrgRepos(ctx)
+	if err != nil {
+		return nil, err
+	}
+	allRepos := make([]overwatch.IamResource, len(repos))
+	err = abstract.ForEach(ctx, len(repos), m.concurrency, func(ctx context.Context, i int) error {
+		repo, err := m.fetchProject(ctx, repos[i])
+		if err != nil {
+			return err
+		}
+		allRepos[i] = repo
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return allRepos, nil
+}
+
+func (m *manager) listOrgRepos(ctx context.Context) ([]*gogithub.Repository, error) {
 	opt := &gogithub.RepositoryListByOrgOptions{
 		ListOptions: gogithub.ListOptions{
 			PerPage: 64,
 		},
 	}
-	var allRepos []overwatch.IamResource
+	var allRepos []*gogithub.Repository
 	for {
 		// This call is limited by the token issuer as it can only see what the issuer can see inside the org
-		repos, resp, err := m.client.Repositories.ListByOrg(context.Background(), m.organisation, opt)
+		repos, resp, err := m.client.Repositories.ListByOrg(ctx, m.organisation, opt)
 		if err != nil {
 			return nil, err
 		}
-		for _, pro := range repos {
-			repo := project{
-				Name:      pro.GetName(),
-				Public:    !pro.GetPrivate(),
-				Protected: []string{},
-			}
-			branchOpts := &gogithub.ListOptions{}
-			for {
-				branches, branchresp, err := m.client.Repositories.ListBranches(context.Background(),
-					pro.GetOwner().GetLogin(),
-					pro.GetName(),
-					branchOpts)
-				if err != nil {
-					return nil, err
-				}
-				for _, branch := range branches {
-					if branch.GetProtected() {
-						repo.Protected = append(repo.Protected, branch.GetName())
-					}
-				}
-				if branchresp.NextPage == 0 {
-					break
-				}
-				branchOpts.Page = branchresp.NextPage
-			}
-			allRepos = append(allRepos, repo)
-		}
+		allRepos = append(allRepos, repos...)
 		if resp.NextPage == 0 {
 			break
 		}
@@ -145,9 +318,74 @@ func (m *manager) fetchOrgProjects() ([]overwatch.IamResource, error) {
 	return allRepos, nil
 }
 
-func (m *manager) seperateLists(collection []overwatch.IamResource) ([]overwatch.IamResource, []overwatch.IamResource) {
-	notcached, modified := []overwatch.IamResource{}, []overwatch.IamResource{}
+func (m *manager) fetchProject(ctx context.Context, pro *gogithub.Repository) (project, error) {
+	repo := project{
+		Name:          pro.GetName(),
+		Public:        !pro.GetPrivate(),
+		Protected:     []branchProtection{},
+		Teams:         []teamAccess{},
+		Collaborators: []collaborator{},
+		DeployKeys:    []deployKey{},
+		Webhooks:      []webhook{},
+	}
+	branchOpts := &gogithub.ListOptions{}
+	for {
+		branches, branchresp, err := m.client.Repositories.ListBranches(ctx,
+			pro.GetOwner().GetLogin(),
+			pro.GetName(),
+			branchOpts)
+		if err != nil {
+			return repo, err
+		}
+		for _, branch := range branches {
+			if !branch.GetProtected() {
+				continue
+			}
+			protection, err := m.fetchBranchProtection(ctx, pro.GetOwner().GetLogin(), pro.GetName(), branch.GetName())
+			if err != nil {
+				return repo, err
+			}
+			repo.Protected = append(repo.Protected, protection)
+		}
+		if branchresp.NextPage == 0 {
+			break
+		}
+		branchOpts.Page = branchresp.NextPage
+	}
+	sortProtection(repo.Protected)
+	teams, err := m.fetchTeamAccess(ctx, pro.GetOwner().GetLogin(), pro.GetName())
+	if err != nil {
+		return repo, err
+	}
+	repo.Teams = teams
+	if repo.Collaborators, err = m.fetchCollaborators(ctx, pro.GetOwner().GetLogin(), pro.GetName()); err != nil {
+		return repo, err
+	}
+	if repo.DeployKeys, err = m.fetchDeployKeys(ctx, pro.GetOwner().GetLogin(), pro.GetName()); err != nil {
+		return repo, err
+	}
+	if repo.Webhooks, err = m.fetchWebhooks(ctx, pro.GetOwner().GetLogin(), pro.GetName()); err != nil {
+		return repo, err
+	}
+	return repo, nil
+}
+
+// seperateLists compares the collection fetched from Github against the stored resources
+// and returns the items that are not stored, the items that differ from what
+// is stored and the stored items that no longer exist on Github.
+func (m *manager) seperateLists(collection []overwatch.IamResource) ([]overwatch.IamResource, []overwatch.IamResource, []overwatch.IamResource) {
+	notcached, modified, removed := []overwatch.IamResource{}, []overwatch.IamResource{}, []overwatch.IamResource{}
+	seen := map[string]map[string]bool{}
 	for _, item := range collection {
+		if _, exist := seen[item.GetType()]; !exist {
+			seen[item.GetType()] = map[string]bool{}
+		}
+		seen[item.GetType()][item.GetName()] = true
+		if s, ok := item.(orgSettings); ok && len(s.Unavailable) != 0 {
+			// Settings that could not be read are reported but never stored
+			modified = append(modified, item)
+			continue
+		}
 		if _, exist := m.resources[item.GetType()]; !exist {
 			notcached = append(notcached, item)
 			// early exit on the loop
@@ -157,11 +395,29 @@ func (m *manager) seperateLists(collection []overwatch.IamResource) ([]overwatch
 		switch {
 		case !exist:
 			notcached = append(notcached, item)
-		case !reflect.DeepEqual(item, obj):
+		case drifted(obj, item):
 			modified = append(modified, item)
 		}
 	}
-	return notcached, modified
+	for kind, items := range m.resources {
+		for name, obj := range items {
+			if !seen[kind][name] {
+				removed = append(removed, obj)
+			}
+		}
+	}
+	return notcached, modified, removed
+}
+
+// drifted reports whether current differs from what has been stored for it
+func drifted(stored, current overwatch.IamResource) bool {
+	switch s := stored.(type) {
+	case project:
+		stored = s.fillUnset(current.(project))
+	case team:
+		stored = s.fillUnset(current.(team))
+	}
+	return !reflect.DeepEqual(current, stored)
 }
 
 func (m *manager) readFromDisk() error {
@@ -169,24 +425,32 @@ func (m *manager) readFromDisk() error {
 	for key, _ := range m.resources {
 		delete(m.resources, key)
 	}
-	// Directory is made up of "path/<Provider>/<Organisation>/<ResourceType>/*.ya?ml"
-	dir := path.Join(m.base.Storer.GetPath(), "Github", m.organisation, "Repos")
-	loaded, err := abstract.ReadFiles(dir, projectTransformer)
-	if err != nil {
-		return err
-	}
-	for _, obj := range loaded {
-		if _, ok := m.resources[obj.GetType()]; !ok {
-			m.resources[obj.GetType()] = map[string]overwatch.IamResource{}
+	// Directory is made up of "path/<Provider>/[<Host>/]<Organisation>/<ResourceType>s/*.ya?ml"
+	for kind, transformer := range transformers {
+		dir := m.storeDir(kind)
+		loaded, err := abstract.ReadFiles(dir, transformer)
+		if err != nil {
+			return err
+		}
+		for _, obj := range loaded {
+			if _, ok := m.resources[obj.GetType()]; !ok {
+				m.resources[obj.GetType()] = map[string]overwatch.IamResource{}
+			}
+			m.resources[obj.GetType()][obj.GetName()] = obj
 		}
-		m.resources[obj.GetType()][obj.GetName()] = obj
 	}
 	return nil
 }
 
+// storeDir returns the directory a resource type is stored in, resources from a
+// Github Enterprise Server are kept apart from github.com by the host name.
+func (m *manager) storeDir(kind string) string {
+	return path.Join(m.base.Storer.GetPath(), "Github", m.host, m.organisation, kind+"s")
+}
+
 func (m *manager) writeToDisk() error {
 	for key, items := range m.resources {
-		dir := path.Join(m.base.Storer.GetPath(), "Github", m.organisation, key+"s")
+		dir := m.storeDir(key)
 		data := []overwatch.IamResource{}
 		for _, obj := range items {
 			data = append(data, obj)
@@ -195,6 +459,9 @@ func (m *manager) writeToDisk() error {
 		if err != nil {
 			return err
 		}
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
 		f := path.Join(dir, key+".yml")
 		// Removing any old file as need just to remove it
 		if _, err := os.Stat(f); !os.IsNotExist(err) {
@@ -0,0 +1,98 @@
+package github
+
+import (
+	"context"
+	"sort"
+
+	overwatch "github.com/SeedJobs/devops-go-overwatch"
+	gogithub "github.com/google/go-github/github"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// RoleAdmin is an owner of the organisation
+	RoleAdmin = "admin"
+	// RoleMember is a member of the organisation without owner rights
+	RoleMember = "member"
+	// RoleOutsideCollaborator has access to one or more repos
+	// without being a member of the organisation
+	RoleOutsideCollaborator = "outside_collaborator"
+)
+
+// member is anyone with access to the organisation and their role within it
+type member struct {
+	Login string `json:"Login" yaml:"Login"`
+	Role  string `json:"Role" yaml:"Role"`
+}
+
+func (m member) GetName() string {
+	return m.Login
+}
+
+func (m member) GetType() string {
+	return "Member"
+}
+
+func (m member) AppliedConfig() []overwatch.IamConfig {
+	return nil
+}
+
+func memberTransformer(buff []byte) ([]overwatch.IamResource, error) {
+	members := []member{}
+	if err := yaml.Unmarshal(buff, &members); err != nil {
+		return nil, err
+	}
+	collections := []overwatch.IamResource{}
+	for _, m := range members {
+		collections = append(collections, m)
+	}
+	return collections, nil
+}
+
+// fetchOrgMembers lists the owners, members and outside collaborators of the organisation
+func (m *manager) fetchOrgMembers(ctx context.Context) ([]overwatch.IamResource, error) {
+	members := []member{}
+	for _, role := range []string{RoleAdmin, RoleMember} {
+		opt := &gogithub.ListMembersOptions{
+			Role:        role,
+			ListOptions: gogithub.ListOptions{PerPage: 100},
+		}
+		for {
+			users, resp, err := m.client.Organizations.ListMembers(ctx, m.organisation, opt)
+			if err != nil {
+				return nil, err
+			}
+			for _, user := range users {
+				members = append(members, member{Login: user.GetLogin(), Role: role})
+			}
+			if resp.NextPage == 0 {
+				break
+			}
+			opt.Page = resp.NextPage
+		}
+	}
+	opt := &gogithub.ListOutsideCollaboratorsOptions{
+		ListOptions: gogithub.ListOptions{PerPage: 100},
+	}
+	for {
+		users, resp, err := m.client.Organizations.ListOutsideCollaborators(ctx, m.organisation, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			members = append(members, member{Login: user.GetLogin(), Role: RoleOutsideCollaborator})
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Login < members[j].Login
+	})
+	collection := []overwatch.IamResource{}
+	for _, m := range members {
+		collection = append(collection, m)
+	}
+	return collection, nil
+}
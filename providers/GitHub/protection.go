@@ -0,0 +1,269 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+)
+
+// branchProtection is the protection configured on a single branch.
+// Anything that is switched off or not required is left as its zero value.
+type branchProtection struct {
+	Branch string `json:"Branch" yaml:"Branch"`
+	// RequirePullRequestReviews and RequireStatusChecks turn the requirement on
+	// by itself, so that reviews are required even when no approvals are.
+	RequirePullRequestReviews bool     `json:"RequirePullRequestReviews" yaml:"RequirePullRequestReviews"`
+	RequiredReviews           int      `json:"RequiredReviews" yaml:"RequiredReviews"`
+	DismissStaleReviews       bool     `json:"DismissStaleReviews" yaml:"DismissStaleReviews"`
+	RequireCodeOwnerReviews   bool     `json:"RequireCodeOwnerReviews" yaml:"RequireCodeOwnerReviews"`
+	RequireStatusChecks       bool     `json:"RequireStatusChecks" yaml:"RequireStatusChecks"`
+	RequiredStatusChecks      []string `json:"RequiredStatusChecks" yaml:"RequiredStatusChecks"`
+	StrictStatusChecks        bool     `json:"StrictStatusChecks" yaml:"StrictStatusChecks"`
+	EnforceAdmins             bool     `json:"EnforceAdmins" yaml:"EnforceAdmins"`
+	// RestrictPushes limits pushing to PushUsers and PushTeams
+	RestrictPushes       bool     `json:"RestrictPushes" yaml:"RestrictPushes"`
+	PushUsers            []string `json:"PushUsers" yaml:"PushUsers"`
+	PushTeams            []string `json:"PushTeams" yaml:"PushTeams"`
+	RequireLinearHistory bool     `json:"RequireLinearHistory" yaml:"RequireLinearHistory"`
+	AllowForcePushes     bool     `json:"AllowForcePushes" yaml:"AllowForcePushes"`
+	AllowDeletions       bool     `json:"AllowDeletions" yaml:"AllowDeletions"`
+	// legacy is set when only the name of the branch was stored, what the branch
+	// is protected by is unknown so it is taken from Github and never enforced.
+	legacy bool
+	// stored are the settings named in the store, stores written before a
+	// setting existed leave it to Github rather than turning it off.
+	stored map[string]bool
+}
+
+// UnmarshalYAML also accepts the older format where only the protected branch name was stored
+func (b *branchProtection) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var branch string
+	if err := unmarshal(&branch); err == nil {
+		*b = branchProtection{Branch: branch, legacy: true}
+		b.normalise()
+		return nil
+	}
+	type plain branchProtection
+	if err := unmarshal((*plain)(b)); err != nil {
+		return err
+	}
+	settings := map[string]interface{}{}
+	if err := unmarshal(&settings); err != nil {
+		return err
+	}
+	b.stored = map[string]bool{}
+	for name := range settings {
+		b.stored[name] = true
+	}
+	b.normalise()
+	return nil
+}
+
+// fillUnset copies the settings of actual that were not stored
+func (b branchProtection) fillUnset(actual branchProtection) branchProtection {
+	if b.legacy {
+		return actual
+	}
+	desired, current := reflect.ValueOf(&b).Elem(), reflect.ValueOf(actual)
+	for i := 0; i < desired.NumField(); i++ {
+		if name := desired.Type().Field(i).Tag.Get("yaml"); name != "" && !b.stored[name] {
+			desired.Field(i).Set(current.Field(i))
+		}
+	}
+	b.stored = nil
+	return b
+}
+
+// normalise sorts the lists and replaces nil lists with empty ones
+// so that what is stored compares equal to what is fetched. Approvals
+// and status checks imply that they are required.
+func (b *branchProtection) normalise() {
+	for _, list := range []*[]string{&b.RequiredStatusChecks, &b.PushUsers, &b.PushTeams} {
+		if *list == nil {
+			*list = []string{}
+		}
+		sort.Strings(*list)
+	}
+	if b.RequiredReviews > 0 {
+		b.require(&b.RequirePullRequestReviews, "RequirePullRequestReviews")
+	}
+	if len(b.RequiredStatusChecks) != 0 || b.StrictStatusChecks {
+		b.require(&b.RequireStatusChecks, "RequireStatusChecks")
+	}
+}
+
+// require turns the setting on, counting it as stored when it is implied by the store
+func (b *branchProtection) require(setting *bool, name string) {
+	*setting = true
+	if b.stored != nil {
+		b.stored[name] = true
+	}
+}
+
+func sortProtection(protected []branchProtection) {
+	sort.Slice(protected, func(i, j int) bool {
+		return protected[i].Branch < protected[j].Branch
+	})
+}
+
+// protectionResponse is the part of the v3 branch protection response that is stored,
+// the vendored client predates several of these settings.
+type protectionResponse struct {
+	RequiredStatusChecks *struct {
+		Strict   bool     `json:"strict"`
+		Contexts []string `json:"contexts"`
+	} `json:"required_status_checks"`
+	RequiredPullRequestReviews *struct {
+		DismissStaleReviews          bool `json:"dismiss_stale_reviews"`
+		RequireCodeOwnerReviews      bool `json:"require_code_owner_reviews"`
+		RequiredApprovingReviewCount int  `json:"required_approving_review_count"`
+	} `json:"required_pull_request_reviews"`
+	EnforceAdmins *enabledSetting `json:"enforce_admins"`
+	Restrictions  *struct {
+		Users []struct {
+			Login string `json:"login"`
+		} `json:"users"`
+		Teams []struct {
+			Slug string `json:"slug"`
+		} `json:"teams"`
+	} `json:"restrictions"`
+	RequiredLinearHistory *enabledSetting `json:"required_linear_history"`
+	AllowForcePushes      *enabledSetting `json:"allow_force_pushes"`
+	AllowDeletions        *enabledSetting `json:"allow_deletions"`
+}
+
+type enabledSetting struct {
+	Enabled bool `json:"enabled"`
+}
+
+func (e *enabledSetting) enabled() bool {
+	return e != nil && e.Enabled
+}
+
+// protectionPath is the path of the protection of a branch,
+// branch names are able to contain slashes so are escaped.
+func protectionPath(owner, repo, branch string) string {
+	return fmt.Sprintf("repos/%v/%v/branches/%v/protection", owner, repo, url.PathEscape(branch))
+}
+
+// fetchBranchProtection reads the protection of a branch that Github reports as protected.
+// Github also reports a protected branch as not found when the credentials are not an admin of the repo.
+func (m *manager) fetchBranchProtection(ctx context.Context, owner, repo, branch string) (branchProtection, error) {
+	protection := branchProtection{Branch: branch}
+	req, err := m.client.NewRequest("GET", protectionPath(owner, repo, branch), nil)
+	if err != nil {
+		return protection, err
+	}
+	// Required for the approving review count on older Github Enterprise releases
+	req.Header.Set("Accept", "application/vnd.github.luke-cage-preview+json")
+	var resp protectionResponse
+	if r, err := m.client.Do(ctx, req, &resp); err != nil {
+		if r != nil && r.StatusCode == http.StatusNotFound {
+			return protection, fmt.Errorf("Unable to read the protection of branch %s in %s/%s, it was either removed or is not visible to the credentials: %w", branch, owner, repo, err)
+		}
+		return protection, err
+	}
+	if checks := resp.RequiredStatusChecks; checks != nil {
+		protection.RequireStatusChecks = true
+		protection.StrictStatusChecks = checks.Strict
+		protection.RequiredStatusChecks = checks.Contexts
+	}
+	if reviews := resp.RequiredPullRequestReviews; reviews != nil {
+		protection.RequirePullRequestReviews = true
+		protection.RequiredReviews = reviews.RequiredApprovingReviewCount
+		protection.DismissStaleReviews = reviews.DismissStaleReviews
+		protection.RequireCodeOwnerReviews = reviews.RequireCodeOwnerReviews
+	}
+	if restrictions := resp.Restrictions; restrictions != nil {
+		protection.RestrictPushes = true
+		for _, user := range restrictions.Users {
+			protection.PushUsers = append(protection.PushUsers, user.Login)
+		}
+		for _, team := range restrictions.Teams {
+			protection.PushTeams = append(protection.PushTeams, team.Slug)
+		}
+	}
+	protection.EnforceAdmins = resp.EnforceAdmins.enabled()
+	protection.RequireLinearHistory = resp.RequiredLinearHistory.enabled()
+	protection.AllowForcePushes = resp.AllowForcePushes.enabled()
+	protection.AllowDeletions = resp.AllowDeletions.enabled()
+	protection.normalise()
+	return protection, nil
+}
+
+// protectionRequest is the body used to replace the protection of a branch
+type protectionRequest struct {
+	RequiredStatusChecks *protectionStatusChecks `json:"required_status_checks"`
+	EnforceAdmins        bool                    `json:"enforce_admins"`
+	RequiredReviews      *protectionReviews      `json:"required_pull_request_reviews"`
+	Restrictions         *protectionRestrictions `json:"restrictions"`
+	RequireLinearHistory bool                    `json:"required_linear_history"`
+	AllowForcePushes     bool                    `json:"allow_force_pushes"`
+	AllowDeletions       bool                    `json:"allow_deletions"`
+}
+
+type protectionStatusChecks struct {
+	Strict   bool     `json:"strict"`
+	Contexts []string `json:"contexts"`
+}
+
+type protectionReviews struct {
+	DismissStaleReviews          bool `json:"dismiss_stale_reviews"`
+	RequireCodeOwnerReviews      bool `json:"require_code_owner_reviews"`
+	RequiredApprovingReviewCount int  `json:"required_approving_review_count"`
+}
+
+type protectionRestrictions struct {
+	Users []string `json:"users"`
+	Teams []string `json:"teams"`
+}
+
+// updateBranchProtection replaces the protection of the branch with the stored protection
+func (m *manager) updateBranchProtection(ctx context.Context, repo string, protection branchProtection) error {
+	body := protectionRequest{
+		EnforceAdmins:        protection.EnforceAdmins,
+		RequireLinearHistory: protection.RequireLinearHistory,
+		AllowForcePushes:     protection.AllowForcePushes,
+		AllowDeletions:       protection.AllowDeletions,
+	}
+	if protection.RequireStatusChecks {
+		body.RequiredStatusChecks = &protectionStatusChecks{
+			Strict:   protection.StrictStatusChecks,
+			Contexts: protection.RequiredStatusChecks,
+		}
+	}
+	if protection.RequirePullRequestReviews {
+		body.RequiredReviews = &protectionReviews{
+			DismissStaleReviews:          protection.DismissStaleReviews,
+			RequireCodeOwnerReviews:      protection.RequireCodeOwnerReviews,
+			RequiredApprovingReviewCount: protection.RequiredReviews,
+		}
+	}
+	if protection.RestrictPushes {
+		body.Restrictions = &protectionRestrictions{
+			Users: protection.PushUsers,
+			Teams: protection.PushTeams,
+		}
+	}
+	req, err := m.client.NewRequest("PUT", protectionPath(m.organisation, repo, protection.Branch), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github.luke-cage-preview+json")
+	_, err = m.client.Do(ctx, req, nil)
+	return err
+}
+
+// removeBranchProtection removes all protection from the branch
+func (m *manager) removeBranchProtection(ctx context.Context, repo, branch string) error {
+	req, err := m.client.NewRequest("DELETE", protectionPath(m.organisation, repo, branch), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github.loki-preview+json")
+	_, err = m.client.Do(ctx, req, nil)
+	return err
+}
@@ -0,0 +1,330 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	overwatch "github.com/SeedJobs/devops-go-overwatch"
+)
+
+const (
+	// PolicyWait sleeps until the rate limit resets before continuing
+	PolicyWait = "wait"
+	// PolicyAbort returns the rate limit error straight away
+	PolicyAbort = "abort"
+
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = time.Second
+	// defaultMaxWait is kept short as callers hold the manager while they wait
+	defaultMaxWait  = 5 * time.Minute
+	maxRetryBackoff = time.Minute
+)
+
+// Rate is the request budget Github has reported back for one resource
+type Rate struct {
+	// Resource is the budget the rate belongs to, such as core or graphql
+	Resource string
+	// Limit is the number of requests allowed per window
+	Limit int
+	// Remaining is the number of requests left in the current window
+	Remaining int
+	// Reset is when the current window ends and Remaining is restored
+	Reset time.Time
+}
+
+// RateLimiter is implemented by the Github manager so that anything
+// scheduling scans can see how much of the request budget is left.
+type RateLimiter interface {
+	// RateLimit returns the budget with the fewest requests remaining out of those
+	// reported by Github, a zero Rate means that no request has been made yet.
+	RateLimit() Rate
+}
+
+// rateLimitTransport sits underneath the Github client and honours the
+// rate limit headers, retrying transient failures with exponential backoff.
+type rateLimitTransport struct {
+	base       http.RoundTripper
+	policy     string
+	maxRetries int
+	backoff    time.Duration
+	maxWait    time.Duration
+	// sleep is replaced in tests so no real time has to pass
+	sleep func(context.Context, time.Duration) error
+
+	mu sync.Mutex
+	// rates are keyed by the resource they belong to, as the REST
+	// and GraphQL APIs are each given their own budget.
+	rates map[string]Rate
+}
+
+func newRateLimitTransport(base http.RoundTripper, additional map[string]interface{}) *rateLimitTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &rateLimitTransport{
+		base:       base,
+		policy:     PolicyWait,
+		maxRetries: defaultMaxRetries,
+		backoff:    defaultRetryBackoff,
+		maxWait:    defaultMaxWait,
+		sleep:      sleepContext,
+		rates:      map[string]Rate{},
+	}
+	if policy, err := readRateLimitPolicy(additional); err == nil {
+		t.policy = policy
+	}
+	if retries, ok := additional["MaxRetries"].(int); ok && retries >= 0 {
+		t.maxRetries = retries
+	}
+	if backoff, ok := additional["RetryBackoff"].(time.Duration); ok && backoff > 0 {
+		t.backoff = backoff
+	}
+	if wait, ok := additional["MaxRateLimitWait"].(time.Duration); ok {
+		t.maxWait = wait
+	}
+	return t
+}
+
+// readRateLimitPolicy checks RateLimitPolicy is one of the known policies
+func readRateLimitPolicy(additional map[string]interface{}) (string, error) {
+	policy, ok := additional["RateLimitPolicy"].(string)
+	if !ok {
+		return PolicyWait, nil
+	}
+	switch policy {
+	case PolicyWait, PolicyAbort:
+		return policy, nil
+	}
+	return "", fmt.Errorf("%w, unknown RateLimitPolicy %s, expected %s or %s", overwatch.ErrMisconfigured, policy, PolicyWait, PolicyAbort)
+}
+
+// Rate returns the budget with the fewest requests remaining
+func (t *rateLimitTransport) Rate() Rate {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var lowest Rate
+	for _, rate := range t.rates {
+		if lowest.Resource == "" || rate.Remaining < lowest.Remaining ||
+			(rate.Remaining == lowest.Remaining && rate.Resource < lowest.Resource) {
+			lowest = rate
+		}
+	}
+	return lowest
+}
+
+func (t *rateLimitTransport) rateFor(resource string) Rate {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rates[resource]
+}
+
+// RoundTrip waits for an exhausted budget before sending the request rather than
+// holding onto the response of the last request of a window. Rate limited requests
+// are retried as Github did not act on them, while server and network errors are
+// only retried for requests that are safe to send twice.
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	resource := requestResource(req)
+	if t.exhausted(resource) {
+		if wait, ok := t.untilReset(ctx, resource); ok {
+			if err := t.sleep(ctx, wait); err != nil {
+				return nil, err
+			}
+		}
+	}
+	retryable := idempotent(req)
+	for attempt := 0; ; attempt++ {
+		out, err := rewind(req, attempt)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := t.base.RoundTrip(out)
+		if err != nil {
+			// Network errors are treated as transient
+			if !retryable || attempt >= t.maxRetries || ctx.Err() != nil {
+				return nil, err
+			}
+			if err := t.sleep(ctx, t.delay(attempt)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		resource = t.record(resp, resource)
+		var wait time.Duration
+		switch {
+		case isRateLimited(resp):
+			reset, ok := t.untilReset(ctx, resource)
+			if !ok || attempt >= t.maxRetries {
+				return resp, nil
+			}
+			wait = reset
+		case isSecondaryLimited(resp):
+			wait = retryAfter(resp, t.delay(attempt))
+			if attempt >= t.maxRetries || !t.canWait(ctx, wait) {
+				return resp, nil
+			}
+		case resp.StatusCode >= http.StatusInternalServerError && retryable:
+			if attempt >= t.maxRetries {
+				return resp, nil
+			}
+			wait = t.delay(attempt)
+		default:
+			return resp, nil
+		}
+		discard(resp)
+		if err := t.sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// record stores the rate limit headers of the response if they are present
+// and returns the resource they belong to, which defaults to that of the request.
+func (t *rateLimitTransport) record(resp *http.Response, resource string) string {
+	if header := resp.Header.Get("X-RateLimit-Resource"); header != "" {
+		resource = header
+	}
+	limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	if err != nil {
+		return resource
+	}
+	remaining, _ := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	reset, _ := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// Concurrent responses can arrive out of order,
+	// within a window the lowest remaining count is the most recent
+	current := t.rates[resource]
+	if reset == current.Reset.Unix() && remaining > current.Remaining {
+		return resource
+	}
+	t.rates[resource] = Rate{
+		Resource:  resource,
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     time.Unix(reset, 0),
+	}
+	return resource
+}
+
+func (t *rateLimitTransport) exhausted(resource string) bool {
+	rate := t.rateFor(resource)
+	return rate.Limit != 0 && rate.Remaining == 0 && time.Now().Before(rate.Reset)
+}
+
+// untilReset returns how long to wait for the budget of the resource to be restored,
+// it reports false when the policy is to abort or the wait is too long.
+func (t *rateLimitTransport) untilReset(ctx context.Context, resource string) (time.Duration, bool) {
+	if t.policy == PolicyAbort {
+		return 0, false
+	}
+	wait := time.Until(t.rateFor(resource).Reset)
+	if wait < 0 {
+		wait = 0
+	}
+	// Allow for clock drift between us and Github
+	wait += time.Second
+	return wait, t.canWait(ctx, wait)
+}
+
+// canWait reports whether waiting is within MaxRateLimitWait and the deadline of ctx
+func (t *rateLimitTransport) canWait(ctx context.Context, wait time.Duration) bool {
+	if wait > t.maxWait {
+		return false
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+		return false
+	}
+	return true
+}
+
+// requestResource is the budget the request is expected to use until Github says otherwise
+func requestResource(req *http.Request) string {
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/graphql"):
+		return "graphql"
+	case strings.HasPrefix(strings.TrimPrefix(req.URL.Path, "/api/v3"), "/search/"):
+		return "search"
+	}
+	return "core"
+}
+
+// idempotent reports whether the request is safe to send again after a server or
+// network error, when Github may already have acted on the first attempt.
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// delay returns the exponential backoff for the attempt
+func (t *rateLimitTransport) delay(attempt int) time.Duration {
+	d := t.backoff << uint(attempt)
+	if d <= 0 || d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	return d
+}
+
+func isRateLimited(resp *http.Response) bool {
+	return (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) &&
+		resp.Header.Get("X-RateLimit-Remaining") == "0"
+}
+
+func isSecondaryLimited(resp *http.Response) bool {
+	return (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusTooManyRequests) &&
+		resp.Header.Get("Retry-After") != ""
+}
+
+func retryAfter(resp *http.Response, fallback time.Duration) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil {
+		return fallback
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// rewind returns a request that can be sent for the attempt, any body has
+// to be recreated as the previous attempt consumed it. A body that is not
+// able to be recreated fails the retry rather than sending it half read.
+func rewind(req *http.Request, attempt int) (*http.Request, error) {
+	if attempt == 0 || req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	if req.GetBody == nil {
+		return nil, fmt.Errorf("Unable to retry %s %s as its body can not be sent again", req.Method, req.URL)
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	out := req.WithContext(req.Context())
+	out.Body = body
+	return out, nil
+}
+
+// discard reads the rest of the body so the connection can be reused
+func discard(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
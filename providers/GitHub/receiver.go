@@ -0,0 +1,242 @@
+package github
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	overwatch "github.com/SeedJobs/devops-go-overwatch"
+)
+
+// maxPayloadSize is the largest webhook payload Github will send
+const maxPayloadSize = 25 << 20
+
+// WebhookHandler receives the webhooks of an organisation and rechecks the
+// resources each event affects, so drift is seen without waiting for a scan.
+// Deliveries are acknowledged before they are rechecked, as Github gives up
+// on a delivery after ten seconds. The affected resources are queued and
+// rechecked one at a time, a resource queued by several deliveries is only
+// rechecked once.
+type WebhookHandler struct {
+	rechecker Rechecker
+	secret    []byte
+	// alert is called with the drift found by each batch of rechecks
+	alert func([]overwatch.IamResource)
+	// failed is called when a resource could not be rechecked
+	failed func(error)
+	// pending tracks the worker while it drains the queue
+	pending sync.WaitGroup
+	// mu guards the queue and whether the worker is running
+	mu      sync.Mutex
+	queue   []target
+	queued  map[target]bool
+	running bool
+}
+
+// NewWebhookHandler returns a handler for the organisation webhooks that verifies
+// each delivery was signed with secret, alert is called whenever an event leads to drift
+// and failed whenever the resources of an event could not be rechecked.
+// The manager must be a Github manager that has been configured.
+func NewWebhookHandler(manager overwatch.IamPolicyManager, secret []byte, alert func([]overwatch.IamResource), failed func(error)) (*WebhookHandler, error) {
+	rechecker, ok := manager.(Rechecker)
+	if !ok {
+		return nil, fmt.Errorf("Manager %T is unable to recheck Github resources", manager)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("A webhook secret is required to verify deliveries")
+	}
+	return &WebhookHandler{
+		rechecker: rechecker,
+		secret:    secret,
+		alert:     alert,
+		failed:    failed,
+	}, nil
+}
+
+// webhookEvent holds the parts of an event payload used to find the affected resources
+type webhookEvent struct {
+	Repository *struct {
+		Name string `json:"name"`
+	} `json:"repository"`
+	Team *struct {
+		Slug string `json:"slug"`
+	} `json:"team"`
+	Member *struct {
+		Login string `json:"login"`
+	} `json:"member"`
+	Membership *struct {
+		User struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	} `json:"membership"`
+	Changes struct {
+		Repository struct {
+			Name struct {
+				From string `json:"from"`
+			} `json:"name"`
+		} `json:"repository"`
+	} `json:"changes"`
+}
+
+// target is a resource that an event may have changed
+type target struct {
+	kind string
+	name string
+}
+
+// targets returns the resources affected by the event, nothing is returned
+// for the events that do not change anything the manager looks after.
+func (e webhookEvent) targets(event string) []target {
+	targets := []target{}
+	add := func(kind, name string) {
+		if name != "" {
+			targets = append(targets, target{kind: kind, name: name})
+		}
+	}
+	repo := ""
+	if e.Repository != nil {
+		repo = e.Repository.Name
+	}
+	switch event {
+	case "repository":
+		add("Repo", repo)
+		// A renamed repo is removed under its old name
+		add("Repo", e.Changes.Repository.Name.From)
+	case "branch_protection_rule":
+		add("Repo", repo)
+	case "member":
+		add("Repo", repo)
+		if e.Member != nil {
+			add("Member", e.Member.Login)
+		}
+	case "team", "team_add", "membership":
+		if e.Team != nil {
+			add("Team", e.Team.Slug)
+		}
+		add("Repo", repo)
+	case "organization":
+		if e.Membership != nil {
+			add("Member", e.Membership.User.Login)
+		} else {
+			// The settings are named after the organisation by Recheck
+			targets = append(targets, target{kind: "Setting"})
+		}
+	}
+	return targets
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPayloadSize))
+	if err != nil {
+		http.Error(w, "Unable to read payload", http.StatusBadRequest)
+		return
+	}
+	if !h.verify(r.Header, payload) {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	var event webhookEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		http.Error(w, "Unable to parse payload", http.StatusBadRequest)
+		return
+	}
+	h.enqueue(event.targets(r.Header.Get("X-GitHub-Event")))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Wait blocks until the deliveries that have been accepted are rechecked
+func (h *WebhookHandler) Wait() {
+	h.pending.Wait()
+}
+
+// enqueue adds the targets that are not queued yet and starts the worker if it is idle
+func (h *WebhookHandler) enqueue(targets []target) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.queued == nil {
+		h.queued = map[target]bool{}
+	}
+	for _, target := range targets {
+		if !h.queued[target] {
+			h.queued[target] = true
+			h.queue = append(h.queue, target)
+		}
+	}
+	if !h.running && len(h.queue) != 0 {
+		h.running = true
+		h.pending.Add(1)
+		go h.work()
+	}
+}
+
+// work rechecks the queued targets until the queue is empty, targets queued
+// while a batch is rechecked are rechecked again as they may have changed since.
+func (h *WebhookHandler) work() {
+	defer h.pending.Done()
+	for {
+		h.mu.Lock()
+		targets := h.queue
+		if len(targets) == 0 {
+			h.running = false
+			h.mu.Unlock()
+			return
+		}
+		h.queue, h.queued = nil, map[target]bool{}
+		h.mu.Unlock()
+		h.recheck(targets)
+	}
+}
+
+// recheck reports the drift of the targets
+func (h *WebhookHandler) recheck(targets []target) {
+	drift := []overwatch.IamResource{}
+	for _, target := range targets {
+		found, err := h.rechecker.Recheck(target.kind, target.name)
+		if err != nil {
+			if h.failed != nil {
+				h.failed(fmt.Errorf("Unable to recheck %s %s: %w", target.kind, target.name, err))
+			}
+			continue
+		}
+		drift = append(drift, found...)
+	}
+	if len(drift) != 0 && h.alert != nil {
+		h.alert(drift)
+	}
+}
+
+// verify checks the payload was signed with the secret, preferring
+// the SHA256 signature over the older SHA1 one when both are sent.
+func (h *WebhookHandler) verify(header http.Header, payload []byte) bool {
+	var (
+		signature string
+		hasher    func() hash.Hash
+	)
+	switch {
+	case header.Get("X-Hub-Signature-256") != "":
+		signature, hasher = strings.TrimPrefix(header.Get("X-Hub-Signature-256"), "sha256="), sha256.New
+	case header.Get("X-Hub-Signature") != "":
+		signature, hasher = strings.TrimPrefix(header.Get("X-Hub-Signature"), "sha1="), sha1.New
+	default:
+		return false
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(hasher, h.secret)
+	mac.Write(payload)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
@@ -0,0 +1,165 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	overwatch "github.com/SeedJobs/devops-go-overwatch"
+	gogithub "github.com/google/go-github/github"
+)
+
+// Rechecker is implemented by the Github manager so that a single
+// resource can be checked for drift without scanning the organisation.
+type Rechecker interface {
+	// Recheck fetches the resource of the type, such as "Repo", and name from Github.
+	// It returns the resource when it differs from the store, or the stored
+	// version when it no longer exists. Checking a team also checks the teams
+	// above it, as they count the members of the team as their own.
+	Recheck(kind, name string) ([]overwatch.IamResource, error)
+}
+
+// Recheck compares a single resource on Github against the store
+func (m *manager) Recheck(kind, name string) ([]overwatch.IamResource, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.client == nil {
+		return nil, overwatch.ErrMisconfigured
+	}
+	ctx := context.Background()
+	var (
+		current overwatch.IamResource
+		err     error
+	)
+	switch kind {
+	case "Repo":
+		current, err = m.fetchRepo(ctx, name)
+	case "Member":
+		current, err = m.fetchMember(ctx, name)
+	case "Team":
+		return m.recheckTeams(ctx, name)
+	case "Setting":
+		// There is only one set of settings, named after the organisation
+		name = m.organisation
+		current, err = m.fetchOrgSettings(ctx)
+	default:
+		return nil, fmt.Errorf("Unable to recheck unknown resource type %s", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m.compare(kind, name, current), nil
+}
+
+// compare returns the drift between the stored resource and current,
+// which is nil when the resource does not exist on Github.
+func (m *manager) compare(kind, name string, current overwatch.IamResource) []overwatch.IamResource {
+	stored, exist := m.resources[kind][name]
+	switch {
+	case current == nil && exist:
+		return []overwatch.IamResource{stored}
+	case current == nil:
+		return []overwatch.IamResource{}
+	case !exist || drifted(stored, current):
+		return []overwatch.IamResource{current}
+	}
+	return []overwatch.IamResource{}
+}
+
+// fetchRepo returns the repo from Github, or nil if it does not exist
+func (m *manager) fetchRepo(ctx context.Context, name string) (overwatch.IamResource, error) {
+	repo, resp, err := m.client.Repositories.Get(ctx, m.organisation, name)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	pro, err := m.fetchProject(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	// Flagging admins requires everyone in a team to be known, the teams are
+	// only listed when they have not been seen by a scan or recheck yet.
+	if m.teamLogins == nil {
+		teams, err := m.fetchOrgTeams(ctx)
+		if err != nil {
+			return nil, err
+		}
+		m.rememberTeams(teams)
+	}
+	return flagAdmins(pro, m.inTeam()), nil
+}
+
+// fetchMember returns the role of the login inside the organisation,
+// or nil if it is neither a member nor an outside collaborator.
+// Pending invitations are not counted, the same as when listing members.
+func (m *manager) fetchMember(ctx context.Context, login string) (overwatch.IamResource, error) {
+	membership, resp, err := m.client.Organizations.GetOrgMembership(ctx, login, m.organisation)
+	switch {
+	case err == nil && membership.GetState() == "active":
+		return member{Login: login, Role: membership.GetRole()}, nil
+	case err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound):
+		return nil, err
+	}
+	opt := &gogithub.ListOutsideCollaboratorsOptions{
+		ListOptions: gogithub.ListOptions{PerPage: 100},
+	}
+	for {
+		users, resp, err := m.client.Organizations.ListOutsideCollaborators(ctx, m.organisation, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			if user.GetLogin() == login {
+				return member{Login: login, Role: RoleOutsideCollaborator}, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// recheckTeams compares the team and every team above it against the store.
+// The parents from both Github and the store are followed so moving a team
+// is reported on its old and new parents.
+func (m *manager) recheckTeams(ctx context.Context, slug string) ([]overwatch.IamResource, error) {
+	teams, err := m.listOrgTeams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]*gogithub.Team{}
+	for _, t := range teams {
+		found[t.GetSlug()] = t
+	}
+	drift := []overwatch.IamResource{}
+	checked := map[string]bool{}
+	for pending := []string{slug}; len(pending) != 0; pending = pending[1:] {
+		slug := pending[0]
+		if slug == "" || checked[slug] {
+			continue
+		}
+		checked[slug] = true
+		var current overwatch.IamResource
+		if t, exist := found[slug]; exist {
+			fetched, err := m.fetchTeam(ctx, t)
+			if err != nil {
+				return nil, err
+			}
+			current = fetched
+			pending = append(pending, fetched.Parent)
+			if m.teamLogins != nil {
+				m.teamLogins[slug] = fetched.Members
+			}
+		} else {
+			delete(m.teamLogins, slug)
+		}
+		if stored, exist := m.resources["Team"][slug].(team); exist {
+			pending = append(pending, stored.Parent)
+		}
+		drift = append(drift, m.compare("Team", slug, current)...)
+	}
+	return drift, nil
+}
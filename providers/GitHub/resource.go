@@ -1,15 +1,45 @@
 package github
 
 import (
+	"fmt"
+	"sort"
+
 	overwatch "github.com/SeedJobs/devops-go-overwatch"
 	yaml "gopkg.in/yaml.v2"
 )
 
+// permissionUnknown is stored for a collaborator whose permission Github does not
+// report as one the manager recognises. It is reported but never granted.
+const permissionUnknown = "unknown"
+
+// teamPermissions are the permissions a team can be granted on a repo
+var teamPermissions = map[string]bool{
+	"pull":     true,
+	"triage":   true,
+	"push":     true,
+	"maintain": true,
+	"admin":    true,
+}
+
 type project struct {
-	Name      string   `json:"Name" yaml:"Name"`
-	Protected []string `json:"Protected" yaml:"Protected"`
-	Public    bool     `json:"Public" yaml:"Public"`
-	Teams     []string `json:"Teams" yaml:"Teams"`
+	Name      string             `json:"Name" yaml:"Name"`
+	Protected []branchProtection `json:"Protected" yaml:"Protected"`
+	Public    bool               `json:"Public" yaml:"Public"`
+	// Teams is nil when the team access of the repo has not been stored
+	Teams []teamAccess `json:"Teams" yaml:"Teams"`
+	// Collaborators have been granted access directly rather than through a team,
+	// it is nil when the collaborators of the repo have not been stored.
+	Collaborators []collaborator `json:"Collaborators" yaml:"Collaborators"`
+	// DeployKeys and Webhooks are only reported, they are never changed on Resync
+	DeployKeys []deployKey `json:"DeployKeys" yaml:"DeployKeys"`
+	Webhooks   []webhook   `json:"Webhooks" yaml:"Webhooks"`
+}
+
+// teamAccess is the permission a team has been granted on a repo,
+// an empty permission has not been stored and is not enforced.
+type teamAccess struct {
+	Slug       string `json:"Slug" yaml:"Slug"`
+	Permission string `json:"Permission" yaml:"Permission"`
 }
 
 func (p project) GetName() string {
@@ -21,7 +51,77 @@ func (p project) GetType() string {
 }
 
 func (p project) AppliedConfig() []overwatch.IamConfig {
-	return nil
+	config := []overwatch.IamConfig{}
+	for _, team := range p.Teams {
+		config = append(config, team)
+	}
+	for _, c := range p.Collaborators {
+		config = append(config, c)
+	}
+	return config
+}
+
+func (t teamAccess) GetName() string {
+	return t.Slug
+}
+
+func (t teamAccess) String() string {
+	return t.Slug + ":" + t.Permission
+}
+
+// UnmarshalYAML also accepts the older format where only the team slug was stored
+func (t *teamAccess) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var slug string
+	if err := unmarshal(&slug); err == nil {
+		t.Slug = slug
+		return nil
+	}
+	type plain teamAccess
+	return unmarshal((*plain)(t))
+}
+
+// sortTeams orders team access by slug so that the order
+// Github or the store lists them in does not count as drift.
+func sortTeams(teams []teamAccess) {
+	sort.Slice(teams, func(i, j int) bool {
+		return teams[i].Slug < teams[j].Slug
+	})
+}
+
+// fillUnset copies what Github reports for the settings that have not been
+// stored, so that they are neither reported as drift nor enforced.
+func (p project) fillUnset(actual project) project {
+	if p.Teams == nil {
+		p.Teams = actual.Teams
+	}
+	if p.Collaborators == nil {
+		p.Collaborators = actual.Collaborators
+	}
+	permissions := map[string]string{}
+	for _, team := range actual.Teams {
+		permissions[team.Slug] = team.Permission
+	}
+	teams := make([]teamAccess, len(p.Teams))
+	for i, team := range p.Teams {
+		if team.Permission == "" {
+			team.Permission = permissions[team.Slug]
+		}
+		teams[i] = team
+	}
+	p.Teams = teams
+	protection := map[string]branchProtection{}
+	for _, branch := range actual.Protected {
+		protection[branch.Branch] = branch
+	}
+	protected := make([]branchProtection, len(p.Protected))
+	for i, branch := range p.Protected {
+		if existing, exist := protection[branch.Branch]; exist {
+			branch = branch.fillUnset(existing)
+		}
+		protected[i] = branch
+	}
+	p.Protected = protected
+	return p
 }
 
 func projectTransformer(buff []byte) ([]overwatch.IamResource, error) {
@@ -31,6 +131,41 @@ func projectTransformer(buff []byte) ([]overwatch.IamResource, error) {
 	}
 	collections := []overwatch.IamResource{}
 	for _, pro := range projects {
+		// Ensure empty lists compare equal to what is fetched from Github
+		if pro.Protected == nil {
+			pro.Protected = []branchProtection{}
+		}
+		sortProtection(pro.Protected)
+		if pro.DeployKeys == nil {
+			pro.DeployKeys = []deployKey{}
+		}
+		if pro.Webhooks == nil {
+			pro.Webhooks = []webhook{}
+		}
+		for i := range pro.Webhooks {
+			if pro.Webhooks[i].Events == nil {
+				pro.Webhooks[i].Events = []string{}
+			}
+		}
+		sortDeployKeys(pro.DeployKeys)
+		sortWebhooks(pro.Webhooks)
+		for _, team := range pro.Teams {
+			if team.Permission != "" && !teamPermissions[team.Permission] {
+				return nil, fmt.Errorf("Unknown permission %s for team %s on %s", team.Permission, team.Slug, pro.Name)
+			}
+		}
+		sortTeams(pro.Teams)
+		for i, c := range pro.Collaborators {
+			// Stores written before unknown permissions were named left them empty
+			if c.Permission == "" {
+				pro.Collaborators[i].Permission = permissionUnknown
+				continue
+			}
+			if c.Permission != permissionUnknown && !teamPermissions[c.Permission] {
+				return nil, fmt.Errorf("Unknown permission %s for collaborator %s on %s", c.Permission, c.Login, pro.Name)
+			}
+		}
+		sortCollaborators(pro.Collaborators)
 		collections = append(collections, pro)
 	}
 	return collections, nil
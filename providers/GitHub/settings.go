@@ -0,0 +1,97 @@
+package github
+
+import (
+	"context"
+	"fmt"
+
+	overwatch "github.com/SeedJobs/devops-go-overwatch"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// orgSettings are the organisation wide security settings,
+// loosening any of them weakens the protection of every repo.
+type orgSettings struct {
+	Organisation                 string `json:"Organisation" yaml:"Organisation"`
+	TwoFactorRequired            bool   `json:"TwoFactorRequired" yaml:"TwoFactorRequired"`
+	DefaultRepositoryPermission  string `json:"DefaultRepositoryPermission" yaml:"DefaultRepositoryPermission"`
+	MembersCanCreatePublicRepos  bool   `json:"MembersCanCreatePublicRepos" yaml:"MembersCanCreatePublicRepos"`
+	MembersCanCreatePrivateRepos bool   `json:"MembersCanCreatePrivateRepos" yaml:"MembersCanCreatePrivateRepos"`
+	MembersCanForkPrivateRepos   bool   `json:"MembersCanForkPrivateRepos" yaml:"MembersCanForkPrivateRepos"`
+	WebCommitSignoffRequired     bool   `json:"WebCommitSignoffRequired" yaml:"WebCommitSignoffRequired"`
+	// Unavailable lists the settings Github did not report, they are never stored
+	Unavailable []string `json:"Unavailable,omitempty" yaml:"-"`
+}
+
+func (o orgSettings) GetName() string {
+	return o.Organisation
+}
+
+func (o orgSettings) GetType() string {
+	return "Setting"
+}
+
+func (o orgSettings) AppliedConfig() []overwatch.IamConfig {
+	return nil
+}
+
+func settingsTransformer(buff []byte) ([]overwatch.IamResource, error) {
+	settings := []orgSettings{}
+	if err := yaml.Unmarshal(buff, &settings); err != nil {
+		return nil, err
+	}
+	collections := []overwatch.IamResource{}
+	for _, s := range settings {
+		collections = append(collections, s)
+	}
+	return collections, nil
+}
+
+// fetchOrgSettings reads the security settings of the organisation,
+// the vendored client predates most of them so the response is decoded here.
+// Github leaves the settings out unless the credentials are an owner of the organisation,
+// in which case only the missing settings are returned as Unavailable.
+func (m *manager) fetchOrgSettings(ctx context.Context) (overwatch.IamResource, error) {
+	req, err := m.client.NewRequest("GET", fmt.Sprintf("orgs/%v", m.organisation), nil)
+	if err != nil {
+		return nil, err
+	}
+	var org struct {
+		TwoFactorRequirementEnabled         *bool   `json:"two_factor_requirement_enabled"`
+		DefaultRepositoryPermission         *string `json:"default_repository_permission"`
+		MembersCanCreatePublicRepositories  *bool   `json:"members_can_create_public_repositories"`
+		MembersCanCreatePrivateRepositories *bool   `json:"members_can_create_private_repositories"`
+		MembersCanForkPrivateRepositories   *bool   `json:"members_can_fork_private_repositories"`
+		WebCommitSignoffRequired            *bool   `json:"web_commit_signoff_required"`
+	}
+	if _, err := m.client.Do(ctx, req, &org); err != nil {
+		return nil, err
+	}
+	missing := []string{}
+	for _, field := range []struct {
+		name    string
+		missing bool
+	}{
+		{"two_factor_requirement_enabled", org.TwoFactorRequirementEnabled == nil},
+		{"default_repository_permission", org.DefaultRepositoryPermission == nil},
+		{"members_can_create_public_repositories", org.MembersCanCreatePublicRepositories == nil},
+		{"members_can_create_private_repositories", org.MembersCanCreatePrivateRepositories == nil},
+		{"members_can_fork_private_repositories", org.MembersCanForkPrivateRepositories == nil},
+		{"web_commit_signoff_required", org.WebCommitSignoffRequired == nil},
+	} {
+		if field.missing {
+			missing = append(missing, field.name)
+		}
+	}
+	if len(missing) != 0 {
+		return orgSettings{Organisation: m.organisation, Unavailable: missing}, nil
+	}
+	return orgSettings{
+		Organisation:                 m.organisation,
+		TwoFactorRequired:            *org.TwoFactorRequirementEnabled,
+		DefaultRepositoryPermission:  *org.DefaultRepositoryPermission,
+		MembersCanCreatePublicRepos:  *org.MembersCanCreatePublicRepositories,
+		MembersCanCreatePrivateRepos: *org.MembersCanCreatePrivateRepositories,
+		MembersCanForkPrivateRepos:   *org.MembersCanForkPrivateRepositories,
+		WebCommitSignoffRequired:     *org.WebCommitSignoffRequired,
+	}, nil
+}
@@ -0,0 +1,292 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	overwatch "github.com/SeedJobs/devops-go-overwatch"
+	"github.com/SeedJobs/devops-go-overwatch/providers/default"
+	gogithub "github.com/google/go-github/github"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// TeamRoleMember can use the access granted to the team
+	TeamRoleMember = "member"
+	// TeamRoleMaintainer can also change the members of the team
+	TeamRoleMaintainer = "maintainer"
+)
+
+// team is a team inside the organisation along with everyone in it.
+// Github counts the members of child teams as members of the parent,
+// so adding someone to a child team is reported on every team above it.
+type team struct {
+	Slug    string `json:"Slug" yaml:"Slug"`
+	Name    string `json:"Name" yaml:"Name"`
+	Privacy string `json:"Privacy" yaml:"Privacy"`
+	// Parent is the slug of the team this team is nested under
+	Parent string `json:"Parent" yaml:"Parent"`
+	// Members is nil when the members of the team have not been stored
+	Members []teamMember `json:"Members" yaml:"Members"`
+}
+
+// teamMember is a user inside a team and their role within it
+type teamMember struct {
+	Login string `json:"Login" yaml:"Login"`
+	Role  string `json:"Role" yaml:"Role"`
+}
+
+func (t team) GetName() string {
+	return t.Slug
+}
+
+func (t team) GetType() string {
+	return "Team"
+}
+
+func (t team) AppliedConfig() []overwatch.IamConfig {
+	config := []overwatch.IamConfig{}
+	for _, member := range t.Members {
+		config = append(config, member)
+	}
+	return config
+}
+
+func (m teamMember) GetName() string {
+	return m.Login
+}
+
+func (m teamMember) String() string {
+	return m.Login + ":" + m.Role
+}
+
+func sortTeamMembers(members []teamMember) {
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Login < members[j].Login
+	})
+}
+
+// fillUnset copies what Github reports for the settings that have not been stored
+func (t team) fillUnset(actual team) team {
+	if t.Members == nil {
+		t.Members = actual.Members
+	}
+	return t
+}
+
+func teamTransformer(buff []byte) ([]overwatch.IamResource, error) {
+	teams := []team{}
+	if err := yaml.Unmarshal(buff, &teams); err != nil {
+		return nil, err
+	}
+	collections := []overwatch.IamResource{}
+	for _, t := range teams {
+		for i, member := range t.Members {
+			switch member.Role {
+			case "":
+				t.Members[i].Role = TeamRoleMember
+			case TeamRoleMember, TeamRoleMaintainer:
+			default:
+				return nil, fmt.Errorf("Unknown role %s for %s in team %s", member.Role, member.Login, t.Slug)
+			}
+		}
+		sortTeamMembers(t.Members)
+		collections = append(collections, t)
+	}
+	return collections, nil
+}
+
+// fetchOrgTeams lists every team inside the organisation and then
+// the members of each team concurrently, teams are returned ordered by slug.
+func (m *manager) fetchOrgTeams(ctx context.Context) ([]overwatch.IamResource, error) {
+	found, err := m.listOrgTeams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	teams := make([]team, len(found))
+	err = abstract.ForEach(ctx, len(found), m.concurrency, func(ctx context.Context, i int) error {
+		t, err := m.fetchTeam(ctx, found[i])
+		if err != nil {
+			return err
+		}
+		teams[i] = t
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(teams, func(i, j int) bool {
+		return teams[i].Slug < teams[j].Slug
+	})
+	collection := []overwatch.IamResource{}
+	for _, t := range teams {
+		collection = append(collection, t)
+	}
+	return collection, nil
+}
+
+// fetchTeam returns the team along with its members
+func (m *manager) fetchTeam(ctx context.Context, t *gogithub.Team) (team, error) {
+	members, err := m.fetchTeamMembers(ctx, t.GetID())
+	if err != nil {
+		return team{}, err
+	}
+	return team{
+		Slug:    t.GetSlug(),
+		Name:    t.GetName(),
+		Privacy: t.GetPrivacy(),
+		Parent:  t.GetParent().GetSlug(),
+		Members: members,
+	}, nil
+}
+
+// fetchTeamMembers lists the maintainers and members of the team
+func (m *manager) fetchTeamMembers(ctx context.Context, id int64) ([]teamMember, error) {
+	members := []teamMember{}
+	for _, role := range []string{TeamRoleMaintainer, TeamRoleMember} {
+		opt := &gogithub.OrganizationListTeamMembersOptions{
+			Role:        role,
+			ListOptions: gogithub.ListOptions{PerPage: 100},
+		}
+		for {
+			users, resp, err := m.client.Organizations.ListTeamMembers(ctx, id, opt)
+			if err != nil {
+				return nil, err
+			}
+			for _, user := range users {
+				members = append(members, teamMember{Login: user.GetLogin(), Role: role})
+			}
+			if resp.NextPage == 0 {
+				break
+			}
+			opt.Page = resp.NextPage
+		}
+	}
+	sortTeamMembers(members)
+	return members, nil
+}
+
+// fetchTeamAccess lists the teams that have been granted access to the repo
+func (m *manager) fetchTeamAccess(ctx context.Context, owner, repo string) ([]teamAccess, error) {
+	teams := []teamAccess{}
+	opt := &gogithub.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := m.client.Repositories.ListTeams(ctx, owner, repo, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, team := range page {
+			teams = append(teams, teamAccess{
+				Slug:       team.GetSlug(),
+				Permission: team.GetPermission(),
+			})
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	sortTeams(teams)
+	return teams, nil
+}
+
+// teamIDs returns the ID of every team in the organisation keyed by slug,
+// the v3 API addresses teams by ID when changing their repo access.
+func (m *manager) teamIDs(ctx context.Context) (map[string]int64, error) {
+	teams, err := m.listOrgTeams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := map[string]int64{}
+	for _, team := range teams {
+		ids[team.GetSlug()] = team.GetID()
+	}
+	return ids, nil
+}
+
+// listOrgTeams returns every team inside the organisation
+func (m *manager) listOrgTeams(ctx context.Context) ([]*gogithub.Team, error) {
+	teams := []*gogithub.Team{}
+	opt := &gogithub.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := m.client.Organizations.ListTeams(ctx, m.organisation, opt)
+		if err != nil {
+			return nil, err
+		}
+		teams = append(teams, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return teams, nil
+}
+
+// planTeamAccess adds the changes that bring the team access of the repo from actual to desired.
+// Teams missing from desired have their access removed, once the teams of the repo have been stored.
+func (m *manager) planTeamAccess(plan *abstract.Plan, desired, actual project, ids func(context.Context, string) (int64, error)) {
+	if desired.Teams == nil {
+		return
+	}
+	current := map[string]string{}
+	for _, team := range actual.Teams {
+		current[team.Slug] = team.Permission
+	}
+	wanted := map[string]bool{}
+	for _, team := range desired.Teams {
+		wanted[team.Slug] = true
+		if permission, exist := current[team.Slug]; exist && permission == team.Permission {
+			continue
+		}
+		team := team
+		description := fmt.Sprintf("grant team %s %s access", team.Slug, team.Permission)
+		if team.Permission == "" {
+			// Github grants its default permission when none is given
+			description = fmt.Sprintf("grant team %s access", team.Slug)
+		}
+		plan.Add(desired, description, func(ctx context.Context) error {
+			id, err := ids(ctx, team.Slug)
+			if err != nil {
+				return err
+			}
+			opt := &gogithub.OrganizationAddTeamRepoOptions{Permission: team.Permission}
+			_, err = m.client.Organizations.AddTeamRepo(ctx, id, m.organisation, desired.Name, opt)
+			return err
+		})
+	}
+	for _, team := range actual.Teams {
+		if wanted[team.Slug] {
+			continue
+		}
+		slug := team.Slug
+		plan.Add(desired, fmt.Sprintf("revoke access of team %s", slug), func(ctx context.Context) error {
+			id, err := ids(ctx, slug)
+			if err != nil {
+				return err
+			}
+			_, err = m.client.Organizations.RemoveTeamRepo(ctx, id, m.organisation, desired.Name)
+			return err
+		})
+	}
+}
+
+// teamIDLookup returns a function that finds the ID of a team by its slug,
+// the teams of the organisation are only listed once the first ID is needed.
+func (m *manager) teamIDLookup() func(context.Context, string) (int64, error) {
+	var ids map[string]int64
+	return func(ctx context.Context, slug string) (int64, error) {
+		if ids == nil {
+			found, err := m.teamIDs(ctx)
+			if err != nil {
+				return 0, err
+			}
+			ids = found
+		}
+		id, exist := ids[slug]
+		if !exist {
+			return 0, fmt.Errorf("Unable to find team %s in %s", slug, m.organisation)
+		}
+		return id, nil
+	}
+}
@@ -0,0 +1,127 @@
+package google
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/SeedJobs/devops-go-overwatch/providers/default"
+)
+
+// accountBinding is a role granted on the service account itself, such as
+// roles/iam.serviceAccountUser which allows the members to act as the account.
+type accountBinding struct {
+	Role    string   `json:"Role" yaml:"Role"`
+	Members []string `json:"Members" yaml:"Members"`
+}
+
+func (b accountBinding) GetName() string {
+	return b.Role
+}
+
+func (b accountBinding) String() string {
+	return b.Role + ":" + strings.Join(b.Members, ",")
+}
+
+func sortAccountBindings(bindings []accountBinding) {
+	for _, b := range bindings {
+		sort.Strings(b.Members)
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		return bindings[i].Role < bindings[j].Role
+	})
+}
+
+// accountPolicyPath is the IAM REST path of the service account the policy is attached to
+func accountPolicyPath(email string) string {
+	return "v1/projects/-/serviceAccounts/" + email
+}
+
+// getAccountPolicy reads the IAM policy of the service account. Version 3 is
+// requested as older versions leave out the conditions of conditional bindings.
+func (s *scope) getAccountPolicy(ctx context.Context, client *restClient, email string) (iamPolicy, error) {
+	var policy iamPolicy
+	query := url.Values{"options.requestedPolicyVersion": {strconv.Itoa(policyVersion)}}
+	err := client.do(ctx, http.MethodPost, accountPolicyPath(email)+":getIamPolicy?"+query.Encode(), struct{}{}, &policy)
+	return policy, err
+}
+
+// fetchAccountPolicy returns the roles granted on the service account. Conditional
+// bindings are left out as they are not stored, and are kept as they are on Resync.
+func (s *scope) fetchAccountPolicy(ctx context.Context, client *restClient, email string) ([]accountBinding, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	policy, err := s.getAccountPolicy(ctx, client, email)
+	if err != nil {
+		return nil, err
+	}
+	bindings := []accountBinding{}
+	for _, b := range policy.Bindings {
+		if len(b.Members) == 0 || b.Condition != nil {
+			continue
+		}
+		bindings = append(bindings, accountBinding{Role: b.Role, Members: append([]string{}, b.Members...)})
+	}
+	sortAccountBindings(bindings)
+	return bindings, nil
+}
+
+// planAccountPolicy replaces the policy of the account when the roles granted on it
+// differ from desired, describing each member that is granted or revoked a role.
+// The policy is left alone when no Bindings are stored for the account.
+func (s *scope) planAccountPolicy(plan *abstract.Plan, desired, actual userAccount) {
+	if desired.Bindings == nil || reflect.DeepEqual(desired.Bindings, actual.Bindings) {
+		return
+	}
+	wanted, current := map[string][]string{}, map[string][]string{}
+	roles := []string{}
+	for _, b := range desired.Bindings {
+		wanted[b.Role] = b.Members
+		roles = append(roles, b.Role)
+	}
+	for _, b := range actual.Bindings {
+		if _, exist := wanted[b.Role]; !exist {
+			roles = append(roles, b.Role)
+		}
+		current[b.Role] = b.Members
+	}
+	sort.Strings(roles)
+	steps := []string{}
+	for _, role := range roles {
+		for _, member := range difference(wanted[role], current[role]) {
+			steps = append(steps, "grant "+member+" "+role)
+		}
+		for _, member := range difference(current[role], wanted[role]) {
+			steps = append(steps, "revoke "+member+" "+role)
+		}
+	}
+	plan.Add(desired, strings.Join(steps, ", "), func(ctx context.Context) error {
+		client, err := s.createRESTClient()
+		if err != nil {
+			return err
+		}
+		path := accountPolicyPath(desired.Email)
+		return s.readModifyWrite(ctx, path, func(ctx context.Context) error {
+			policy, err := s.getAccountPolicy(ctx, client, desired.Email)
+			if err != nil {
+				return err
+			}
+			bindings := []policyBinding{}
+			for _, b := range policy.Bindings {
+				if b.Condition != nil {
+					bindings = append(bindings, b)
+				}
+			}
+			for _, b := range desired.Bindings {
+				bindings = append(bindings, policyBinding{Role: b.Role, Members: b.Members})
+			}
+			policy.Bindings, policy.Version = bindings, policyVersion
+			// The etag that was read makes the call fail if the policy has changed since
+			return client.do(ctx, http.MethodPost, path+":setIamPolicy", map[string]interface{}{"policy": policy}, nil)
+		})
+	})
+}
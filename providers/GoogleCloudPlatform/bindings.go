@@ -0,0 +1,245 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	overwatch "github.com/SeedJobs/devops-go-overwatch"
+	"github.com/SeedJobs/devops-go-overwatch/providers/default"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// policyVersion is the version of IAM policies that is able to hold conditions
+const policyVersion = 3
+
+// binding is a role of the IAM policy of a project, folder or organization along
+// with everyone granted it. Resource is taken from where the binding is stored.
+type binding struct {
+	Role      string     `json:"Role" yaml:"Role"`
+	Members   []string   `json:"Members" yaml:"Members"`
+	Condition *condition `json:"Condition,omitempty" yaml:"Condition"`
+	Resource  string     `json:"Resource" yaml:"-"`
+	Type      string     `json:"Type" yaml:"Type"`
+}
+
+// condition limits when a binding applies, such as granting a role until a
+// set time. The same role is able to be granted under several conditions.
+type condition struct {
+	Expression  string `json:"expression" yaml:"Expression"`
+	Title       string `json:"title" yaml:"Title"`
+	Description string `json:"description,omitempty" yaml:"Description"`
+}
+
+// GetName is the role, along with the title and expression of the condition
+// when there is one, as a role may be granted under conditions sharing a title.
+func (b binding) GetName() string {
+	if b.Condition != nil {
+		return b.Role + " (" + b.Condition.Title + ": " + b.Condition.Expression + ")"
+	}
+	return b.Role
+}
+
+func (b binding) GetType() string {
+	return b.Type
+}
+
+func (b binding) AppliedConfig() []overwatch.IamConfig {
+	configs := []overwatch.IamConfig{}
+	for _, member := range b.Members {
+		configs = append(configs, config{Name: member})
+	}
+	return configs
+}
+
+func bindingTransformer(buff []byte) ([]overwatch.IamResource, error) {
+	var items []binding
+	if err := yaml.Unmarshal(buff, &items); err != nil {
+		return nil, err
+	}
+	collection := []overwatch.IamResource{}
+	for _, obj := range items {
+		obj.Type = "Binding"
+		if c := obj.Condition; c != nil && (c.Expression == "" || c.Title == "") {
+			return nil, fmt.Errorf("Condition of binding %s requires an Expression and Title", obj.Role)
+		}
+		if obj.Members == nil {
+			obj.Members = []string{}
+		}
+		sort.Strings(obj.Members)
+		collection = append(collection, obj)
+	}
+	return collection, nil
+}
+
+// iamPolicy is the Cloud Resource Manager REST representation of an IAM policy
+type iamPolicy struct {
+	Version  int             `json:"version,omitempty"`
+	Bindings []policyBinding `json:"bindings,omitempty"`
+	Etag     string          `json:"etag,omitempty"`
+}
+
+type policyBinding struct {
+	Role      string     `json:"role"`
+	Members   []string   `json:"members"`
+	Condition *condition `json:"condition,omitempty"`
+}
+
+// getPolicy reads the IAM policy of the project, folder or organization. Version 3
+// is requested as older versions leave out the conditions of conditional bindings.
+func (s *scope) getPolicy(ctx context.Context) (iamPolicy, error) {
+	var policy iamPolicy
+	client, err := s.createResourceManagerClient()
+	if err != nil {
+		return policy, err
+	}
+	body := map[string]interface{}{
+		"options": map[string]interface{}{"requestedPolicyVersion": policyVersion},
+	}
+	err = client.do(ctx, http.MethodPost, s.policyPath()+":getIamPolicy", body, &policy)
+	return policy, err
+}
+
+// setPolicy replaces the IAM policy of the project, folder or organization, the
+// etag of the policy makes it fail if the policy has changed since it was read.
+func (s *scope) setPolicy(ctx context.Context, policy iamPolicy) error {
+	client, err := s.createResourceManagerClient()
+	if err != nil {
+		return err
+	}
+	policy.Version = policyVersion
+	body := map[string]interface{}{"policy": policy}
+	return client.do(ctx, http.MethodPost, s.policyPath()+":setIamPolicy", body, nil)
+}
+
+// fetchBindings returns each binding of the scope, sorted by name
+func (s *scope) fetchBindings() ([]binding, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	policy, err := s.getPolicy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bindings := []binding{}
+	for _, b := range policy.Bindings {
+		bindings = append(bindings, s.fromPolicy(b))
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		return bindings[i].GetName() < bindings[j].GetName()
+	})
+	return bindings, nil
+}
+
+func (s *scope) fromPolicy(b policyBinding) binding {
+	members := append([]string{}, b.Members...)
+	sort.Strings(members)
+	return binding{Role: b.Role, Members: members, Condition: b.Condition, Resource: s.parent(), Type: "Binding"}
+}
+
+// bindingDrift returns the bindings that differ from the store followed
+// by the stored version of those no longer granted to anyone.
+func (s *scope) bindingDrift(bindings []binding) []overwatch.IamResource {
+	modified := []overwatch.IamResource{}
+	seen := map[string]bool{}
+	for _, b := range bindings {
+		seen[b.GetName()] = true
+		stored, exist := s.resources["Binding"][b.GetName()]
+		if !exist || !reflect.DeepEqual(stored, b) {
+			modified = append(modified, b)
+		}
+	}
+	for _, name := range s.storedNames("Binding") {
+		if !seen[name] {
+			modified = append(modified, s.resources["Binding"][name])
+		}
+	}
+	return modified
+}
+
+// planBindings adds the changes that grant and revoke roles member by member until
+// the policy matches the store, bindings that are not stored are revoked from
+// everyone. Nothing is planned while no bindings are stored, rather than
+// removing everyone from the project, folder or organization.
+func (s *scope) planBindings(plan *abstract.Plan, bindings []binding) {
+	if len(s.resources["Binding"]) == 0 {
+		return
+	}
+	current := map[string]binding{}
+	for _, b := range bindings {
+		current[b.GetName()] = b
+	}
+	for _, name := range s.storedNames("Binding") {
+		desired := s.resources["Binding"][name].(binding)
+		s.planBinding(plan, desired, current[name])
+	}
+	for _, actual := range bindings {
+		if _, stored := s.resources["Binding"][actual.GetName()]; !stored {
+			revoked := actual
+			revoked.Members = []string{}
+			s.planBinding(plan, revoked, actual)
+		}
+	}
+}
+
+// planBinding adds a change when the members or condition of the binding differ
+// from desired, describing each member that is granted or revoked the role.
+func (s *scope) planBinding(plan *abstract.Plan, desired, actual binding) {
+	granted, revoked := difference(desired.Members, actual.Members), difference(actual.Members, desired.Members)
+	steps := []string{}
+	for _, member := range granted {
+		steps = append(steps, "grant "+member)
+	}
+	for _, member := range revoked {
+		steps = append(steps, "revoke "+member)
+	}
+	if len(desired.Members) != 0 && len(actual.Members) != 0 && !reflect.DeepEqual(desired.Condition, actual.Condition) {
+		steps = append(steps, "change condition to "+desired.Condition.Expression)
+	}
+	if len(steps) == 0 {
+		return
+	}
+	plan.Add(desired, strings.Join(steps, ", "), func(ctx context.Context) error {
+		return s.readModifyWrite(ctx, s.parent(), func(ctx context.Context) error {
+			policy, err := s.getPolicy(ctx)
+			if err != nil {
+				return err
+			}
+			policy.Bindings = replaceBinding(policy.Bindings, desired)
+			return s.setPolicy(ctx, policy)
+		})
+	})
+}
+
+// replaceBinding swaps the binding with the same role and condition for
+// desired, leaving every other binding of the role as it is. The binding is
+// removed when desired has no members left.
+func replaceBinding(bindings []policyBinding, desired binding) []policyBinding {
+	replaced := []policyBinding{}
+	for _, b := range bindings {
+		if (binding{Role: b.Role, Condition: b.Condition}).GetName() != desired.GetName() {
+			replaced = append(replaced, b)
+		}
+	}
+	if len(desired.Members) != 0 {
+		replaced = append(replaced, policyBinding{Role: desired.Role, Members: desired.Members, Condition: desired.Condition})
+	}
+	return replaced
+}
+
+// difference returns the items of a that are not in b
+func difference(a, b []string) []string {
+	exist := map[string]bool{}
+	for _, item := range b {
+		exist[item] = true
+	}
+	diff := []string{}
+	for _, item := range a {
+		if !exist[item] {
+			diff = append(diff, item)
+		}
+	}
+	return diff
+}
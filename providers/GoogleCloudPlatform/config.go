@@ -9,5 +9,5 @@ func (c config) GetName() string {
 }
 
 func (c config) String() string {
-	return ""
+	return c.Name
 }
@@ -0,0 +1,74 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	overwatch "github.com/SeedJobs/devops-go-overwatch"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultConflictRetries is how many more times an IAM policy is read and
+// merged again when it changes between being read and written, when
+// ConflictRetries is not defined in the additional map.
+const defaultConflictRetries = 3
+
+// defaultConflictBackoff is roughly how long is waited before the first retry of a
+// conflicting write, doubling for each retry after it, when ConflictBackoff is not
+// defined in the additional map.
+const defaultConflictBackoff = 100 * time.Millisecond
+
+// isConflict reports whether the etag of a policy no longer matched when it was written
+func isConflict(err error) bool {
+	if e, ok := err.(*restError); ok {
+		return e.Code == http.StatusConflict
+	}
+	return status.Code(err) == codes.Aborted
+}
+
+// readModifyWrite calls update, which reads the IAM policy of the resource, merges in
+// the change and writes it back with the etag that was read, until it does not conflict
+// with someone else changing the policy at the same time. Each attempt has its own timeout,
+// and attempts are spread out by a jittered backoff so concurrent writers fall out of step.
+// ErrConflict is returned once the policy has changed during every attempt.
+func (m *cloudIamManager) readModifyWrite(ctx context.Context, resource string, update func(context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		err := func() error {
+			ctx, cancel := context.WithTimeout(ctx, m.timeout)
+			defer cancel()
+			return update(ctx)
+		}()
+		if !isConflict(err) {
+			return err
+		}
+		if attempt == m.conflictRetries {
+			return fmt.Errorf("%w, the IAM policy of %s changed during each of %d attempts", overwatch.ErrConflict, resource, attempt+1)
+		}
+		if err := sleepContext(ctx, jitter(m.conflictBackoff<<uint(attempt))); err != nil {
+			return err
+		}
+	}
+}
+
+// jitter returns a random duration between half and one and a half times d
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
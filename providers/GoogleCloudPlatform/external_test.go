@@ -1,12 +1,26 @@
 package google_test
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	overwatch "github.com/SeedJobs/devops-go-overwatch"
+	"github.com/SeedJobs/devops-go-overwatch/overwatchtest"
 	google "github.com/SeedJobs/devops-go-overwatch/providers/GoogleCloudPlatform"
+	"github.com/SeedJobs/devops-go-overwatch/providers/GoogleCloudPlatform/fake"
+	"google.golang.org/api/option"
+	adminpb "google.golang.org/genproto/googleapis/iam/admin/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 )
 
 var (
@@ -151,3 +165,1237 @@ func TestListingModifiedResources(t *testing.T) {
 		t.Log("Modified resource is:", mod)
 	}
 }
+
+// newOfflineManager creates a manager that talks to the fake server and
+// reads its stored resources from a temporary directory.
+// The returned function cleans up everything that was created.
+func newOfflineManager(t *testing.T, project string, stored map[string]string) (overwatch.IamPolicyManager, *fake.Server, func()) {
+	return newOfflineManagerWith(t, project, stored, nil)
+}
+
+// newOfflineManagerWith is newOfflineManager with extra configuration added to Additional
+func newOfflineManagerWith(t *testing.T, project string, stored map[string]string, additional map[string]interface{}) (overwatch.IamPolicyManager, *fake.Server, func()) {
+	server, err := fake.NewServer()
+	if err != nil {
+		t.Fatal("Unable to start fake server:", err)
+	}
+	conn, err := server.Dial()
+	if err != nil {
+		t.Fatal("Unable to dial fake server:", err)
+	}
+	dir, err := ioutil.TempDir("", "overwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		conn.Close()
+		server.Close()
+		os.RemoveAll(dir)
+	}
+	for file, content := range stored {
+		filepath := path.Join(dir, file)
+		if err := os.MkdirAll(path.Dir(filepath), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	man, err := google.NewManager()
+	if err != nil {
+		t.Fatal("Unable to create manager")
+	}
+	conf := offlineConfig(server, conn, dir, project)
+	for key, value := range additional {
+		conf.Additional[key] = value
+	}
+	if err = man.LoadConfiguration(conf); err != nil {
+		cleanup()
+		t.Fatal("Unable to LoadConfigurations due to", err)
+	}
+	return man, server, cleanup
+}
+
+// offlineConfig points a manager at the fake server and the store in dir,
+// Project is left out when project is empty.
+func offlineConfig(server *fake.Server, conn *grpc.ClientConn, dir, project string) overwatch.IamManagerConfig {
+	conf := overwatch.IamManagerConfig{
+		Additional: map[string]interface{}{
+			"Location":      dir,
+			"Synchro":       "local",
+			"ClientOptions": []option.ClientOption{option.WithGRPCConn(conn)},
+			"RESTClientOptions": []option.ClientOption{
+				option.WithEndpoint(server.ResourceManagerURL),
+				option.WithHTTPClient(http.DefaultClient),
+			},
+			"RequestTimeout":  2 * time.Second,
+			"ConflictBackoff": time.Millisecond,
+			"Enforce":         true,
+		},
+	}
+	if project != "" {
+		conf.Additional["Project"] = project
+	}
+	return conf
+}
+
+func TestListingModifiedResourcesOffline(t *testing.T) {
+	project := "offline-project"
+	man, server, cleanup := newOfflineManager(t, project, map[string]string{
+		"GoogleCloudPlatform/Project/offline-project/ServiceAccounts/accounts.yml": `---
+- Name: Unchanged
+  Email: unchanged@offline-project.iam.gserviceaccount.com
+- Name: Before
+  Email: renamed@offline-project.iam.gserviceaccount.com
+`,
+	})
+	defer cleanup()
+	if len(man.Resources()) != 2 {
+		t.Fatal("Expected two resources to be loaded from disk, got", man.Resources())
+	}
+	server.AddServiceAccount(project, "unchanged", "Unchanged")
+	server.AddServiceAccount(project, "renamed", "After")
+	server.AddServiceAccount(project, "added", "Added")
+	modified, err := man.ListModifiedResources()
+	if err != nil {
+		t.Fatal("Manager has returned an error:", err)
+	}
+	names := map[string]bool{}
+	for _, mod := range modified {
+		names[mod.GetName()] = true
+	}
+	if len(modified) != 2 ||
+		!names["renamed@offline-project.iam.gserviceaccount.com"] ||
+		!names["added@offline-project.iam.gserviceaccount.com"] {
+		t.Fatal("Expected the renamed and added accounts to be modified, got", modified)
+	}
+}
+
+func TestListingModifiedResourcesUnavailable(t *testing.T) {
+	man, server, cleanup := newOfflineManager(t, "offline-project", nil)
+	defer cleanup()
+	server.Close()
+	if _, err := man.ListModifiedResources(); err == nil {
+		t.Fatal("Expected an error when the provider can not be contacted")
+	}
+}
+
+func TestResyncReconcilesServiceAccounts(t *testing.T) {
+	project := "offline-project"
+	man, server, cleanup := newOfflineManager(t, project, map[string]string{
+		"GoogleCloudPlatform/Project/offline-project/ServiceAccounts/accounts.yml": `---
+- Name: Builder
+  Email: builder@offline-project.iam.gserviceaccount.com
+  Description: Runs the builds
+- Name: Before
+  Email: renamed@offline-project.iam.gserviceaccount.com
+- Name: Missing
+  Email: missing@offline-project.iam.gserviceaccount.com
+  Description: Removed by hand
+`,
+	})
+	defer cleanup()
+	server.AddServiceAccount(project, "builder", "Builder")
+	server.UpdateServiceAccount("builder@offline-project.iam.gserviceaccount.com", func(a *fake.Account) {
+		a.Description = "Runs the builds"
+	})
+	server.AddServiceAccount(project, "renamed", "After")
+	server.AddServiceAccount(project, "unknown", "Unknown")
+	changed, err := man.Resync()
+	if err != nil {
+		t.Fatal("Unable to resync:", err)
+	}
+	names := map[string]bool{}
+	for _, resource := range changed {
+		names[resource.GetName()] = true
+	}
+	for _, account := range []string{"renamed", "missing", "unknown"} {
+		if !names[account+"@offline-project.iam.gserviceaccount.com"] {
+			t.Error("Expected the changes to include", account)
+		}
+	}
+	if len(changed) != 3 {
+		t.Fatal("Expected three accounts to be changed, got", changed)
+	}
+	expected := map[string]fake.Account{
+		"builder": {DisplayName: "Builder", Description: "Runs the builds"},
+		"renamed": {DisplayName: "Before"},
+		"missing": {DisplayName: "Missing", Description: "Removed by hand"},
+		"unknown": {DisplayName: "Unknown", Disabled: true},
+	}
+	for id, want := range expected {
+		acc, exist := server.ServiceAccount(id + "@offline-project.iam.gserviceaccount.com")
+		if !exist {
+			t.Error("Expected the account to exist:", id)
+			continue
+		}
+		if acc.DisplayName != want.DisplayName || acc.Description != want.Description || acc.Disabled != want.Disabled {
+			t.Errorf("Account %s is %+v, expected %+v", id, acc, want)
+		}
+	}
+	if changed, err := man.Resync(); err != nil || len(changed) != 0 {
+		t.Fatal("Expected a second resync to change nothing, got", changed, err)
+	}
+	if modified, err := man.ListModifiedResources(); err != nil || len(modified) != 0 {
+		t.Fatal("Expected no drift after resyncing, got", modified, err)
+	}
+}
+
+func TestResyncDeletesUnmanagedServiceAccounts(t *testing.T) {
+	project := "offline-project"
+	man, server, cleanup := newOfflineManagerWith(t, project, map[string]string{
+		"GoogleCloudPlatform/Project/offline-project/ServiceAccounts/accounts.yml": `---
+- Name: Builder
+  Email: builder@offline-project.iam.gserviceaccount.com
+`,
+	}, map[string]interface{}{
+		"Unmanaged": google.UnmanagedDelete,
+	})
+	defer cleanup()
+	server.AddServiceAccount(project, "builder", "Builder")
+	server.AddServiceAccount(project, "unknown", "Unknown")
+	if _, err := man.Resync(); err != nil {
+		t.Fatal("Unable to resync:", err)
+	}
+	if accounts := server.ServiceAccounts(project); len(accounts) != 1 {
+		t.Fatal("Expected the unmanaged account to be deleted, got", accounts)
+	}
+}
+
+func TestResyncAlertOnlyServiceAccounts(t *testing.T) {
+	project := "offline-project"
+	man, server, cleanup := newOfflineManagerWith(t, project, map[string]string{
+		"GoogleCloudPlatform/Project/offline-project/ServiceAccounts/accounts.yml": `---
+- Name: Before
+  Email: renamed@offline-project.iam.gserviceaccount.com
+`,
+	}, map[string]interface{}{
+		"Enforcement": map[string]string{
+			"Default": "enforce",
+			"ServiceAccount/renamed@offline-project.iam.gserviceaccount.com": "alert-only",
+		},
+	})
+	defer cleanup()
+	server.AddServiceAccount(project, "renamed", "After")
+	server.AddServiceAccount(project, "unknown", "Unknown")
+	changed, err := man.Resync()
+	if err != nil {
+		t.Fatal("Unable to resync:", err)
+	}
+	if len(changed) != 1 || changed[0].GetName() != "unknown@offline-project.iam.gserviceaccount.com" {
+		t.Fatal("Expected only the unknown account to be changed, got", changed)
+	}
+	if acc, _ := server.ServiceAccount("renamed@offline-project.iam.gserviceaccount.com"); acc.DisplayName != "After" {
+		t.Fatal("Expected the alert-only account to be left alone, got", acc)
+	}
+}
+
+func TestResyncAlertOnlyByDefault(t *testing.T) {
+	project := "offline-project"
+	man, server, cleanup := newOfflineManagerWith(t, project, map[string]string{
+		"GoogleCloudPlatform/Project/offline-project/ServiceAccounts/accounts.yml": `---
+- Name: Before
+  Email: renamed@offline-project.iam.gserviceaccount.com
+`,
+	}, map[string]interface{}{
+		"Enforce": false,
+	})
+	defer cleanup()
+	server.AddServiceAccount(project, "renamed", "After")
+	server.AddServiceAccount(project, "unknown", "Unknown")
+	changed, err := man.Resync()
+	if err != nil {
+		t.Fatal("Unable to resync:", err)
+	}
+	if len(changed) != 0 {
+		t.Fatal("Expected nothing to be changed without Enforce, got", changed)
+	}
+	if acc, _ := server.ServiceAccount("unknown@offline-project.iam.gserviceaccount.com"); acc.Disabled {
+		t.Fatal("Expected the unmanaged account to be left enabled")
+	}
+}
+
+func TestResyncWithoutStoredAccounts(t *testing.T) {
+	project := "offline-project"
+	man, server, cleanup := newOfflineManager(t, project, nil)
+	defer cleanup()
+	server.AddServiceAccount(project, "unknown", "Unknown")
+	if _, err := man.Resync(); err != nil {
+		t.Fatal("Unable to resync:", err)
+	}
+	if acc, _ := server.ServiceAccount("unknown@offline-project.iam.gserviceaccount.com"); acc.Disabled {
+		t.Fatal("Expected the account to be left enabled while none are stored")
+	}
+}
+
+func TestUnknownUnmanagedAction(t *testing.T) {
+	man, err := google.NewManager()
+	if err != nil {
+		t.Fatal("Unable to create manager")
+	}
+	err = man.LoadConfiguration(overwatch.IamManagerConfig{
+		Additional: map[string]interface{}{
+			"Project":   "offline-project",
+			"Unmanaged": "ignore",
+		},
+	})
+	if err == nil {
+		t.Fatal("Expected an error for an unknown Unmanaged action")
+	}
+}
+
+// backend drives the fake server for the conformance suite
+type backend struct {
+	server  *fake.Server
+	project string
+	dir     string
+	next    int
+}
+
+func (b *backend) email(id string) string {
+	return fmt.Sprintf("%s@%s.iam.gserviceaccount.com", id, b.project)
+}
+
+func (b *backend) Seed(t *testing.T, count int) []string {
+	names := []string{}
+	content := "---\n"
+	for i := 0; i < count; i++ {
+		id := fmt.Sprintf("seeded-%d", i)
+		b.server.AddServiceAccount(b.project, id, id)
+		content += fmt.Sprintf("- Name: %s\n  Email: %s\n", id, b.email(id))
+		names = append(names, b.email(id))
+	}
+	dir := path.Join(b.dir, "GoogleCloudPlatform/Project", b.project, "ServiceAccounts")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "accounts.yml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func (b *backend) Add(t *testing.T) string {
+	b.next++
+	id := fmt.Sprintf("added-%d", b.next)
+	return b.server.AddServiceAccount(b.project, id, id).Email
+}
+
+func (b *backend) Modify(t *testing.T, name string) {
+	if err := b.server.UpdateServiceAccount(name, func(a *fake.Account) { a.DisplayName += " modified" }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (b *backend) Remove(t *testing.T, name string) {
+	b.server.RemoveServiceAccount(name)
+}
+
+func (b *backend) Stop() {
+	b.server.Close()
+}
+
+func TestConformance(t *testing.T) {
+	overwatchtest.Run(t, func(t *testing.T) overwatchtest.Fixture {
+		server, err := fake.NewServer()
+		if err != nil {
+			t.Fatal("Unable to start fake server:", err)
+		}
+		conn, err := server.Dial()
+		if err != nil {
+			t.Fatal("Unable to dial fake server:", err)
+		}
+		dir, err := ioutil.TempDir("", "overwatch")
+		if err != nil {
+			t.Fatal(err)
+		}
+		man, err := google.NewManager()
+		if err != nil {
+			t.Fatal("Unable to create manager")
+		}
+		return overwatchtest.Fixture{
+			Manager: man,
+			Config:  offlineConfig(server, conn, dir, "conformance"),
+			Backend: &backend{server: server, project: "conformance", dir: dir},
+			Cleanup: func() {
+				conn.Close()
+				server.Close()
+				os.RemoveAll(dir)
+			},
+		}
+	})
+}
+
+func TestProjectBindings(t *testing.T) {
+	project := "offline-project"
+	man, server, cleanup := newOfflineManager(t, project, map[string]string{
+		"GoogleCloudPlatform/Project/offline-project/Bindings/bindings.yml": `---
+- Role: roles/viewer
+  Members:
+  - user:alice@example.com
+  - serviceAccount:builder@offline-project.iam.gserviceaccount.com
+- Role: roles/editor
+  Members:
+  - user:bob@example.com
+- Role: roles/viewer
+  Members:
+  - user:temp@example.com
+  Condition:
+    Expression: request.time < timestamp('2030-01-01T00:00:00Z')
+    Title: expires
+`,
+	})
+	defer cleanup()
+	conditional := fake.Binding{
+		Role:      "roles/viewer",
+		Members:   []string{"user:temp@example.com"},
+		Condition: &fake.Expr{Expression: "request.time < timestamp('2030-01-01T00:00:00Z')", Title: "expires"},
+	}
+	server.SetProjectPolicy(project, fake.Policy{Bindings: []fake.Binding{
+		{Role: "roles/viewer", Members: []string{"user:alice@example.com", "user:carol@example.com"}},
+		{Role: "roles/editor", Members: []string{"user:bob@example.com"}},
+		{Role: "roles/owner", Members: []string{"user:owner@example.com"}},
+		conditional,
+	}})
+	modified, err := man.ListModifiedResources()
+	if err != nil {
+		t.Fatal("Manager has returned an error:", err)
+	}
+	names := map[string]bool{}
+	for _, mod := range modified {
+		names[mod.GetName()] = true
+	}
+	if len(modified) != 2 || !names["roles/viewer"] || !names["roles/owner"] {
+		t.Fatal("Expected the viewer and owner bindings to be modified, got", modified)
+	}
+	changed, err := man.Resync()
+	if err != nil {
+		t.Fatal("Unable to resync:", err)
+	}
+	if len(changed) != 2 {
+		t.Fatal("Expected the viewer and owner bindings to be changed, got", changed)
+	}
+	policy := server.ProjectPolicy(project)
+	roles := map[string][]string{}
+	for _, b := range policy.Bindings {
+		if b.Condition != nil {
+			if !reflect.DeepEqual(b, conditional) {
+				t.Error("Expected the conditional binding to be left alone, got", b)
+			}
+			continue
+		}
+		members := append([]string{}, b.Members...)
+		sort.Strings(members)
+		roles[b.Role] = members
+	}
+	expected := map[string][]string{
+		"roles/viewer": {"serviceAccount:builder@offline-project.iam.gserviceaccount.com", "user:alice@example.com"},
+		"roles/editor": {"user:bob@example.com"},
+	}
+	if !reflect.DeepEqual(roles, expected) {
+		t.Fatal("Expected the project policy to match the store, got", roles)
+	}
+	if changed, err := man.Resync(); err != nil || len(changed) != 0 {
+		t.Fatal("Expected a second resync to change nothing, got", changed, err)
+	}
+}
+
+func TestConditionalBindings(t *testing.T) {
+	project := "offline-project"
+	man, server, cleanup := newOfflineManager(t, project, map[string]string{
+		"GoogleCloudPlatform/Project/offline-project/Bindings/bindings.yml": `---
+- Role: roles/viewer
+  Members:
+  - user:alice@example.com
+- Role: roles/editor
+  Members:
+  - user:oncall@example.com
+  Condition:
+    Expression: request.time < timestamp('2031-01-01T00:00:00Z')
+    Title: on call
+    Description: Extended for another year
+- Role: roles/owner
+  Members:
+  - user:breakglass@example.com
+  Condition:
+    Expression: request.time < timestamp('2030-06-01T00:00:00Z')
+    Title: break glass
+`,
+	})
+	defer cleanup()
+	server.SetProjectPolicy(project, fake.Policy{Version: 3, Bindings: []fake.Binding{
+		{Role: "roles/viewer", Members: []string{"user:alice@example.com"}},
+		{
+			Role:      "roles/editor",
+			Members:   []string{"user:oncall@example.com"},
+			Condition: &fake.Expr{Expression: "request.time < timestamp('2030-01-01T00:00:00Z')", Title: "on call"},
+		},
+		{
+			Role:      "roles/viewer",
+			Members:   []string{"user:contractor@example.com"},
+			Condition: &fake.Expr{Expression: "request.time < timestamp('2029-01-01T00:00:00Z')", Title: "contract"},
+		},
+	}})
+	modified, err := man.ListModifiedResources()
+	if err != nil {
+		t.Fatal("Manager has returned an error:", err)
+	}
+	names := []string{}
+	for _, mod := range modified {
+		names = append(names, mod.GetName())
+	}
+	sort.Strings(names)
+	// Changing the expression replaces the binding, so both versions are reported
+	expected := []string{
+		"roles/editor (on call: request.time < timestamp('2030-01-01T00:00:00Z'))",
+		"roles/editor (on call: request.time < timestamp('2031-01-01T00:00:00Z'))",
+		"roles/owner (break glass: request.time < timestamp('2030-06-01T00:00:00Z'))",
+		"roles/viewer (contract: request.time < timestamp('2029-01-01T00:00:00Z'))",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatal("Expected", expected, "got", names)
+	}
+	if _, err := man.Resync(); err != nil {
+		t.Fatal("Unable to resync:", err)
+	}
+	policy := server.ProjectPolicy(project)
+	if policy.Version != 3 {
+		t.Error("Expected the policy to be written as version 3, got", policy.Version)
+	}
+	bindings := map[string]fake.Binding{}
+	for _, b := range policy.Bindings {
+		name := b.Role
+		if b.Condition != nil {
+			name += " (" + b.Condition.Title + ")"
+		}
+		bindings[name] = b
+	}
+	if len(policy.Bindings) != 3 || len(bindings) != 3 {
+		t.Fatal("Expected the viewer, on call and break glass bindings, got", policy.Bindings)
+	}
+	if c := bindings["roles/editor (on call)"].Condition; c == nil ||
+		c.Expression != "request.time < timestamp('2031-01-01T00:00:00Z')" ||
+		c.Description != "Extended for another year" {
+		t.Error("Expected the on call condition to be updated, got", c)
+	}
+	if _, exist := bindings["roles/owner (break glass)"]; !exist {
+		t.Error("Expected the break glass binding to be created")
+	}
+	if modified, err := man.ListModifiedResources(); err != nil || len(modified) != 0 {
+		t.Fatal("Expected no drift after resyncing, got", modified, err)
+	}
+}
+
+func TestConditionsSharingTitle(t *testing.T) {
+	project := "offline-project"
+	man, server, cleanup := newOfflineManager(t, project, map[string]string{
+		"GoogleCloudPlatform/Project/offline-project/Bindings/bindings.yml": `---
+- Role: roles/viewer
+  Members:
+  - user:alice@example.com
+  Condition:
+    Expression: request.time < timestamp('2030-01-01T00:00:00Z')
+    Title: temporary
+- Role: roles/viewer
+  Members:
+  - user:bob@example.com
+  Condition:
+    Expression: resource.name.startsWith('projects/offline-project/buckets/logs')
+    Title: temporary
+`,
+	})
+	defer cleanup()
+	live := []fake.Binding{
+		{
+			Role:      "roles/viewer",
+			Members:   []string{"user:alice@example.com"},
+			Condition: &fake.Expr{Expression: "request.time < timestamp('2030-01-01T00:00:00Z')", Title: "temporary"},
+		},
+		{
+			Role:      "roles/viewer",
+			Members:   []string{"user:bob@example.com"},
+			Condition: &fake.Expr{Expression: "resource.name.startsWith('projects/offline-project/buckets/logs')", Title: "temporary"},
+		},
+	}
+	server.SetProjectPolicy(project, fake.Policy{Version: 3, Bindings: live})
+	if modified, err := man.ListModifiedResources(); err != nil || len(modified) != 0 {
+		t.Fatal("Expected conditions sharing a title to be told apart, got", modified, err)
+	}
+	if changed, err := man.Resync(); err != nil || len(changed) != 0 {
+		t.Fatal("Expected nothing to change, got", changed, err)
+	}
+	if policy := server.ProjectPolicy(project); !reflect.DeepEqual(policy.Bindings, live) {
+		t.Fatal("Expected both bindings to be kept, got", policy.Bindings)
+	}
+}
+
+func TestConditionRequiresTitle(t *testing.T) {
+	server, err := fake.NewServer()
+	if err != nil {
+		t.Fatal("Unable to start fake server:", err)
+	}
+	defer server.Close()
+	conn, err := server.Dial()
+	if err != nil {
+		t.Fatal("Unable to dial fake server:", err)
+	}
+	defer conn.Close()
+	dir, err := ioutil.TempDir("", "overwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bindings := path.Join(dir, "GoogleCloudPlatform/Project/offline-project/Bindings")
+	if err := os.MkdirAll(bindings, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	stored := "- Role: roles/viewer\n  Members: [user:alice@example.com]\n  Condition:\n    Expression: 'true'\n"
+	if err := ioutil.WriteFile(path.Join(bindings, "bindings.yml"), []byte(stored), 0644); err != nil {
+		t.Fatal(err)
+	}
+	man, _ := google.NewManager()
+	if err := man.LoadConfiguration(offlineConfig(server, conn, dir, "offline-project")); err == nil {
+		t.Fatal("Expected an error loading a condition without a title")
+	}
+}
+
+func TestResyncWithoutStoredBindings(t *testing.T) {
+	project := "offline-project"
+	man, server, cleanup := newOfflineManager(t, project, nil)
+	defer cleanup()
+	server.SetProjectPolicy(project, fake.Policy{Bindings: []fake.Binding{
+		{Role: "roles/owner", Members: []string{"user:owner@example.com"}},
+	}})
+	if _, err := man.Resync(); err != nil {
+		t.Fatal("Unable to resync:", err)
+	}
+	if policy := server.ProjectPolicy(project); len(policy.Bindings) != 1 {
+		t.Fatal("Expected the project policy to be left alone, got", policy)
+	}
+}
+
+func TestMultipleProjects(t *testing.T) {
+	man, server, cleanup := newOfflineManagerWith(t, "", map[string]string{
+		"GoogleCloudPlatform/Project/alpha/ServiceAccounts/accounts.yml": `---
+- Name: Builder
+  Email: builder@alpha.iam.gserviceaccount.com
+`,
+		"GoogleCloudPlatform/Project/beta/ServiceAccounts/accounts.yml": `---
+- Name: Builder
+  Email: builder@beta.iam.gserviceaccount.com
+`,
+		"GoogleCloudPlatform/Project/alpha/Bindings/bindings.yml": `---
+- Role: roles/viewer
+  Members:
+  - user:alice@example.com
+`,
+		"GoogleCloudPlatform/Project/beta/Bindings/bindings.yml": `---
+- Role: roles/viewer
+  Members:
+  - user:bob@example.com
+`,
+	}, map[string]interface{}{
+		"Projects":    []string{"alpha", "beta"},
+		"Concurrency": 2,
+	})
+	defer cleanup()
+	if len(man.Resources()) != 4 {
+		t.Fatal("Expected the resources of both projects to be loaded, got", man.Resources())
+	}
+	modified, err := man.ListModifiedResources()
+	if err != nil {
+		t.Fatal("Manager has returned an error:", err)
+	}
+	if len(modified) != 4 {
+		t.Fatal("Expected the missing accounts and bindings of both projects, got", modified)
+	}
+	changed, err := man.Resync()
+	if err != nil {
+		t.Fatal("Unable to resync:", err)
+	}
+	if len(changed) != 4 {
+		t.Fatal("Expected the bindings of each project to be changed separately, got", changed)
+	}
+	for project, member := range map[string]string{"alpha": "user:alice@example.com", "beta": "user:bob@example.com"} {
+		if accounts := server.ServiceAccounts(project); len(accounts) != 1 {
+			t.Error("Expected the account of", project, "to be created, got", accounts)
+		}
+		policy := server.ProjectPolicy(project)
+		if len(policy.Bindings) != 1 || !reflect.DeepEqual(policy.Bindings[0].Members, []string{member}) {
+			t.Error("Expected the binding of", project, "to be applied, got", policy)
+		}
+	}
+	if modified, err := man.ListModifiedResources(); err != nil || len(modified) != 0 {
+		t.Fatal("Expected no drift after resyncing, got", modified, err)
+	}
+}
+
+func TestDiscoverProjects(t *testing.T) {
+	man, server, cleanup := newOfflineManagerWith(t, "", map[string]string{
+		"GoogleCloudPlatform/Organization/42/Bindings/bindings.yml": `---
+- Role: roles/resourcemanager.organizationViewer
+  Members:
+  - group:everyone@example.com
+`,
+		"GoogleCloudPlatform/Folder/7/Bindings/bindings.yml": `---
+- Role: roles/viewer
+  Members:
+  - group:team@example.com
+`,
+		"GoogleCloudPlatform/Project/nested/ServiceAccounts/accounts.yml": `---
+- Name: Builder
+  Email: builder@nested.iam.gserviceaccount.com
+`,
+	}, map[string]interface{}{
+		"Organization":     "42",
+		"Folders":          []string{"7"},
+		"DiscoverProjects": true,
+	})
+	defer cleanup()
+	server.AddFolder("7", "organizations/42")
+	server.AddProject("direct", "organizations/42")
+	server.AddProject("nested", "folders/7")
+	server.AddProject("gone", "folders/7")
+	server.DeleteProject("gone")
+	server.AddServiceAccount("direct", "unknown", "Unknown")
+	server.AddServiceAccount("gone", "left", "Left")
+	server.SetPolicy("folders/7", fake.Policy{Bindings: []fake.Binding{
+		{Role: "roles/viewer", Members: []string{"group:team@example.com", "user:mallory@example.com"}},
+	}})
+	modified, err := man.ListModifiedResources()
+	if err != nil {
+		t.Fatal("Manager has returned an error:", err)
+	}
+	names := []string{}
+	for _, mod := range modified {
+		names = append(names, mod.GetType()+"/"+mod.GetName())
+	}
+	sort.Strings(names)
+	expected := []string{
+		"Binding/roles/resourcemanager.organizationViewer",
+		"Binding/roles/viewer",
+		"ServiceAccount/builder@nested.iam.gserviceaccount.com",
+		"ServiceAccount/unknown@direct.iam.gserviceaccount.com",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatal("Expected", expected, "got", names)
+	}
+	changed, err := man.Resync()
+	if err != nil {
+		t.Fatal("Unable to resync:", err)
+	}
+	for _, res := range changed {
+		if strings.HasSuffix(res.GetName(), "@direct.iam.gserviceaccount.com") {
+			t.Error("Expected the discovered project without a store to be alert-only, changed", res)
+		}
+	}
+	if policy := server.Policy("folders/7"); len(policy.Bindings) != 1 || len(policy.Bindings[0].Members) != 1 {
+		t.Error("Expected the folder policy to match the store, got", policy)
+	}
+	if policy := server.Policy("organizations/42"); len(policy.Bindings) != 1 {
+		t.Error("Expected the organization policy to match the store, got", policy)
+	}
+	if acc, _ := server.ServiceAccount("unknown@direct.iam.gserviceaccount.com"); acc.Disabled {
+		t.Error("Expected the account of the discovered project without a store to be left enabled")
+	}
+	if acc, _ := server.ServiceAccount("left@gone.iam.gserviceaccount.com"); acc.Disabled {
+		t.Error("Expected projects pending deletion to be left alone")
+	}
+	if len(server.ServiceAccounts("nested")) != 1 {
+		t.Error("Expected the account of the nested project to be created")
+	}
+}
+
+func TestDiscoverProjectsRequiresParent(t *testing.T) {
+	server, err := fake.NewServer()
+	if err != nil {
+		t.Fatal("Unable to start fake server:", err)
+	}
+	defer server.Close()
+	conn, err := server.Dial()
+	if err != nil {
+		t.Fatal("Unable to dial fake server:", err)
+	}
+	defer conn.Close()
+	man, _ := google.NewManager()
+	conf := offlineConfig(server, conn, os.TempDir(), "")
+	conf.Additional["DiscoverProjects"] = true
+	if err := man.LoadConfiguration(conf); err == nil {
+		t.Fatal("Expected an error discovering projects without a folder or organization")
+	}
+}
+
+func TestCustomRoles(t *testing.T) {
+	project := "offline-project"
+	man, server, cleanup := newOfflineManagerWith(t, project, map[string]string{
+		"GoogleCloudPlatform/Project/offline-project/Roles/roles.yml": `---
+- Name: projects/offline-project/roles/deployer
+  Title: Deployer
+  Stage: GA
+  Permissions:
+  - run.services.update
+  - run.services.get
+- Name: projects/offline-project/roles/restored
+  Title: Restored
+  Permissions:
+  - storage.objects.get
+`,
+		"GoogleCloudPlatform/Organization/1234/Roles/roles.yml": `---
+- Name: organizations/1234/roles/auditor
+  Title: Auditor
+  Description: Reads the audit logs
+  Stage: BETA
+  Permissions:
+  - logging.logEntries.list
+`,
+	}, map[string]interface{}{
+		"Organization":   "1234",
+		"UnmanagedRoles": google.UnmanagedDisable,
+	})
+	defer cleanup()
+	server.AddRole("projects/offline-project", "deployer", &adminpb.Role{
+		Title:               "Deployer",
+		Stage:               adminpb.Role_GA,
+		IncludedPermissions: []string{"run.services.get", "run.services.update", "iam.serviceAccounts.actAs"},
+	})
+	server.AddRole("projects/offline-project", "restored", &adminpb.Role{
+		Title:               "Restored",
+		IncludedPermissions: []string{"storage.objects.get"},
+		Deleted:             true,
+	})
+	server.AddRole("projects/offline-project", "legacy", &adminpb.Role{Title: "Legacy"})
+	modified, err := man.ListModifiedResources()
+	if err != nil {
+		t.Fatal("Manager has returned an error:", err)
+	}
+	names := map[string]bool{}
+	for _, mod := range modified {
+		names[mod.GetName()] = true
+	}
+	for _, role := range []string{
+		"projects/offline-project/roles/deployer",
+		"projects/offline-project/roles/restored",
+		"projects/offline-project/roles/legacy",
+		"organizations/1234/roles/auditor",
+	} {
+		if !names[role] {
+			t.Error("Expected the role to be modified:", role)
+		}
+	}
+	if len(modified) != 4 {
+		t.Fatal("Expected four roles to be modified, got", modified)
+	}
+	if _, err := man.Resync(); err != nil {
+		t.Fatal("Unable to resync:", err)
+	}
+	deployer := server.Role("projects/offline-project/roles/deployer")
+	if len(deployer.GetIncludedPermissions()) != 2 {
+		t.Error("Expected the added permission to be removed, got", deployer.GetIncludedPermissions())
+	}
+	if restored := server.Role("projects/offline-project/roles/restored"); restored.GetDeleted() {
+		t.Error("Expected the stored role to be undeleted")
+	}
+	if legacy := server.Role("projects/offline-project/roles/legacy"); legacy.GetStage() != adminpb.Role_DISABLED {
+		t.Error("Expected the unmanaged role to be disabled, got", legacy.GetStage())
+	}
+	auditor := server.Role("organizations/1234/roles/auditor")
+	if auditor == nil || auditor.GetStage() != adminpb.Role_BETA || auditor.GetDescription() != "Reads the audit logs" {
+		t.Error("Expected the organization role to be created, got", auditor)
+	}
+	if modified, err := man.ListModifiedResources(); err != nil || len(modified) != 0 {
+		t.Fatal("Expected no drift after resyncing, got", modified, err)
+	}
+}
+
+func TestUnmanagedRolesAreLeftAlone(t *testing.T) {
+	stored := map[string]string{
+		"GoogleCloudPlatform/Project/offline-project/Roles/roles.yml": `---
+- Name: projects/offline-project/roles/deployer
+  Title: Deployer
+  Permissions:
+  - run.services.update
+`,
+	}
+	for name, tc := range map[string]struct {
+		stored     map[string]string
+		additional map[string]interface{}
+	}{
+		"default":     {stored: stored},
+		"empty store": {additional: map[string]interface{}{"UnmanagedRoles": google.UnmanagedDelete}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			man, server, cleanup := newOfflineManagerWith(t, "offline-project", tc.stored, tc.additional)
+			defer cleanup()
+			server.AddRole("projects/offline-project", "legacy", &adminpb.Role{Title: "Legacy"})
+			if _, err := man.Resync(); err != nil {
+				t.Fatal("Unable to resync:", err)
+			}
+			legacy := server.Role("projects/offline-project/roles/legacy")
+			if legacy == nil || legacy.GetDeleted() || legacy.GetStage() == adminpb.Role_DISABLED {
+				t.Fatal("Expected the unmanaged role to be left alone, got", legacy)
+			}
+		})
+	}
+}
+
+func TestUnknownUnmanagedRolesAction(t *testing.T) {
+	man, _ := google.NewManager()
+	err := man.LoadConfiguration(overwatch.IamManagerConfig{
+		Additional: map[string]interface{}{
+			"Project":        "offline-project",
+			"UnmanagedRoles": "ignore",
+		},
+	})
+	if err == nil {
+		t.Fatal("Expected an error for an unknown UnmanagedRoles action")
+	}
+}
+
+func TestServiceAccountKeys(t *testing.T) {
+	project := "offline-project"
+	email := "builder@offline-project.iam.gserviceaccount.com"
+	stored := map[string]string{
+		"GoogleCloudPlatform/Project/offline-project/ServiceAccounts/accounts.yml": `---
+- Name: Builder
+  Email: builder@offline-project.iam.gserviceaccount.com
+`,
+	}
+	for _, action := range []string{google.KeyActionAlert, google.KeyActionDisable, google.KeyActionDelete} {
+		t.Run(action, func(t *testing.T) {
+			man, server, cleanup := newOfflineManagerWith(t, project, stored, map[string]interface{}{
+				"KeyMaxAge": 90 * 24 * time.Hour,
+				"KeyAction": action,
+			})
+			defer cleanup()
+			server.AddServiceAccount(project, "builder", "Builder")
+			server.AddServiceAccountKey(email, time.Now().AddDate(-2, 0, 0), true)
+			server.AddServiceAccountKey(email, time.Now(), true)
+			server.AddServiceAccountKey(email, time.Now(), false)
+			modified, err := man.ListModifiedResources()
+			if err != nil {
+				t.Fatal("Manager has returned an error:", err)
+			}
+			if len(modified) != 1 || len(modified[0].AppliedConfig()) != 2 {
+				t.Fatal("Expected the account to be reported with its two user managed keys, got", modified)
+			}
+			if _, err := man.Resync(); err != nil {
+				t.Fatal("Unable to resync:", err)
+			}
+			disabled, remaining := 0, 0
+			for _, key := range server.ServiceAccountKeys(email) {
+				if key.KeyType != "USER_MANAGED" {
+					continue
+				}
+				remaining++
+				if key.Disabled {
+					disabled++
+				}
+			}
+			switch {
+			case action == google.KeyActionAlert && (remaining != 2 || disabled != 0):
+				t.Fatal("Expected the keys to be left alone, got", server.ServiceAccountKeys(email))
+			case action == google.KeyActionDisable && disabled != 2:
+				t.Fatal("Expected the unexpected keys to be disabled, got", server.ServiceAccountKeys(email))
+			case action == google.KeyActionDelete && remaining != 0:
+				t.Fatal("Expected the unexpected keys to be deleted, got", server.ServiceAccountKeys(email))
+			}
+		})
+	}
+}
+
+func TestServiceAccountPolicies(t *testing.T) {
+	project := "offline-project"
+	builder := "builder@offline-project.iam.gserviceaccount.com"
+	created := "created@offline-project.iam.gserviceaccount.com"
+	man, server, cleanup := newOfflineManager(t, project, map[string]string{
+		"GoogleCloudPlatform/Project/offline-project/ServiceAccounts/accounts.yml": `---
+- Name: Builder
+  Email: builder@offline-project.iam.gserviceaccount.com
+  Bindings:
+  - Role: roles/iam.serviceAccountUser
+    Members:
+    - user:alice@example.com
+- Name: Created
+  Email: created@offline-project.iam.gserviceaccount.com
+  Bindings:
+  - Role: roles/iam.serviceAccountTokenCreator
+    Members:
+    - serviceAccount:builder@offline-project.iam.gserviceaccount.com
+`,
+	})
+	defer cleanup()
+	server.AddServiceAccount(project, "builder", "Builder")
+	server.SetServiceAccountPolicy(builder, fake.Policy{Bindings: []fake.Binding{
+		{Role: "roles/iam.serviceAccountUser", Members: []string{"user:alice@example.com", "user:mallory@example.com"}},
+		{Role: "roles/iam.serviceAccountTokenCreator", Members: []string{"user:mallory@example.com"}},
+	}})
+	modified, err := man.ListModifiedResources()
+	if err != nil {
+		t.Fatal("Manager has returned an error:", err)
+	}
+	names := map[string]bool{}
+	for _, mod := range modified {
+		names[mod.GetName()] = true
+	}
+	if len(modified) != 2 || !names[builder] || !names[created] {
+		t.Fatal("Expected the builder and created accounts to be modified, got", modified)
+	}
+	if _, err := man.Resync(); err != nil {
+		t.Fatal("Unable to resync:", err)
+	}
+	expected := map[string][]fake.Binding{
+		builder: {{Role: "roles/iam.serviceAccountUser", Members: []string{"user:alice@example.com"}}},
+		created: {{Role: "roles/iam.serviceAccountTokenCreator", Members: []string{"serviceAccount:" + builder}}},
+	}
+	for email, bindings := range expected {
+		policy := server.ServiceAccountPolicy(email)
+		if !reflect.DeepEqual(policy.Bindings, bindings) {
+			t.Errorf("Expected the policy of %s to match the store, got %v", email, policy)
+		}
+	}
+	if modified, err := man.ListModifiedResources(); err != nil || len(modified) != 0 {
+		t.Fatal("Expected no drift after resyncing, got", modified, err)
+	}
+}
+
+func TestUnstoredServiceAccountPolicyIsKept(t *testing.T) {
+	project := "offline-project"
+	builder := "builder@offline-project.iam.gserviceaccount.com"
+	man, server, cleanup := newOfflineManager(t, project, map[string]string{
+		"GoogleCloudPlatform/Project/offline-project/ServiceAccounts/accounts.yml": `---
+- Name: Builder
+  Email: builder@offline-project.iam.gserviceaccount.com
+`,
+	})
+	defer cleanup()
+	server.AddServiceAccount(project, "builder", "Builder")
+	bindings := []fake.Binding{
+		{Role: "roles/iam.serviceAccountUser", Members: []string{"user:alice@example.com"}},
+	}
+	server.SetServiceAccountPolicy(builder, fake.Policy{Bindings: bindings})
+	if modified, err := man.ListModifiedResources(); err != nil || len(modified) != 0 {
+		t.Fatal("Expected no drift without stored Bindings, got", modified, err)
+	}
+	if _, err := man.Resync(); err != nil {
+		t.Fatal("Unable to resync:", err)
+	}
+	if policy := server.ServiceAccountPolicy(builder); !reflect.DeepEqual(policy.Bindings, bindings) {
+		t.Fatal("Expected the policy of the account to be kept, got", policy)
+	}
+}
+
+func TestServiceAccountPolicyKeepsConditions(t *testing.T) {
+	project := "offline-project"
+	builder := "builder@offline-project.iam.gserviceaccount.com"
+	man, server, cleanup := newOfflineManager(t, project, map[string]string{
+		"GoogleCloudPlatform/Project/offline-project/ServiceAccounts/accounts.yml": `---
+- Name: Builder
+  Email: builder@offline-project.iam.gserviceaccount.com
+  Bindings:
+  - Role: roles/iam.serviceAccountUser
+    Members:
+    - user:alice@example.com
+`,
+	})
+	defer cleanup()
+	server.AddServiceAccount(project, "builder", "Builder")
+	oncall := fake.Binding{
+		Role:      "roles/iam.serviceAccountTokenCreator",
+		Members:   []string{"group:oncall@example.com"},
+		Condition: &fake.Expr{Expression: `request.time < timestamp("2030-01-01T00:00:00Z")`, Title: "until 2030"},
+	}
+	server.SetServiceAccountPolicy(builder, fake.Policy{Version: 3, Bindings: []fake.Binding{
+		oncall,
+		{Role: "roles/iam.serviceAccountUser", Members: []string{"user:mallory@example.com"}},
+	}})
+	if _, err := man.Resync(); err != nil {
+		t.Fatal("Unable to resync:", err)
+	}
+	expected := []fake.Binding{oncall, {Role: "roles/iam.serviceAccountUser", Members: []string{"user:alice@example.com"}}}
+	if policy := server.ServiceAccountPolicy(builder); policy.Version != 3 || !reflect.DeepEqual(policy.Bindings, expected) {
+		t.Fatal("Expected the conditional binding to be kept, got", policy)
+	}
+	if modified, err := man.ListModifiedResources(); err != nil || len(modified) != 0 {
+		t.Fatal("Expected no drift after resyncing, got", modified, err)
+	}
+}
+
+func TestPolicyConflictsAreRetried(t *testing.T) {
+	project := "offline-project"
+	man, server, cleanup := newOfflineManager(t, project, map[string]string{
+		"GoogleCloudPlatform/Project/offline-project/Bindings/bindings.yml": `---
+- Role: roles/viewer
+  Members:
+  - user:alice@example.com
+`,
+	})
+	defer cleanup()
+	server.InterfereWithPolicy("projects/"+project, 2, func(policy *fake.Policy) {
+		policy.Bindings = append(policy.Bindings, fake.Binding{Role: "roles/browser", Members: []string{"user:concurrent@example.com"}})
+	})
+	if _, err := man.Resync(); err != nil {
+		t.Fatal("Expected the conflicts to be retried, got", err)
+	}
+	roles := map[string]int{}
+	for _, b := range server.ProjectPolicy(project).Bindings {
+		roles[b.Role]++
+	}
+	if roles["roles/viewer"] != 1 || roles["roles/browser"] != 2 {
+		t.Fatal("Expected the binding to be merged with the concurrent changes, got", server.ProjectPolicy(project))
+	}
+}
+
+func TestPolicyConflictsPersisting(t *testing.T) {
+	project := "offline-project"
+	man, server, cleanup := newOfflineManagerWith(t, project, map[string]string{
+		"GoogleCloudPlatform/Project/offline-project/Bindings/bindings.yml": `---
+- Role: roles/viewer
+  Members:
+  - user:alice@example.com
+`,
+	}, map[string]interface{}{
+		"ConflictRetries": 1,
+	})
+	defer cleanup()
+	server.InterfereWithPolicy("projects/"+project, 2, func(policy *fake.Policy) {})
+	_, err := man.Resync()
+	if !errors.Is(err, overwatch.ErrConflict) {
+		t.Fatal("Expected ErrConflict once every attempt conflicts, got", err)
+	}
+	if policy := server.ProjectPolicy(project); len(policy.Bindings) != 0 {
+		t.Fatal("Expected the policy to be left as it was, got", policy)
+	}
+}
+
+func TestPolicyConflictsBackOff(t *testing.T) {
+	project := "offline-project"
+	man, server, cleanup := newOfflineManagerWith(t, project, map[string]string{
+		"GoogleCloudPlatform/Project/offline-project/Bindings/bindings.yml": `---
+- Role: roles/viewer
+  Members:
+  - user:alice@example.com
+`,
+	}, map[string]interface{}{
+		"ConflictBackoff": 40 * time.Millisecond,
+	})
+	defer cleanup()
+	server.InterfereWithPolicy("projects/"+project, 2, func(policy *fake.Policy) {})
+	start := time.Now()
+	if _, err := man.Resync(); err != nil {
+		t.Fatal("Expected the conflicts to be retried, got", err)
+	}
+	// The two retries wait at least half of 40ms and 80ms
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Fatal("Expected the retries to back off, took", elapsed)
+	}
+}
+
+func TestServiceAccountPolicyConflictsAreRetried(t *testing.T) {
+	project := "offline-project"
+	builder := "builder@offline-project.iam.gserviceaccount.com"
+	man, server, cleanup := newOfflineManager(t, project, map[string]string{
+		"GoogleCloudPlatform/Project/offline-project/ServiceAccounts/accounts.yml": `---
+- Name: Builder
+  Email: builder@offline-project.iam.gserviceaccount.com
+  Bindings:
+  - Role: roles/iam.serviceAccountUser
+    Members:
+    - user:alice@example.com
+`,
+	})
+	defer cleanup()
+	server.AddServiceAccount(project, "builder", "Builder")
+	server.InterfereWithServiceAccountPolicy(builder, 3, func(policy *fake.Policy) {
+		policy.Bindings = append(policy.Bindings, fake.Binding{Role: "roles/owner", Members: []string{"user:mallory@example.com"}})
+	})
+	if _, err := man.Resync(); err != nil {
+		t.Fatal("Expected the conflicts to be retried, got", err)
+	}
+	expected := []fake.Binding{{Role: "roles/iam.serviceAccountUser", Members: []string{"user:alice@example.com"}}}
+	if policy := server.ServiceAccountPolicy(builder); !reflect.DeepEqual(policy.Bindings, expected) {
+		t.Fatal("Expected the policy of the account to match the store, got", policy)
+	}
+}
+
+func TestReloadKeepsHandedConnection(t *testing.T) {
+	man, server, cleanup := newOfflineManager(t, "offline-project", nil)
+	defer cleanup()
+	if _, err := man.ListModifiedResources(); err != nil {
+		t.Fatal("Manager has returned an error:", err)
+	}
+	conn, err := server.Dial()
+	if err != nil {
+		t.Fatal("Unable to dial fake server:", err)
+	}
+	defer conn.Close()
+	dir, err := ioutil.TempDir("", "overwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i := 0; i < 2; i++ {
+		if err := man.LoadConfiguration(offlineConfig(server, conn, dir, "offline-project")); err != nil {
+			t.Fatal("Unable to LoadConfigurations due to", err)
+		}
+		if _, err := man.ListModifiedResources(); err != nil {
+			t.Fatal("Expected the handed over connection to be used, got", err)
+		}
+	}
+	if state := conn.GetState(); state == connectivity.Shutdown {
+		t.Fatal("Expected the handed over connection to be left open")
+	}
+}
+
+// slowTransport delays every request it sends
+type slowTransport struct {
+	delay time.Duration
+}
+
+func (s slowTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	time.Sleep(s.delay)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestRequestTimeoutIsPerRequest(t *testing.T) {
+	project := "offline-project"
+	server, err := fake.NewServer()
+	if err != nil {
+		t.Fatal("Unable to start fake server:", err)
+	}
+	defer server.Close()
+	conn, err := server.Dial()
+	if err != nil {
+		t.Fatal("Unable to dial fake server:", err)
+	}
+	defer conn.Close()
+	dir, err := ioutil.TempDir("", "overwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i := 0; i < 5; i++ {
+		server.AddServiceAccount(project, fmt.Sprintf("account-%d", i), "Account")
+	}
+	// Listing the accounts along with their keys and policies takes far longer than
+	// the timeout, while each request finishes well within it
+	conf := offlineConfig(server, conn, dir, project)
+	conf.Additional["RESTClientOptions"] = []option.ClientOption{
+		option.WithEndpoint(server.ResourceManagerURL),
+		option.WithHTTPClient(&http.Client{Transport: slowTransport{delay: 20 * time.Millisecond}}),
+	}
+	conf.Additional["RequestTimeout"] = 100 * time.Millisecond
+	man, err := google.NewManager()
+	if err != nil {
+		t.Fatal("Unable to create manager")
+	}
+	if err := man.LoadConfiguration(conf); err != nil {
+		t.Fatal("Unable to LoadConfigurations due to", err)
+	}
+	modified, err := man.ListModifiedResources()
+	if err != nil {
+		t.Fatal("Expected each request to have its own timeout, got", err)
+	}
+	if len(modified) != 5 {
+		t.Fatal("Expected every account to be reported, got", modified)
+	}
+}
@@ -0,0 +1,363 @@
+package fake
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	empty "github.com/golang/protobuf/ptypes/empty"
+	adminpb "google.golang.org/genproto/googleapis/iam/admin/v1"
+	iampb "google.golang.org/genproto/googleapis/iam/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultPageSize = 100
+
+// iamService implements adminpb.IAMServer on top of the Server state.
+type iamService struct {
+	s *Server
+}
+
+// AddServiceAccountKey creates a key for the service account that
+// was made valid at the given time.
+func (s *Server) AddServiceAccountKey(email string, created time.Time, userManaged bool) (*adminpb.ServiceAccountKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	acc, exist := s.accounts[email]
+	if !exist {
+		return nil, fmt.Errorf("Unable to find service account %s", email)
+	}
+	return s.createKey(acc, created, userManaged), nil
+}
+
+// AddRole creates a custom role under the parent,
+// for example "projects/my-project" or "organizations/1234".
+func (s *Server) AddRole(parent, roleID string, role *adminpb.Role) *adminpb.Role {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	created := proto.Clone(role).(*adminpb.Role)
+	created.Name = parent + "/roles/" + roleID
+	created.Etag = []byte(strconv.Itoa(s.next()))
+	s.roles[created.Name] = created
+	return proto.Clone(created).(*adminpb.Role)
+}
+
+// Role returns a copy of the custom role with the matching resource name
+func (s *Server) Role(name string) *adminpb.Role {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	role, exist := s.roles[name]
+	if !exist {
+		return nil
+	}
+	return proto.Clone(role).(*adminpb.Role)
+}
+
+func (s *Server) next() int {
+	s.nextID++
+	return s.nextID
+}
+
+func (s *Server) createKey(acc *adminpb.ServiceAccount, created time.Time, userManaged bool) *adminpb.ServiceAccountKey {
+	buff := make([]byte, 20)
+	rand.Read(buff)
+	after, _ := ptypes.TimestampProto(created)
+	before, _ := ptypes.TimestampProto(created.AddDate(10, 0, 0))
+	k := &adminpb.ServiceAccountKey{
+		Name:            acc.Name + "/keys/" + hex.EncodeToString(buff),
+		KeyAlgorithm:    adminpb.ServiceAccountKeyAlgorithm_KEY_ALG_RSA_2048,
+		ValidAfterTime:  after,
+		ValidBeforeTime: before,
+	}
+	s.keys[acc.Email] = append(s.keys[acc.Email], &key{userManaged: userManaged, key: k})
+	return proto.Clone(k).(*adminpb.ServiceAccountKey)
+}
+
+func (i *iamService) ListServiceAccounts(ctx context.Context, req *adminpb.ListServiceAccountsRequest) (*adminpb.ListServiceAccountsResponse, error) {
+	i.s.mu.Lock()
+	defer i.s.mu.Unlock()
+	project := strings.TrimPrefix(req.GetName(), "projects/")
+	all := i.s.sortedAccounts(project)
+	start, end, next, err := page(len(all), req.GetPageSize(), req.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+	resp := &adminpb.ListServiceAccountsResponse{NextPageToken: next}
+	for _, acc := range all[start:end] {
+		resp.Accounts = append(resp.Accounts, copyAccount(acc))
+	}
+	return resp, nil
+}
+
+func (i *iamService) GetServiceAccount(ctx context.Context, req *adminpb.GetServiceAccountRequest) (*adminpb.ServiceAccount, error) {
+	i.s.mu.Lock()
+	defer i.s.mu.Unlock()
+	acc, exist := i.s.lookupAccount(req.GetName())
+	if !exist {
+		return nil, status.Errorf(codes.NotFound, "Service account %s does not exist", req.GetName())
+	}
+	return copyAccount(acc), nil
+}
+
+func (i *iamService) CreateServiceAccount(ctx context.Context, req *adminpb.CreateServiceAccountRequest) (*adminpb.ServiceAccount, error) {
+	i.s.mu.Lock()
+	defer i.s.mu.Unlock()
+	project := strings.TrimPrefix(req.GetName(), "projects/")
+	if req.GetAccountId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "account_id is required")
+	}
+	email := fmt.Sprintf("%s@%s.iam.gserviceaccount.com", req.GetAccountId(), project)
+	if _, exist := i.s.accounts[email]; exist {
+		return nil, status.Errorf(codes.AlreadyExists, "Service account %s already exists", email)
+	}
+	return i.s.createAccount(project, req.GetAccountId(), req.GetServiceAccount().GetDisplayName()), nil
+}
+
+func (i *iamService) UpdateServiceAccount(ctx context.Context, req *adminpb.ServiceAccount) (*adminpb.ServiceAccount, error) {
+	i.s.mu.Lock()
+	defer i.s.mu.Unlock()
+	acc, exist := i.s.lookupAccount(req.GetName())
+	if !exist {
+		return nil, status.Errorf(codes.NotFound, "Service account %s does not exist", req.GetName())
+	}
+	if len(req.GetEtag()) != 0 && string(req.GetEtag()) != string(acc.Etag) {
+		return nil, status.Error(codes.Aborted, "Etag does not match the current service account")
+	}
+	acc.DisplayName = req.GetDisplayName()
+	acc.Etag = []byte(strconv.Itoa(i.s.next()))
+	return copyAccount(acc), nil
+}
+
+func (i *iamService) DeleteServiceAccount(ctx context.Context, req *adminpb.DeleteServiceAccountRequest) (*empty.Empty, error) {
+	i.s.mu.Lock()
+	defer i.s.mu.Unlock()
+	acc, exist := i.s.lookupAccount(req.GetName())
+	if !exist {
+		return nil, status.Errorf(codes.NotFound, "Service account %s does not exist", req.GetName())
+	}
+	i.s.deleteAccount(acc)
+	return &empty.Empty{}, nil
+}
+
+func (i *iamService) ListServiceAccountKeys(ctx context.Context, req *adminpb.ListServiceAccountKeysRequest) (*adminpb.ListServiceAccountKeysResponse, error) {
+	i.s.mu.Lock()
+	defer i.s.mu.Unlock()
+	acc, exist := i.s.lookupAccount(req.GetName())
+	if !exist {
+		return nil, status.Errorf(codes.NotFound, "Service account %s does not exist", req.GetName())
+	}
+	wantUser, wantSystem := len(req.GetKeyTypes()) == 0, len(req.GetKeyTypes()) == 0
+	for _, t := range req.GetKeyTypes() {
+		switch t {
+		case adminpb.ListServiceAccountKeysRequest_USER_MANAGED:
+			wantUser = true
+		case adminpb.ListServiceAccountKeysRequest_SYSTEM_MANAGED:
+			wantSystem = true
+		}
+	}
+	resp := &adminpb.ListServiceAccountKeysResponse{}
+	for _, k := range i.s.keys[acc.Email] {
+		if (k.userManaged && wantUser) || (!k.userManaged && wantSystem) {
+			resp.Keys = append(resp.Keys, proto.Clone(k.key).(*adminpb.ServiceAccountKey))
+		}
+	}
+	return resp, nil
+}
+
+func (i *iamService) GetServiceAccountKey(ctx context.Context, req *adminpb.GetServiceAccountKeyRequest) (*adminpb.ServiceAccountKey, error) {
+	i.s.mu.Lock()
+	defer i.s.mu.Unlock()
+	for _, keys := range i.s.keys {
+		for _, k := range keys {
+			if k.key.Name == req.GetName() {
+				return proto.Clone(k.key).(*adminpb.ServiceAccountKey), nil
+			}
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "Key %s does not exist", req.GetName())
+}
+
+func (i *iamService) CreateServiceAccountKey(ctx context.Context, req *adminpb.CreateServiceAccountKeyRequest) (*adminpb.ServiceAccountKey, error) {
+	i.s.mu.Lock()
+	defer i.s.mu.Unlock()
+	acc, exist := i.s.lookupAccount(req.GetName())
+	if !exist {
+		return nil, status.Errorf(codes.NotFound, "Service account %s does not exist", req.GetName())
+	}
+	return i.s.createKey(acc, time.Now(), true), nil
+}
+
+func (i *iamService) DeleteServiceAccountKey(ctx context.Context, req *adminpb.DeleteServiceAccountKeyRequest) (*empty.Empty, error) {
+	i.s.mu.Lock()
+	defer i.s.mu.Unlock()
+	for email, keys := range i.s.keys {
+		for index, k := range keys {
+			if k.key.Name == req.GetName() {
+				i.s.keys[email] = append(keys[:index], keys[index+1:]...)
+				return &empty.Empty{}, nil
+			}
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "Key %s does not exist", req.GetName())
+}
+
+func (i *iamService) SignBlob(ctx context.Context, req *adminpb.SignBlobRequest) (*adminpb.SignBlobResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "SignBlob is not supported by the fake")
+}
+
+func (i *iamService) SignJwt(ctx context.Context, req *adminpb.SignJwtRequest) (*adminpb.SignJwtResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "SignJwt is not supported by the fake")
+}
+
+func (i *iamService) GetIamPolicy(ctx context.Context, req *iampb.GetIamPolicyRequest) (*iampb.Policy, error) {
+	return nil, status.Error(codes.Unimplemented, "The policies of service accounts are only served over REST by the fake")
+}
+
+func (i *iamService) SetIamPolicy(ctx context.Context, req *iampb.SetIamPolicyRequest) (*iampb.Policy, error) {
+	return nil, status.Error(codes.Unimplemented, "The policies of service accounts are only served over REST by the fake")
+}
+
+func (i *iamService) TestIamPermissions(ctx context.Context, req *iampb.TestIamPermissionsRequest) (*iampb.TestIamPermissionsResponse, error) {
+	return &iampb.TestIamPermissionsResponse{Permissions: req.GetPermissions()}, nil
+}
+
+func (i *iamService) QueryGrantableRoles(ctx context.Context, req *adminpb.QueryGrantableRolesRequest) (*adminpb.QueryGrantableRolesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "QueryGrantableRoles is not supported by the fake")
+}
+
+func (i *iamService) ListRoles(ctx context.Context, req *adminpb.ListRolesRequest) (*adminpb.ListRolesResponse, error) {
+	i.s.mu.Lock()
+	defer i.s.mu.Unlock()
+	names := []string{}
+	for name, role := range i.s.roles {
+		if strings.HasPrefix(name, req.GetParent()+"/roles/") && (!role.Deleted || req.GetShowDeleted()) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	start, end, next, err := page(len(names), req.GetPageSize(), req.GetPageToken())
+	if err != nil {
+		return nil, err
+	}
+	resp := &adminpb.ListRolesResponse{NextPageToken: next}
+	for _, name := range names[start:end] {
+		role := proto.Clone(i.s.roles[name]).(*adminpb.Role)
+		if req.GetView() != adminpb.RoleView_FULL {
+			role.IncludedPermissions = nil
+		}
+		resp.Roles = append(resp.Roles, role)
+	}
+	return resp, nil
+}
+
+func (i *iamService) GetRole(ctx context.Context, req *adminpb.GetRoleRequest) (*adminpb.Role, error) {
+	i.s.mu.Lock()
+	defer i.s.mu.Unlock()
+	role, exist := i.s.roles[req.GetName()]
+	if !exist {
+		return nil, status.Errorf(codes.NotFound, "Role %s does not exist", req.GetName())
+	}
+	return proto.Clone(role).(*adminpb.Role), nil
+}
+
+func (i *iamService) CreateRole(ctx context.Context, req *adminpb.CreateRoleRequest) (*adminpb.Role, error) {
+	i.s.mu.Lock()
+	defer i.s.mu.Unlock()
+	name := req.GetParent() + "/roles/" + req.GetRoleId()
+	if _, exist := i.s.roles[name]; exist {
+		return nil, status.Errorf(codes.AlreadyExists, "Role %s already exists", name)
+	}
+	role := proto.Clone(req.GetRole()).(*adminpb.Role)
+	role.Name = name
+	role.Etag = []byte(strconv.Itoa(i.s.next()))
+	i.s.roles[name] = role
+	return proto.Clone(role).(*adminpb.Role), nil
+}
+
+func (i *iamService) UpdateRole(ctx context.Context, req *adminpb.UpdateRoleRequest) (*adminpb.Role, error) {
+	i.s.mu.Lock()
+	defer i.s.mu.Unlock()
+	role, exist := i.s.roles[req.GetName()]
+	if !exist {
+		return nil, status.Errorf(codes.NotFound, "Role %s does not exist", req.GetName())
+	}
+	if len(req.GetRole().GetEtag()) != 0 && string(req.GetRole().GetEtag()) != string(role.Etag) {
+		return nil, status.Error(codes.Aborted, "Etag does not match the current role")
+	}
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		paths = []string{"title", "description", "included_permissions", "stage"}
+	}
+	for _, path := range paths {
+		switch path {
+		case "title":
+			role.Title = req.GetRole().GetTitle()
+		case "description":
+			role.Description = req.GetRole().GetDescription()
+		case "included_permissions", "includedPermissions":
+			role.IncludedPermissions = append([]string{}, req.GetRole().GetIncludedPermissions()...)
+		case "stage":
+			role.Stage = req.GetRole().GetStage()
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "Unknown update mask path %s", path)
+		}
+	}
+	role.Etag = []byte(strconv.Itoa(i.s.next()))
+	return proto.Clone(role).(*adminpb.Role), nil
+}
+
+func (i *iamService) DeleteRole(ctx context.Context, req *adminpb.DeleteRoleRequest) (*adminpb.Role, error) {
+	return i.setDeleted(req.GetName(), true)
+}
+
+func (i *iamService) UndeleteRole(ctx context.Context, req *adminpb.UndeleteRoleRequest) (*adminpb.Role, error) {
+	return i.setDeleted(req.GetName(), false)
+}
+
+func (i *iamService) QueryTestablePermissions(ctx context.Context, req *adminpb.QueryTestablePermissionsRequest) (*adminpb.QueryTestablePermissionsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "QueryTestablePermissions is not supported by the fake")
+}
+
+func (i *iamService) setDeleted(name string, deleted bool) (*adminpb.Role, error) {
+	i.s.mu.Lock()
+	defer i.s.mu.Unlock()
+	role, exist := i.s.roles[name]
+	if !exist {
+		return nil, status.Errorf(codes.NotFound, "Role %s does not exist", name)
+	}
+	role.Deleted = deleted
+	role.Etag = []byte(strconv.Itoa(i.s.next()))
+	return proto.Clone(role).(*adminpb.Role), nil
+}
+
+// page converts the page size and token of a list request into the
+// range of items to return and the token for the following page.
+func page(total int, size int32, token string) (int, int, string, error) {
+	start := 0
+	if token != "" {
+		var err error
+		if start, err = strconv.Atoi(token); err != nil || start > total {
+			return 0, 0, "", status.Errorf(codes.InvalidArgument, "Invalid page token %s", token)
+		}
+	}
+	if size <= 0 {
+		size = defaultPageSize
+	}
+	end := start + int(size)
+	if end >= total {
+		return start, total, "", nil
+	}
+	return start, end, strconv.Itoa(end), nil
+}
+
+func copyAccount(acc *adminpb.ServiceAccount) *adminpb.ServiceAccount {
+	return proto.Clone(acc).(*adminpb.ServiceAccount)
+}
@@ -0,0 +1,269 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	adminpb "google.golang.org/genproto/googleapis/iam/admin/v1"
+)
+
+// Account mirrors the JSON representation of a service account
+// used by the IAM v1 REST API, which includes the description
+// and disabled state that the gRPC service does not expose.
+type Account struct {
+	Name        string `json:"name"`
+	ProjectID   string `json:"projectId"`
+	UniqueID    string `json:"uniqueId"`
+	Email       string `json:"email"`
+	DisplayName string `json:"displayName"`
+	Description string `json:"description,omitempty"`
+	Disabled    bool   `json:"disabled,omitempty"`
+	Etag        []byte `json:"etag"`
+}
+
+// Key mirrors the JSON representation of a service account key used by the IAM v1 REST API
+type Key struct {
+	Name            string `json:"name"`
+	KeyAlgorithm    string `json:"keyAlgorithm"`
+	ValidAfterTime  string `json:"validAfterTime"`
+	ValidBeforeTime string `json:"validBeforeTime"`
+	KeyType         string `json:"keyType"`
+	Disabled        bool   `json:"disabled,omitempty"`
+}
+
+// iamREST serves the service account calls of the IAM v1 REST API
+type iamREST struct {
+	s *Server
+}
+
+// UpdateServiceAccount changes the display name, description or disabled
+// state of the service account as if it had been changed outside of overwatch.
+func (s *Server) UpdateServiceAccount(email string, update func(*Account)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	acc, exist := s.accounts[email]
+	if !exist {
+		return fmt.Errorf("Unable to find service account %s", email)
+	}
+	current := s.restAccount(acc)
+	update(&current)
+	s.setAccount(acc, current)
+	return nil
+}
+
+// ServiceAccount returns the service account with the matching email
+// along with the fields only the REST API exposes.
+func (s *Server) ServiceAccount(email string) (Account, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	acc, exist := s.accounts[email]
+	if !exist {
+		return Account{}, false
+	}
+	return s.restAccount(acc), true
+}
+
+// ServiceAccountKeys returns the keys of the service account with the matching email
+func (s *Server) ServiceAccountKeys(email string) []Key {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []Key{}
+	for _, k := range s.keys[email] {
+		keys = append(keys, restKey(k))
+	}
+	return keys
+}
+
+func restKey(k *key) Key {
+	after, _ := ptypes.Timestamp(k.key.ValidAfterTime)
+	before, _ := ptypes.Timestamp(k.key.ValidBeforeTime)
+	keyType := "SYSTEM_MANAGED"
+	if k.userManaged {
+		keyType = "USER_MANAGED"
+	}
+	return Key{
+		Name:            k.key.Name,
+		KeyAlgorithm:    k.key.KeyAlgorithm.String(),
+		ValidAfterTime:  after.Format(time.RFC3339),
+		ValidBeforeTime: before.Format(time.RFC3339),
+		KeyType:         keyType,
+		Disabled:        k.disabled,
+	}
+}
+
+func (s *Server) restAccount(acc *adminpb.ServiceAccount) Account {
+	return Account{
+		Name:        acc.Name,
+		ProjectID:   acc.ProjectId,
+		UniqueID:    acc.UniqueId,
+		Email:       acc.Email,
+		DisplayName: acc.DisplayName,
+		Description: s.descriptions[acc.Email],
+		Disabled:    s.disabled[acc.Email],
+		Etag:        append([]byte{}, acc.Etag...),
+	}
+}
+
+func (s *Server) setAccount(acc *adminpb.ServiceAccount, updated Account) {
+	acc.DisplayName = updated.DisplayName
+	acc.Etag = []byte(strconv.Itoa(s.next()))
+	s.descriptions[acc.Email] = updated.Description
+	s.disabled[acc.Email] = updated.Disabled
+}
+
+func (s *Server) deleteAccount(acc *adminpb.ServiceAccount) {
+	delete(s.accounts, acc.Email)
+	delete(s.keys, acc.Email)
+	delete(s.resourcePolicies, acc.Name)
+	delete(s.descriptions, acc.Email)
+	delete(s.disabled, acc.Email)
+}
+
+// ServeHTTP handles the paths under projects/<project>/serviceAccounts,
+// including the keys and IAM policy of each service account.
+func (r *iamREST) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/v1/"), "/")
+	if len(parts) == 3 && req.Method == http.MethodGet {
+		r.list(w, req, parts[1])
+		return
+	}
+	if len(parts) < 4 {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Unknown path "+req.URL.Path)
+		return
+	}
+	account, method := splitMethod(parts[3])
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	acc, exist := r.s.lookupAccount(account)
+	if !exist {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Service account "+account+" does not exist")
+		return
+	}
+	switch {
+	case len(parts) == 4 && method == "" && req.Method == http.MethodGet:
+		writeJSON(w, r.s.restAccount(acc))
+	case len(parts) == 4 && method == "" && req.Method == http.MethodPatch:
+		r.patch(w, req, acc)
+	case len(parts) == 4 && method == "disable" && req.Method == http.MethodPost:
+		r.s.disabled[acc.Email] = true
+		writeJSON(w, struct{}{})
+	case len(parts) == 4 && method == "enable" && req.Method == http.MethodPost:
+		r.s.disabled[acc.Email] = false
+		writeJSON(w, struct{}{})
+	case len(parts) == 4 && (method == "getIamPolicy" || method == "setIamPolicy") && req.Method == http.MethodPost:
+		r.s.servePolicy(w, req, acc.Name, method)
+	case len(parts) == 5 && parts[4] == "keys" && req.Method == http.MethodGet:
+		r.listKeys(w, req, acc)
+	case len(parts) == 6 && parts[4] == "keys":
+		r.key(w, req, acc, parts[5])
+	default:
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Unknown path "+req.URL.Path)
+	}
+}
+
+// splitMethod separates a custom method, such as ":disable", from the end of a path segment
+func splitMethod(segment string) (string, string) {
+	if i := strings.Index(segment, ":"); i != -1 {
+		return segment[:i], segment[i+1:]
+	}
+	return segment, ""
+}
+
+func (r *iamREST) listKeys(w http.ResponseWriter, req *http.Request, acc *adminpb.ServiceAccount) {
+	types := req.URL.Query()["keyTypes"]
+	resp := struct {
+		Keys []Key `json:"keys,omitempty"`
+	}{}
+	for _, k := range r.s.keys[acc.Email] {
+		key := restKey(k)
+		wanted := len(types) == 0
+		for _, t := range types {
+			wanted = wanted || t == key.KeyType
+		}
+		if wanted {
+			resp.Keys = append(resp.Keys, key)
+		}
+	}
+	writeJSON(w, resp)
+}
+
+// key deletes, disables or enables a single key of the account
+func (r *iamREST) key(w http.ResponseWriter, req *http.Request, acc *adminpb.ServiceAccount, segment string) {
+	id, method := splitMethod(segment)
+	keys := r.s.keys[acc.Email]
+	for index, k := range keys {
+		if k.key.Name != acc.Name+"/keys/"+id {
+			continue
+		}
+		switch {
+		case method == "" && req.Method == http.MethodDelete:
+			r.s.keys[acc.Email] = append(keys[:index], keys[index+1:]...)
+		case method == "disable" && req.Method == http.MethodPost:
+			k.disabled = true
+		case method == "enable" && req.Method == http.MethodPost:
+			k.disabled = false
+		default:
+			writeError(w, http.StatusNotFound, "NOT_FOUND", "Unknown method "+method)
+			return
+		}
+		writeJSON(w, struct{}{})
+		return
+	}
+	writeError(w, http.StatusNotFound, "NOT_FOUND", "Key "+id+" does not exist")
+}
+
+func (r *iamREST) list(w http.ResponseWriter, req *http.Request, project string) {
+	size, _ := strconv.Atoi(req.URL.Query().Get("pageSize"))
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	all := r.s.sortedAccounts(project)
+	start, end, next, err := page(len(all), int32(size), req.URL.Query().Get("pageToken"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+		return
+	}
+	resp := struct {
+		Accounts      []Account `json:"accounts,omitempty"`
+		NextPageToken string    `json:"nextPageToken,omitempty"`
+	}{NextPageToken: next}
+	for _, acc := range all[start:end] {
+		resp.Accounts = append(resp.Accounts, r.s.restAccount(acc))
+	}
+	writeJSON(w, resp)
+}
+
+// patch updates the fields named by the update mask, the etag is
+// only checked when the request includes one.
+func (r *iamREST) patch(w http.ResponseWriter, req *http.Request, acc *adminpb.ServiceAccount) {
+	var body struct {
+		ServiceAccount Account `json:"serviceAccount"`
+		UpdateMask     string  `json:"updateMask"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+		return
+	}
+	if len(body.ServiceAccount.Etag) != 0 && string(body.ServiceAccount.Etag) != string(acc.Etag) {
+		writeError(w, http.StatusConflict, "ABORTED", "Etag does not match the current service account")
+		return
+	}
+	updated := r.s.restAccount(acc)
+	for _, field := range strings.Split(body.UpdateMask, ",") {
+		switch strings.TrimSpace(field) {
+		case "displayName":
+			updated.DisplayName = body.ServiceAccount.DisplayName
+		case "description":
+			updated.Description = body.ServiceAccount.Description
+		default:
+			writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Unable to update field "+field)
+			return
+		}
+	}
+	r.s.setAccount(acc, updated)
+	writeJSON(w, r.s.restAccount(acc))
+}
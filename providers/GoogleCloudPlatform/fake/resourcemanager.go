@@ -0,0 +1,321 @@
+package fake
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Policy mirrors the JSON representation of an IAM policy
+// used by the Cloud Resource Manager REST API.
+type Policy struct {
+	Version  int       `json:"version,omitempty"`
+	Bindings []Binding `json:"bindings,omitempty"`
+	Etag     string    `json:"etag,omitempty"`
+}
+
+// Binding associates members with a role
+type Binding struct {
+	Role      string   `json:"role"`
+	Members   []string `json:"members"`
+	Condition *Expr    `json:"condition,omitempty"`
+}
+
+// Expr is a Common Expression Language condition attached to a binding
+type Expr struct {
+	Expression  string `json:"expression"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+const emptyEtag = "BwAAAAAAAAA="
+
+// resourceManager serves the getIamPolicy and setIamPolicy calls of projects,
+// folders and organizations along with listing the projects and folders
+// of the Cloud Resource Manager v1 and v2 REST APIs.
+type resourceManager struct {
+	s *Server
+}
+
+// container is a project or folder and where it sits in the hierarchy
+type container struct {
+	parent  string
+	deleted bool
+}
+
+// AddProject places the project inside of the parent, such as organizations/<id>
+// or folders/<id>, so that it can be discovered.
+func (s *Server) AddProject(project, parent string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.containers["projects/"+project] = &container{parent: parent}
+}
+
+// AddFolder places the folder inside of the parent, such as organizations/<id>
+func (s *Server) AddFolder(folder, parent string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.containers["folders/"+folder] = &container{parent: parent}
+}
+
+// DeleteProject marks the project as pending deletion
+func (s *Server) DeleteProject(project string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, exist := s.containers["projects/"+project]; exist {
+		c.deleted = true
+	}
+}
+
+// InterfereWithPolicy makes change to the IAM policy of the resource, such as
+// projects/<id>, right before each of the next times it is set. Setting the policy
+// with the etag that was read then fails as the policy has changed since.
+func (s *Server) InterfereWithPolicy(resource string, times int, change func(*Policy)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interference[resource] = &interference{times: times, change: change}
+}
+
+// interfere applies any pending change to the policy of the resource, it must be called with mu held
+func (s *Server) interfere(resource string) {
+	i, exist := s.interference[resource]
+	if !exist || i.times == 0 || i.change == nil {
+		return
+	}
+	i.times--
+	policy, exist := s.resourcePolicies[resource]
+	if !exist {
+		policy = &Policy{}
+	}
+	i.change(policy)
+	policy.Etag = s.nextEtag()
+	s.resourcePolicies[resource] = policy
+}
+
+// SetPolicy replaces the IAM policy of the resource, such as
+// folders/<id>, as if it had been changed outside of overwatch.
+func (s *Server) SetPolicy(resource string, policy Policy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	policy.Etag = s.nextEtag()
+	s.resourcePolicies[resource] = copyRestPolicy(&policy)
+}
+
+// Policy returns a copy of the current IAM policy of the resource
+func (s *Server) Policy(resource string) Policy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.policy(resource)
+}
+
+// policy returns a copy of the policy of the resource, it must be called with mu held
+func (s *Server) policy(resource string) Policy {
+	if policy, exist := s.resourcePolicies[resource]; exist {
+		return *copyRestPolicy(policy)
+	}
+	return Policy{Etag: emptyEtag}
+}
+
+// SetProjectPolicy replaces the IAM policy of the project
+// as if it had been changed outside of overwatch.
+func (s *Server) SetProjectPolicy(project string, policy Policy) {
+	s.SetPolicy("projects/"+project, policy)
+}
+
+// ProjectPolicy returns a copy of the current IAM policy of the project
+func (s *Server) ProjectPolicy(project string) Policy {
+	return s.Policy("projects/" + project)
+}
+
+func (s *Server) nextEtag() string {
+	return base64.StdEncoding.EncodeToString([]byte(strconv.Itoa(s.next())))
+}
+
+// children returns the names of the containers of the kind directly inside of parent
+func (s *Server) children(kind, parent string) []string {
+	names := []string{}
+	for name, c := range s.containers {
+		if strings.HasPrefix(name, kind+"/") && c.parent == parent {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *resourceManager) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case req.Method == http.MethodGet && req.URL.Path == "/v1/projects":
+		r.listProjects(w, req)
+		return
+	case req.Method == http.MethodGet && req.URL.Path == "/v2/folders":
+		r.listFolders(w, req)
+		return
+	}
+	resource, method := splitMethod(strings.TrimPrefix(req.URL.Path, "/"))
+	parts := strings.Split(resource, "/")
+	valid := req.Method == http.MethodPost && len(parts) == 3
+	if valid {
+		switch parts[0] + "/" + parts[1] {
+		case "v1/projects", "v1/organizations", "v2/folders":
+		default:
+			valid = false
+		}
+	}
+	if !valid {
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Unknown path "+req.URL.Path)
+		return
+	}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	r.s.servePolicy(w, req, parts[1]+"/"+parts[2], method)
+}
+
+// servePolicy handles the getIamPolicy and setIamPolicy calls of the resource,
+// the version is requested either in the body or as a query parameter like the
+// IAM API does. It must be called with mu held.
+func (s *Server) servePolicy(w http.ResponseWriter, req *http.Request, resource, method string) {
+	switch method {
+	case "getIamPolicy":
+		var body struct {
+			Options struct {
+				RequestedPolicyVersion int `json:"requestedPolicyVersion"`
+			} `json:"options"`
+		}
+		json.NewDecoder(req.Body).Decode(&body)
+		version := body.Options.RequestedPolicyVersion
+		if v := req.URL.Query().Get("options.requestedPolicyVersion"); v != "" {
+			version, _ = strconv.Atoi(v)
+		}
+		writeJSON(w, versioned(s.policy(resource), version))
+	case "setIamPolicy":
+		var body struct {
+			Policy Policy `json:"policy"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+			return
+		}
+		if hasConditions(body.Policy) && body.Policy.Version < 3 {
+			writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Conditions require policy version 3")
+			return
+		}
+		s.interfere(resource)
+		if body.Policy.Etag != "" && body.Policy.Etag != s.policy(resource).Etag {
+			writeError(w, http.StatusConflict, "ABORTED", "There were concurrent policy changes")
+			return
+		}
+		body.Policy.Etag = s.nextEtag()
+		s.resourcePolicies[resource] = copyRestPolicy(&body.Policy)
+		writeJSON(w, body.Policy)
+	default:
+		writeError(w, http.StatusNotFound, "NOT_FOUND", "Unknown method "+method)
+	}
+}
+
+// listProjects only understands the filter "parent.type:<type> parent.id:<id>"
+func (r *resourceManager) listProjects(w http.ResponseWriter, req *http.Request) {
+	var kind, id string
+	for _, term := range strings.Fields(req.URL.Query().Get("filter")) {
+		switch {
+		case strings.HasPrefix(term, "parent.type:"):
+			kind = strings.TrimPrefix(term, "parent.type:")
+		case strings.HasPrefix(term, "parent.id:"):
+			id = strings.TrimPrefix(term, "parent.id:")
+		}
+	}
+	type project struct {
+		ProjectID      string `json:"projectId"`
+		LifecycleState string `json:"lifecycleState"`
+	}
+	resp := struct {
+		Projects []project `json:"projects,omitempty"`
+	}{}
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, name := range r.s.children("projects", kind+"s/"+id) {
+		state := "ACTIVE"
+		if r.s.containers[name].deleted {
+			state = "DELETE_REQUESTED"
+		}
+		resp.Projects = append(resp.Projects, project{ProjectID: strings.TrimPrefix(name, "projects/"), LifecycleState: state})
+	}
+	writeJSON(w, resp)
+}
+
+func (r *resourceManager) listFolders(w http.ResponseWriter, req *http.Request) {
+	type folder struct {
+		Name           string `json:"name"`
+		Parent         string `json:"parent"`
+		LifecycleState string `json:"lifecycleState"`
+	}
+	resp := struct {
+		Folders []folder `json:"folders,omitempty"`
+	}{}
+	parent := req.URL.Query().Get("parent")
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	for _, name := range r.s.children("folders", parent) {
+		resp.Folders = append(resp.Folders, folder{Name: name, Parent: parent, LifecycleState: "ACTIVE"})
+	}
+	writeJSON(w, resp)
+}
+
+func hasConditions(policy Policy) bool {
+	for _, b := range policy.Bindings {
+		if b.Condition != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// versioned returns the policy as it is seen by a client asking for the version,
+// like Google the conditions are left out of a policy older than version 3 and
+// the roles of conditional bindings are suffixed with _withcond_.
+func versioned(policy Policy, version int) Policy {
+	if version >= 3 || !hasConditions(policy) {
+		return policy
+	}
+	policy.Version = 1
+	for i, b := range policy.Bindings {
+		if b.Condition != nil {
+			policy.Bindings[i].Role = b.Role + "_withcond_"
+			policy.Bindings[i].Condition = nil
+		}
+	}
+	return policy
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, state, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"status":  state,
+		},
+	})
+}
+
+func copyRestPolicy(policy *Policy) *Policy {
+	cp := &Policy{Version: policy.Version, Etag: policy.Etag}
+	for _, b := range policy.Bindings {
+		binding := Binding{Role: b.Role, Members: append([]string{}, b.Members...)}
+		if b.Condition != nil {
+			cond := *b.Condition
+			binding.Condition = &cond
+		}
+		cp.Bindings = append(cp.Bindings, binding)
+	}
+	return cp
+}
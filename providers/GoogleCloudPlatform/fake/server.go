@@ -0,0 +1,216 @@
+// Package fake provides an in-process stand in for the Google Cloud
+// IAM Admin gRPC service, the service account calls of the IAM REST API
+// and the Cloud Resource Manager calls for IAM policies and listing projects.
+// It allows the GoogleCloudPlatform manager to be exercised without
+// credentials or a real project.
+package fake
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	adminpb "google.golang.org/genproto/googleapis/iam/admin/v1"
+	"google.golang.org/grpc"
+)
+
+// Server holds the state of the fake IAM backend.
+// All methods are safe to be called concurrently.
+type Server struct {
+	// Addr is the address that the gRPC IAM Admin service is listening on
+	Addr string
+	// ResourceManagerURL is the base url of the REST services,
+	// both Resource Manager and IAM are served from it.
+	ResourceManagerURL string
+
+	mu       sync.Mutex
+	nextID   int
+	accounts map[string]*adminpb.ServiceAccount
+	// descriptions and disabled are keyed by email, as only the REST API exposes them
+	descriptions map[string]string
+	disabled     map[string]bool
+	keys         map[string][]*key
+	roles        map[string]*adminpb.Role
+	// resourcePolicies are the IAM policies of projects, folders, organizations
+	// and service accounts, keyed by their resource name
+	resourcePolicies map[string]*Policy
+	// containers are the projects and folders that are able to be listed
+	containers map[string]*container
+	// interference changes a policy just before it is written, keyed by resource name
+	interference map[string]*interference
+
+	grpc *grpc.Server
+	http *httptest.Server
+}
+
+type key struct {
+	userManaged bool
+	disabled    bool
+	key         *adminpb.ServiceAccountKey
+}
+
+// interference is a change someone else makes to a policy between
+// it being read and written, for the next times it is written.
+type interference struct {
+	times  int
+	change func(*Policy)
+}
+
+// NewServer starts both the gRPC and REST services on the loopback interface.
+// Close must be called once the server is no longer needed.
+func NewServer() (*Server, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Addr:             lis.Addr().String(),
+		accounts:         map[string]*adminpb.ServiceAccount{},
+		descriptions:     map[string]string{},
+		disabled:         map[string]bool{},
+		keys:             map[string][]*key{},
+		roles:            map[string]*adminpb.Role{},
+		resourcePolicies: map[string]*Policy{},
+		containers:       map[string]*container{},
+		interference:     map[string]*interference{},
+		grpc:             grpc.NewServer(),
+	}
+	adminpb.RegisterIAMServer(s.grpc, &iamService{s})
+	go s.grpc.Serve(lis)
+	s.http = httptest.NewServer(http.HandlerFunc(s.serveREST))
+	s.ResourceManagerURL = s.http.URL
+	return s, nil
+}
+
+// Dial creates an insecure client connection to the fake gRPC service.
+func (s *Server) Dial() (*grpc.ClientConn, error) {
+	return grpc.Dial(s.Addr, grpc.WithInsecure())
+}
+
+// serveREST sends the service account paths to IAM and everything else to Resource Manager
+func (s *Server) serveREST(w http.ResponseWriter, req *http.Request) {
+	if strings.Contains(req.URL.Path, "/serviceAccounts") {
+		(&iamREST{s}).ServeHTTP(w, req)
+		return
+	}
+	(&resourceManager{s}).ServeHTTP(w, req)
+}
+
+// Close stops all the services that the server started
+func (s *Server) Close() {
+	s.grpc.Stop()
+	s.http.Close()
+}
+
+// AddServiceAccount creates a service account in the project
+// as if it had been made by someone outside of overwatch.
+func (s *Server) AddServiceAccount(project, accountID, displayName string) *adminpb.ServiceAccount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createAccount(project, accountID, displayName)
+}
+
+// RemoveServiceAccount deletes the service account with the matching email
+func (s *Server) RemoveServiceAccount(email string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if acc, exist := s.accounts[email]; exist {
+		s.deleteAccount(acc)
+	}
+}
+
+// ServiceAccounts returns a copy of all the service accounts in the project
+func (s *Server) ServiceAccounts(project string) []*adminpb.ServiceAccount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	accounts := []*adminpb.ServiceAccount{}
+	for _, acc := range s.sortedAccounts(project) {
+		accounts = append(accounts, copyAccount(acc))
+	}
+	return accounts
+}
+
+// SetServiceAccountPolicy replaces the IAM policy attached to the
+// service account with the matching email.
+func (s *Server) SetServiceAccountPolicy(email string, policy Policy) {
+	if name, exist := s.accountName(email); exist {
+		s.SetPolicy(name, policy)
+	}
+}
+
+// InterfereWithServiceAccountPolicy makes change to the IAM policy of the service
+// account right before each of the next times it is set, making those calls fail.
+func (s *Server) InterfereWithServiceAccountPolicy(email string, times int, change func(*Policy)) {
+	if name, exist := s.accountName(email); exist {
+		s.InterfereWithPolicy(name, times, change)
+	}
+}
+
+// ServiceAccountPolicy returns a copy of the IAM policy attached to the
+// service account with the matching email.
+func (s *Server) ServiceAccountPolicy(email string) Policy {
+	name, _ := s.accountName(email)
+	return s.Policy(name)
+}
+
+// accountName returns the resource name of the service account with the matching email
+func (s *Server) accountName(email string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	acc, exist := s.accounts[email]
+	if !exist {
+		return "", false
+	}
+	return acc.Name, true
+}
+
+func (s *Server) createAccount(project, accountID, displayName string) *adminpb.ServiceAccount {
+	id := s.next()
+	email := fmt.Sprintf("%s@%s.iam.gserviceaccount.com", accountID, project)
+	acc := &adminpb.ServiceAccount{
+		Name:        fmt.Sprintf("projects/%s/serviceAccounts/%s", project, email),
+		ProjectId:   project,
+		UniqueId:    fmt.Sprintf("%021d", id),
+		Email:       email,
+		DisplayName: displayName,
+		Etag:        []byte(fmt.Sprint(id)),
+	}
+	s.accounts[email] = acc
+	return copyAccount(acc)
+}
+
+// sortedAccounts returns the stored accounts for the project ordered by email
+// so that paging through them is stable.
+func (s *Server) sortedAccounts(project string) []*adminpb.ServiceAccount {
+	emails := []string{}
+	for email, acc := range s.accounts {
+		if project == "-" || acc.ProjectId == project {
+			emails = append(emails, email)
+		}
+	}
+	sort.Strings(emails)
+	accounts := []*adminpb.ServiceAccount{}
+	for _, email := range emails {
+		accounts = append(accounts, s.accounts[email])
+	}
+	return accounts
+}
+
+// lookupAccount accepts any of the resource name forms the API allows,
+// projects/<project>/serviceAccounts/<email|unique id>
+func (s *Server) lookupAccount(name string) (*adminpb.ServiceAccount, bool) {
+	id := name[strings.LastIndex(name, "/")+1:]
+	if acc, exist := s.accounts[id]; exist {
+		return acc, true
+	}
+	for _, acc := range s.accounts {
+		if acc.UniqueId == id {
+			return acc, true
+		}
+	}
+	return nil, false
+}
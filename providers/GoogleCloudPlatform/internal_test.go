@@ -1,9 +1,16 @@
 package google
 
 import (
+	"context"
+	"net/http"
+	"os"
+	"reflect"
 	"testing"
+	"time"
 
 	overwatch "github.com/SeedJobs/devops-go-overwatch"
+	"github.com/SeedJobs/devops-go-overwatch/providers/default"
+	"google.golang.org/api/option"
 )
 
 func TestImplementsOverwatch(t *testing.T) {
@@ -16,3 +23,93 @@ func TestImplementsOverwatch(t *testing.T) {
 		t.Fatal("config does not implement overwatch.IamConfig")
 	}
 }
+
+func TestRoleTransformer(t *testing.T) {
+	roles, err := roleTransformer([]byte(`---
+- Name: projects/p/roles/viewer
+  Permissions:
+  - b.get
+  - a.get
+`))
+	if err != nil {
+		t.Fatal("Unable to read role:", err)
+	}
+	role := roles[0].(customRole)
+	if role.Stage != "ALPHA" || role.Permissions[0] != "a.get" || role.Type != "Role" {
+		t.Fatal("Expected the role to default to ALPHA with sorted permissions, got", role)
+	}
+	for _, invalid := range []string{
+		"- Name: viewer\n",
+		"- Name: projects/p/roles/viewer\n  Stage: LIVE\n",
+	} {
+		if _, err := roleTransformer([]byte(invalid)); err == nil {
+			t.Error("Expected an error reading", invalid)
+		}
+	}
+}
+
+func TestPlanKeys(t *testing.T) {
+	s := newScope(&cloudIamManager{keyAction: KeyActionDisable}, scopeProject, "p")
+	desired := userAccount{Email: "a@p.iam.gserviceaccount.com", Keys: []accountKey{{ID: "stored"}, {ID: "stale"}}}
+	actual := userAccount{Email: "a@p.iam.gserviceaccount.com", Keys: []accountKey{
+		{ID: "stored"},
+		{ID: "stale", Stale: true},
+		{ID: "new"},
+		{ID: "off", Disabled: true},
+	}}
+	plan := &abstract.Plan{}
+	s.planKeys(plan, desired, actual)
+	described := []string{}
+	for _, change := range plan.Changes {
+		described = append(described, change.Description)
+	}
+	expected := []string{"disable key stale", "disable key new"}
+	if !reflect.DeepEqual(described, expected) {
+		t.Fatal("Expected", expected, "got", described)
+	}
+}
+
+func TestReloadResetsClientSettings(t *testing.T) {
+	man, _ := NewManager()
+	m := man.(*cloudIamManager)
+	conf := overwatch.IamManagerConfig{Additional: map[string]interface{}{
+		"Project":           "p",
+		"Location":          os.TempDir(),
+		"Synchro":           "local",
+		"ClientOptions":     []option.ClientOption{option.WithEndpoint("localhost:1")},
+		"RESTClientOptions": []option.ClientOption{option.WithEndpoint("http://localhost:1")},
+		"RequestTimeout":    time.Second,
+		"ConflictBackoff":   time.Second,
+	}}
+	if err := m.LoadConfiguration(conf); err != nil {
+		t.Fatal("Unable to LoadConfigurations due to", err)
+	}
+	for _, key := range []string{"ClientOptions", "RESTClientOptions", "RequestTimeout", "ConflictBackoff"} {
+		delete(conf.Additional, key)
+	}
+	if err := m.LoadConfiguration(conf); err != nil {
+		t.Fatal("Unable to LoadConfigurations due to", err)
+	}
+	if m.options != nil || m.restOptions != nil || m.timeout != defaultRequestTimeout || m.conflictBackoff != defaultConflictBackoff {
+		t.Fatal("Expected the client settings of the previous configuration to be dropped, got", m.options, m.restOptions, m.timeout, m.conflictBackoff)
+	}
+}
+
+func TestConflictBackoffStopsWithContext(t *testing.T) {
+	m := &cloudIamManager{timeout: time.Second, conflictRetries: 3, conflictBackoff: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	err := m.readModifyWrite(ctx, "projects/offline-project", func(context.Context) error {
+		attempts++
+		cancel()
+		return &restError{Code: http.StatusConflict}
+	})
+	if err != context.Canceled || attempts != 1 {
+		t.Fatal("Expected the backoff to stop once the context is done, got", err, "after", attempts, "attempts")
+	}
+	for _, d := range []time.Duration{0, time.Millisecond, time.Second} {
+		if j := jitter(d); j < d/2 || j > d*3/2 {
+			t.Fatal("Expected the jitter of", d, "to be within half of it, got", j)
+		}
+	}
+}
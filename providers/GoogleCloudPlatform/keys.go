@@ -0,0 +1,138 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/SeedJobs/devops-go-overwatch/providers/default"
+)
+
+const (
+	// KeyActionAlert only reports stale and unexpected keys
+	KeyActionAlert = "alert"
+	// KeyActionDisable disables stale and unexpected keys on Resync
+	KeyActionDisable = "disable"
+	// KeyActionDelete deletes stale and unexpected keys on Resync
+	KeyActionDelete = "delete"
+)
+
+// accountKey is a user managed key of a service account. System managed
+// keys are left out as Google rotates them and never hands them out.
+type accountKey struct {
+	ID       string `json:"ID" yaml:"ID"`
+	Type     string `json:"Type" yaml:"Type"`
+	Created  string `json:"Created" yaml:"Created"`
+	Expires  string `json:"Expires" yaml:"Expires"`
+	Disabled bool   `json:"Disabled" yaml:"Disabled"`
+	// Stale flags a key older than KeyMaxAge.
+	// It is reported as drift until it is stored as well.
+	Stale bool `json:"Stale" yaml:"Stale"`
+}
+
+func (k accountKey) GetName() string {
+	return k.ID
+}
+
+func (k accountKey) String() string {
+	return k.ID + ":" + k.Created
+}
+
+func sortKeys(keys []accountKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+}
+
+// fetchKeys lists the user managed keys of the service account
+func (s *scope) fetchKeys(ctx context.Context, client *restClient, email string) ([]accountKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	var resp struct {
+		Keys []struct {
+			Name            string `json:"name"`
+			KeyType         string `json:"keyType"`
+			ValidAfterTime  string `json:"validAfterTime"`
+			ValidBeforeTime string `json:"validBeforeTime"`
+			Disabled        bool   `json:"disabled"`
+		} `json:"keys"`
+	}
+	path := "v1/projects/" + s.id + "/serviceAccounts/" + email + "/keys?keyTypes=USER_MANAGED"
+	if err := client.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	keys := []accountKey{}
+	for _, k := range resp.Keys {
+		key := accountKey{
+			ID:       k.Name[strings.LastIndex(k.Name, "/")+1:],
+			Type:     k.KeyType,
+			Created:  k.ValidAfterTime,
+			Expires:  k.ValidBeforeTime,
+			Disabled: k.Disabled,
+		}
+		if created, err := time.Parse(time.RFC3339, k.ValidAfterTime); err == nil && s.keyMaxAge > 0 {
+			key.Stale = time.Since(created) > s.keyMaxAge
+		}
+		keys = append(keys, key)
+	}
+	sortKeys(keys)
+	return keys, nil
+}
+
+// planKeys disables or deletes, depending on KeyAction, the keys
+// of the account that are stale or have not been stored.
+func (s *scope) planKeys(plan *abstract.Plan, desired, actual userAccount) {
+	if s.keyAction == KeyActionAlert {
+		return
+	}
+	stored := map[string]bool{}
+	for _, key := range desired.Keys {
+		stored[key.ID] = true
+	}
+	for _, key := range actual.Keys {
+		if stored[key.ID] && !key.Stale {
+			continue
+		}
+		path := "v1/projects/" + s.id + "/serviceAccounts/" + actual.Email + "/keys/" + key.ID
+		switch {
+		case s.keyAction == KeyActionDelete:
+			plan.Add(actual, "delete key "+key.ID, func(ctx context.Context) error {
+				return s.callKey(ctx, http.MethodDelete, path)
+			})
+		case !key.Disabled:
+			plan.Add(actual, "disable key "+key.ID, func(ctx context.Context) error {
+				return s.callKey(ctx, http.MethodPost, path+":disable")
+			})
+		}
+	}
+}
+
+func (s *scope) callKey(ctx context.Context, method, path string) error {
+	client, err := s.createRESTClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	var body interface{}
+	if method == http.MethodPost {
+		body = struct{}{}
+	}
+	return client.do(ctx, method, path, body, nil)
+}
+
+// readKeyAction checks KeyAction is one of the known actions
+func readKeyAction(additional map[string]interface{}) (string, error) {
+	action, ok := additional["KeyAction"].(string)
+	if !ok {
+		return KeyActionAlert, nil
+	}
+	switch action {
+	case KeyActionAlert, KeyActionDisable, KeyActionDelete:
+		return action, nil
+	}
+	return "", fmt.Errorf("Unknown KeyAction %s, expected %s, %s or %s", action, KeyActionAlert, KeyActionDisable, KeyActionDelete)
+}
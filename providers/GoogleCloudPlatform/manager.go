@@ -3,126 +3,431 @@ package google
 import (
 	"context"
 	"fmt"
-	"path"
+	"reflect"
+	"sync"
 	"time"
 
 	admin "cloud.google.com/go/iam/admin/apiv1"
 	overwatch "github.com/SeedJobs/devops-go-overwatch"
 	"github.com/SeedJobs/devops-go-overwatch/providers/default"
-	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
 	adminpb "google.golang.org/genproto/googleapis/iam/admin/v1"
 )
 
+const defaultRequestTimeout = time.Minute
+
+// handedConn is the type of the option that hands over a connection the caller owns
+var handedConn = reflect.TypeOf(option.WithGRPCConn(nil))
+
+// transformers reads the store of each resource type
+var transformers = map[string]func([]byte) ([]overwatch.IamResource, error){
+	"ServiceAccount": userAccountTransformer,
+	"Binding":        bindingTransformer,
+	"Role":           roleTransformer,
+}
+
+// defaultConcurrency is the number of projects, folders and organizations
+// that are scanned at once when Concurrency is not defined in the additional map.
+const defaultConcurrency = 4
+
 type cloudIamManager struct {
-	base      *abstract.Manager
-	Project   string
-	resources map[string]overwatch.IamResource
+	// mu guards all fields as the manager can be used concurrently
+	mu   sync.Mutex
+	base *abstract.Manager
+	// projects and folders are those listed in the configuration, organization
+	// is the id of the organization whose IAM policy and custom roles are managed.
+	projects     []string
+	folders      []string
+	organization string
+	// discover adds every active project under the folders and
+	// organization to the projects that are managed on each scan.
+	discover bool
+	// scopes are the organization, folders and projects being managed in that order
+	scopes []*scope
+	// concurrency limits how many scopes are scanned at once
+	concurrency int
+	// options are passed through to the IAM client when it is created,
+	// allowing a different endpoint or connection to be used.
+	options []option.ClientOption
+	client  *admin.IamClient
+	// dialed is set when the client made its own connection, which
+	// is closed on reload unlike one handed over through ClientOptions.
+	dialed bool
+	// restOptions are passed through to the REST clients, which
+	// read and update what the IAM client is unable to.
+	restOptions []option.ClientOption
+	iam         *restClient
+	crm         *restClient
+	// policy decides which drift is corrected on Resync
+	policy abstract.Policy
+	// keyMaxAge is the age after which a key is flagged as stale, zero never flags keys
+	keyMaxAge time.Duration
+	// keyAction is what Resync does to stale and unexpected keys
+	keyAction string
+	// unmanaged is what Resync does to accounts that are not stored
+	unmanaged string
+	// unmanagedRoles is what Resync does to custom roles that are not stored,
+	// empty leaves them alone.
+	unmanagedRoles string
+	// timeout bounds each call made to GCP so that an unreachable
+	// provider is reported rather than retried forever.
+	timeout time.Duration
+	// conflictRetries bounds how many times a policy write is retried
+	// after conflicting with someone else changing the policy.
+	conflictRetries int
+	// conflictBackoff is roughly how long is waited before retrying a conflicting write
+	conflictBackoff time.Duration
 }
 
 func NewManager() (overwatch.IamPolicyManager, error) {
 	return &cloudIamManager{
 		// This will enforce that any operation that depends on expire to happen
 		// straight away as no data would have been loaded
-		base:      abstract.DefaultManager(),
-		resources: map[string]overwatch.IamResource{},
+		base:            abstract.DefaultManager(),
+		timeout:         defaultRequestTimeout,
+		concurrency:     defaultConcurrency,
+		conflictRetries: defaultConflictRetries,
+		conflictBackoff: defaultConflictBackoff,
+		unmanaged:       UnmanagedDisable,
+		keyAction:       KeyActionAlert,
 	}, nil
 }
 
+// LoadConfiguration reads which projects, folders and organization are managed from
+// Project, Projects, Folders and Organization. Setting DiscoverProjects manages every
+// active project under the folders and organization as well, which are found on each scan.
 func (m *cloudIamManager) LoadConfiguration(conf overwatch.IamManagerConfig) error {
-	project, ok := conf.Additional["Project"].(string)
-	if !ok {
-		return fmt.Errorf("Unable to convert Project from Additional to a string")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.projects, m.folders = nil, nil
+	m.options, m.restOptions = nil, nil
+	m.timeout = defaultRequestTimeout
+	if project, exist := conf.Additional["Project"]; exist {
+		p, ok := project.(string)
+		if !ok {
+			return fmt.Errorf("Unable to convert Project from Additional to a string")
+		}
+		m.projects = append(m.projects, p)
+	}
+	if projects, exist := conf.Additional["Projects"]; exist {
+		p, ok := projects.([]string)
+		if !ok {
+			return fmt.Errorf("Unable to convert Projects from Additional to a []string")
+		}
+		m.projects = append(m.projects, p...)
+	}
+	if folders, exist := conf.Additional["Folders"]; exist {
+		f, ok := folders.([]string)
+		if !ok {
+			return fmt.Errorf("Unable to convert Folders from Additional to a []string")
+		}
+		m.folders = f
+	}
+	m.organization, _ = conf.Additional["Organization"].(string)
+	m.discover, _ = conf.Additional["DiscoverProjects"].(bool)
+	switch {
+	case m.discover && len(m.folders) == 0 && m.organization == "":
+		return fmt.Errorf("DiscoverProjects requires Folders or an Organization to discover projects in")
+	case !m.discover && len(m.projects) == 0:
+		return fmt.Errorf("Unable to find Project or Projects in Additional")
+	}
+	m.concurrency = defaultConcurrency
+	if concurrency, exist := conf.Additional["Concurrency"].(int); exist && concurrency > 0 {
+		m.concurrency = concurrency
+	}
+	if opts, ok := conf.Additional["ClientOptions"].([]option.ClientOption); ok {
+		m.options = opts
+	}
+	if opts, ok := conf.Additional["RESTClientOptions"].([]option.ClientOption); ok {
+		m.restOptions = opts
+	}
+	if timeout, ok := conf.Additional["RequestTimeout"].(time.Duration); ok {
+		m.timeout = timeout
+	}
+	m.conflictRetries = defaultConflictRetries
+	if retries, ok := conf.Additional["ConflictRetries"].(int); ok && retries >= 0 {
+		m.conflictRetries = retries
+	}
+	m.conflictBackoff = defaultConflictBackoff
+	if backoff, ok := conf.Additional["ConflictBackoff"].(time.Duration); ok && backoff >= 0 {
+		m.conflictBackoff = backoff
+	}
+	policy, err := abstract.ReadPolicy(conf.Additional)
+	if err != nil {
+		return err
+	}
+	m.policy = policy
+	if m.unmanaged, err = readUnmanaged(conf.Additional, "Unmanaged", UnmanagedDisable); err != nil {
+		return err
+	}
+	if m.unmanagedRoles, err = readUnmanaged(conf.Additional, "UnmanagedRoles", ""); err != nil {
+		return err
+	}
+	m.keyMaxAge, _ = conf.Additional["KeyMaxAge"].(time.Duration)
+	if m.keyAction, err = readKeyAction(conf.Additional); err != nil {
+		return err
+	}
+	// A connection the manager dialed is closed rather than leaked, one handed
+	// over through ClientOptions belongs to the caller and is left open.
+	if m.client != nil && m.dialed {
+		m.client.Close()
 	}
-	m.Project = project
+	m.client, m.dialed = nil, false
+	m.iam = nil
+	m.crm = nil
 	if err := m.base.Readconfig(conf); err != nil {
 		return err
 	}
-	return m.loadFromDisc()
+	m.scopes = nil
+	return m.setScopes(m.projects)
 }
 
 func (m *cloudIamManager) Resources() []overwatch.IamResource {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.update()
 	res := []overwatch.IamResource{}
-	for _, val := range m.resources {
-		res = append(res, val)
+	for _, s := range m.scopes {
+		for _, items := range s.resources {
+			for _, val := range items {
+				res = append(res, val)
+			}
+		}
 	}
 	return res
 }
 
 func (m *cloudIamManager) ListModifiedResources() ([]overwatch.IamResource, error) {
-	if m.base.Storer == nil {
-		return nil, fmt.Errorf("Unable to load local resources due to misconfigured manager")
-	}
-	if err := m.update(); err != nil {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.prepare(); err != nil {
 		return nil, err
 	}
-	client, err := m.createClient()
+	modified := make([][]overwatch.IamResource, len(m.scopes))
+	err := abstract.ForEach(context.Background(), len(m.scopes), m.concurrency, func(ctx context.Context, i int) error {
+		current, err := m.scopes[i].fetch()
+		if err != nil {
+			return err
+		}
+		modified[i] = m.scopes[i].drift(current)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer client.Close()
-	req := &adminpb.ListServiceAccountsRequest{
-		Name: "projects/" + m.Project,
+	res := []overwatch.IamResource{}
+	for _, items := range modified {
+		res = append(res, items...)
 	}
-	it := client.ListServiceAccounts(context.Background(), req)
-	modifiedResources := []overwatch.IamResource{}
-	// Checking for modified Service accounts
-	for {
-		resp, err := it.Next()
-		if err == iterator.Done {
-			break
+	return res, nil
+}
+
+// Resync applies the stored service accounts, custom roles and IAM bindings to each
+// project, folder and organization and returns the resources that were changed. Only
+// the drift of resources the Enforcement policy enforces is corrected, which is nothing
+// unless configured otherwise. The organization and folders are resynced before any of
+// the projects, so that projects are able to refer to the custom roles of the organization.
+// Scopes without anything stored, such as discovered projects, are never enforced.
+func (m *cloudIamManager) Resync() ([]overwatch.IamResource, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.prepare(); err != nil {
+		return nil, err
+	}
+	parents, projects := []*scope{}, []*scope{}
+	for _, s := range m.scopes {
+		if s.kind == scopeProject {
+			projects = append(projects, s)
+		} else {
+			parents = append(parents, s)
 		}
+	}
+	applied := []abstract.Change{}
+	for _, scopes := range [][]*scope{parents, projects} {
+		changes := make([][]abstract.Change, len(scopes))
+		err := abstract.ForEach(context.Background(), len(scopes), m.concurrency, func(ctx context.Context, i int) error {
+			current, err := scopes[i].fetch()
+			if err != nil {
+				return err
+			}
+			plan := &abstract.Plan{}
+			scopes[i].plan(plan, current)
+			policy := m.policy
+			if len(scopes[i].resources) == 0 {
+				// Nothing is stored for the scope, such as a newly discovered
+				// project, so its drift is only reported.
+				policy = abstract.Policy{Default: abstract.PolicyAlertOnly}
+			}
+			changes[i], _, err = plan.Apply(ctx, policy)
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
-		serviceAccount := userAccount{
-			Name:  resp.GetDisplayName(),
-			Email: resp.GetEmail(),
-			Type:  "ServiceAccount",
+		for _, c := range changes {
+			applied = append(applied, c...)
 		}
-		stored, exist := m.resources[serviceAccount.Email]
-		switch {
-		case !exist:
-			modifiedResources = append(modifiedResources, serviceAccount)
-		default:
-			if stored != serviceAccount {
-				modifiedResources = append(modifiedResources, serviceAccount)
-			}
+	}
+	return changedResources(applied), nil
+}
+
+// prepare brings the store and discovered projects up to date and creates
+// the clients up front, as the scopes are scanned concurrently.
+func (m *cloudIamManager) prepare() error {
+	if m.base.Storer == nil {
+		return overwatch.ErrMisconfigured
+	}
+	if err := m.update(); err != nil {
+		return err
+	}
+	if _, err := m.createClient(); err != nil {
+		return err
+	}
+	if _, err := m.createRESTClient(); err != nil {
+		return err
+	}
+	if _, err := m.createResourceManagerClient(); err != nil {
+		return err
+	}
+	if !m.discover {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	projects := append([]string{}, m.projects...)
+	parents := []string{}
+	if m.organization != "" {
+		parents = append(parents, "organizations/"+m.organization)
+	}
+	for _, f := range m.folders {
+		parents = append(parents, "folders/"+f)
+	}
+	for _, parent := range parents {
+		found, err := m.discoverProjects(ctx, parent)
+		if err != nil {
+			return err
 		}
+		projects = append(projects, found...)
 	}
-	return modifiedResources, nil
+	return m.setScopes(projects)
 }
 
-func (m *cloudIamManager) Resync() ([]overwatch.IamResource, error) {
-	return nil, overwatch.ErrNotImplemented
+// setScopes manages the organization, folders and projects, scopes that
+// are already managed are kept while new ones are loaded from the store.
+func (m *cloudIamManager) setScopes(projects []string) error {
+	existing := map[string]*scope{}
+	for _, s := range m.scopes {
+		existing[s.parent()] = s
+	}
+	wanted := []*scope{}
+	if m.organization != "" {
+		wanted = append(wanted, newScope(m, scopeOrganization, m.organization))
+	}
+	for _, f := range m.folders {
+		wanted = append(wanted, newScope(m, scopeFolder, f))
+	}
+	for _, p := range projects {
+		wanted = append(wanted, newScope(m, scopeProject, p))
+	}
+	scopes := []*scope{}
+	seen := map[string]bool{}
+	for _, s := range wanted {
+		if seen[s.parent()] {
+			continue
+		}
+		seen[s.parent()] = true
+		if current, exist := existing[s.parent()]; exist {
+			scopes = append(scopes, current)
+			continue
+		}
+		if err := s.loadFromDisc(); err != nil {
+			return err
+		}
+		scopes = append(scopes, s)
+	}
+	m.scopes = scopes
+	return nil
 }
 
+// createClient lazily creates the IAM client and keeps it
+// for the lifetime of the manager.
 func (m *cloudIamManager) createClient() (*admin.IamClient, error) {
-	return admin.NewIamClient(context.Background())
+	if m.client != nil {
+		return m.client, nil
+	}
+	client, err := admin.NewIamClient(context.Background(), m.options...)
+	if err != nil {
+		return nil, err
+	}
+	m.client, m.dialed = client, true
+	for _, opt := range m.options {
+		if reflect.TypeOf(opt) == handedConn {
+			m.dialed = false
+		}
+	}
+	return client, nil
 }
 
-func (m *cloudIamManager) loadFromDisc() error {
-	// Start from a fresh slate and ensure all previous resources are removed
-	for item, _ := range m.resources {
-		delete(m.resources, item)
+// iamClient returns the raw gRPC client of the IAM service, the generated
+// IamClient does not include the role calls or those for IAM policies.
+func (m *cloudIamManager) iamClient() (adminpb.IAMClient, error) {
+	client, err := m.createClient()
+	if err != nil {
+		return nil, err
 	}
-	dir := path.Join(m.base.Storer.GetPath(), "GoogleCloudPlatform/Project", m.Project, "ServiceAccounts")
-	serviceaccounts, err := abstract.ReadFiles(dir, userAccountTransformer)
+	return adminpb.NewIAMClient(client.Connection()), nil
+}
+
+// callIAM calls the IAM service within the request timeout
+func (m *cloudIamManager) callIAM(ctx context.Context, call func(context.Context, adminpb.IAMClient) error) error {
+	client, err := m.iamClient()
 	if err != nil {
 		return err
 	}
-	for _, resource := range serviceaccounts {
-		m.resources[resource.GetName()] = resource
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	return call(ctx, client)
+}
+
+// createRESTClient lazily creates the client for the IAM REST API
+func (m *cloudIamManager) createRESTClient() (*restClient, error) {
+	if m.iam != nil {
+		return m.iam, nil
+	}
+	client, err := newRESTClient(iamEndpoint, m.restOptions)
+	if err != nil {
+		return nil, err
+	}
+	m.iam = client
+	return client, nil
+}
+
+// createResourceManagerClient lazily creates the client for the Cloud Resource Manager REST API
+func (m *cloudIamManager) createResourceManagerClient() (*restClient, error) {
+	if m.crm != nil {
+		return m.crm, nil
+	}
+	client, err := newRESTClient(resourceManagerEndpoint, m.restOptions)
+	if err != nil {
+		return nil, err
+	}
+	m.crm = client
+	return client, nil
+}
+
+func (m *cloudIamManager) loadFromDisc() error {
+	for _, s := range m.scopes {
+		if err := s.loadFromDisc(); err != nil {
+			return err
+		}
 	}
-	// Load Members from disc
-	// Load Roles from disc
 	return nil
 }
 
 func (m *cloudIamManager) update() error {
 	if time.Now().After(m.base.Expire) {
 		if m.base.Storer == nil {
-			return fmt.Errorf("Storer is undefined")
+			return overwatch.ErrMisconfigured
 		}
 		updated, err := m.base.Storer.Synced()
 		switch {
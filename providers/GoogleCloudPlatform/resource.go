@@ -6,9 +6,17 @@ import (
 )
 
 type userAccount struct {
-	Name  string `json:"Name" yaml:"Name"`
-	Email string `json:"Email" yaml:"Email"`
-	Type  string `json:"Type" yaml:"Type"`
+	Name        string `json:"Name" yaml:"Name"`
+	Email       string `json:"Email" yaml:"Email"`
+	Type        string `json:"Type" yaml:"Type"`
+	Description string `json:"Description" yaml:"Description"`
+	Disabled    bool   `json:"Disabled" yaml:"Disabled"`
+	// Keys are the user managed keys of the account
+	Keys []accountKey `json:"Keys" yaml:"Keys"`
+	// Bindings are the roles granted on the account itself,
+	// which decide who is able to act as or impersonate it.
+	// They are left nil when not stored so that the policy is kept.
+	Bindings []accountBinding `json:"Bindings" yaml:"Bindings"`
 }
 
 type userCollection []userAccount
@@ -22,7 +30,22 @@ func (r userAccount) GetType() string {
 }
 
 func (r userAccount) AppliedConfig() []overwatch.IamConfig {
-	return nil
+	configs := []overwatch.IamConfig{}
+	for _, key := range r.Keys {
+		configs = append(configs, key)
+	}
+	for _, b := range r.Bindings {
+		configs = append(configs, b)
+	}
+	return configs
+}
+
+// fillUnset returns the account with the bindings that were not stored taken from actual
+func (r userAccount) fillUnset(actual userAccount) userAccount {
+	if r.Bindings == nil {
+		r.Bindings = actual.Bindings
+	}
+	return r
 }
 
 func userAccountTransformer(buff []byte) ([]overwatch.IamResource, error) {
@@ -33,6 +56,21 @@ func userAccountTransformer(buff []byte) ([]overwatch.IamResource, error) {
 	collection := []overwatch.IamResource{}
 	for _, obj := range items {
 		obj.Type = "ServiceAccount"
+		if obj.Keys == nil {
+			obj.Keys = []accountKey{}
+		}
+		sortKeys(obj.Keys)
+		if obj.Bindings != nil {
+			// A role granted to no one is the same as the role not being granted
+			bindings := []accountBinding{}
+			for _, b := range obj.Bindings {
+				if len(b.Members) != 0 {
+					bindings = append(bindings, b)
+				}
+			}
+			sortAccountBindings(bindings)
+			obj.Bindings = bindings
+		}
 		collection = append(collection, obj)
 	}
 	return collection, nil
@@ -0,0 +1,90 @@
+package google
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+const (
+	iamEndpoint             = "https://iam.googleapis.com/"
+	resourceManagerEndpoint = "https://cloudresourcemanager.googleapis.com/"
+	cloudPlatformScope      = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+// restClient calls the Google REST APIs directly for what the
+// vendored gRPC clients predate, such as service account descriptions.
+type restClient struct {
+	client   *http.Client
+	endpoint string
+}
+
+// restError is the error body returned by the Google REST APIs
+type restError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+func (e *restError) Error() string {
+	return fmt.Sprintf("%s (%d): %s", e.Status, e.Code, e.Message)
+}
+
+// newRESTClient creates a client for the API at endpoint, the options
+// are applied afterwards so they are able to replace the endpoint.
+func newRESTClient(endpoint string, opts []option.ClientOption) (*restClient, error) {
+	opts = append([]option.ClientOption{
+		option.WithEndpoint(endpoint),
+		option.WithScopes(cloudPlatformScope),
+	}, opts...)
+	client, endpoint, err := htransport.NewClient(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &restClient{client: client, endpoint: strings.TrimSuffix(endpoint, "/")}, nil
+}
+
+// do sends body as JSON to the path and decodes the response into out,
+// either of which can be nil.
+func (c *restClient) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var payload io.Reader
+	if body != nil {
+		buff, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(buff)
+	}
+	req, err := http.NewRequest(method, c.endpoint+"/"+path, payload)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		var reply struct {
+			Error restError `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil || reply.Error.Code == 0 {
+			reply.Error = restError{Code: resp.StatusCode, Status: resp.Status}
+		}
+		return &reply.Error
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
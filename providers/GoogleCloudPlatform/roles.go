@@ -0,0 +1,257 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	overwatch "github.com/SeedJobs/devops-go-overwatch"
+	"github.com/SeedJobs/devops-go-overwatch/providers/default"
+	adminpb "google.golang.org/genproto/googleapis/iam/admin/v1"
+	"google.golang.org/genproto/protobuf/field_mask"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// customRole is a role defined inside a project or organization, named by
+// its resource name such as projects/<project>/roles/<id>.
+type customRole struct {
+	Name        string   `json:"Name" yaml:"Name"`
+	Title       string   `json:"Title" yaml:"Title"`
+	Description string   `json:"Description" yaml:"Description"`
+	Stage       string   `json:"Stage" yaml:"Stage"`
+	Permissions []string `json:"Permissions" yaml:"Permissions"`
+	Type        string   `json:"Type" yaml:"Type"`
+}
+
+func (r customRole) GetName() string {
+	return r.Name
+}
+
+func (r customRole) GetType() string {
+	return r.Type
+}
+
+func (r customRole) AppliedConfig() []overwatch.IamConfig {
+	configs := []overwatch.IamConfig{}
+	for _, permission := range r.Permissions {
+		configs = append(configs, config{Name: permission})
+	}
+	return configs
+}
+
+// splitRole returns the parent and id of the role name
+func splitRole(name string) (string, string) {
+	i := strings.LastIndex(name, "/roles/")
+	if i == -1 {
+		return "", name
+	}
+	return name[:i], name[i+len("/roles/"):]
+}
+
+// roleTransformer reads custom roles, a role without a stage is
+// in the ALPHA stage the same as when it is created without one.
+func roleTransformer(buff []byte) ([]overwatch.IamResource, error) {
+	var items []customRole
+	if err := yaml.Unmarshal(buff, &items); err != nil {
+		return nil, err
+	}
+	collection := []overwatch.IamResource{}
+	for _, obj := range items {
+		obj.Type = "Role"
+		if parent, _ := splitRole(obj.Name); parent == "" {
+			return nil, fmt.Errorf("Role %s must be named projects/<project>/roles/<id> or organizations/<id>/roles/<id>", obj.Name)
+		}
+		if obj.Stage == "" {
+			obj.Stage = adminpb.Role_ALPHA.String()
+		}
+		if _, exist := adminpb.Role_RoleLaunchStage_value[obj.Stage]; !exist {
+			return nil, fmt.Errorf("Unknown stage %s of role %s", obj.Stage, obj.Name)
+		}
+		if obj.Permissions == nil {
+			obj.Permissions = []string{}
+		}
+		sort.Strings(obj.Permissions)
+		collection = append(collection, obj)
+	}
+	return collection, nil
+}
+
+func fromRole(role *adminpb.Role) customRole {
+	permissions := append([]string{}, role.GetIncludedPermissions()...)
+	sort.Strings(permissions)
+	return customRole{
+		Name:        role.GetName(),
+		Title:       role.GetTitle(),
+		Description: role.GetDescription(),
+		Stage:       role.GetStage().String(),
+		Permissions: permissions,
+		Type:        "Role",
+	}
+}
+
+func (r customRole) proto() *adminpb.Role {
+	return &adminpb.Role{
+		Title:               r.Title,
+		Description:         r.Description,
+		IncludedPermissions: r.Permissions,
+		Stage:               adminpb.Role_RoleLaunchStage(adminpb.Role_RoleLaunchStage_value[r.Stage]),
+	}
+}
+
+// fetchRoles lists the custom roles of the project or organization, along with
+// the roles that have been deleted but are able to be undeleted, keyed by their name.
+func (s *scope) fetchRoles() ([]customRole, map[string]customRole, error) {
+	client, err := s.iamClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	roles, deleted := []customRole{}, map[string]customRole{}
+	req := &adminpb.ListRolesRequest{
+		Parent:      s.parent(),
+		PageSize:    100,
+		View:        adminpb.RoleView_FULL,
+		ShowDeleted: true,
+	}
+	for {
+		resp, err := client.ListRoles(ctx, req)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, role := range resp.GetRoles() {
+			if role.GetDeleted() {
+				deleted[role.GetName()] = fromRole(role)
+				continue
+			}
+			roles = append(roles, fromRole(role))
+		}
+		if resp.GetNextPageToken() == "" {
+			return roles, deleted, nil
+		}
+		req.PageToken = resp.GetNextPageToken()
+	}
+}
+
+// roleDrift returns the custom roles that differ from the store followed by the
+// stored version of those that no longer exist. Roles that are not stored but
+// have already been disabled are left out, as that is what Resync does to them.
+func (s *scope) roleDrift(roles []customRole) []overwatch.IamResource {
+	modified := []overwatch.IamResource{}
+	seen := map[string]bool{}
+	for _, role := range roles {
+		seen[role.Name] = true
+		stored, exist := s.resources["Role"][role.Name]
+		switch {
+		case !exist && role.Stage == adminpb.Role_DISABLED.String():
+		case !exist, !reflect.DeepEqual(stored, role):
+			modified = append(modified, role)
+		}
+	}
+	for _, name := range s.storedNames("Role") {
+		if !seen[name] {
+			modified = append(modified, s.resources["Role"][name])
+		}
+	}
+	return modified
+}
+
+// planRoles adds the changes that bring the custom roles back to their stored
+// definition. Missing roles are undeleted or created. Roles that are not stored
+// are only moved to the DISABLED stage, or deleted, when UnmanagedRoles asks for
+// it and at least one role is stored for the scope.
+func (s *scope) planRoles(plan *abstract.Plan, roles []customRole, deleted map[string]customRole) {
+	current := map[string]customRole{}
+	for _, role := range roles {
+		current[role.Name] = role
+	}
+	for _, name := range s.storedNames("Role") {
+		desired := s.resources["Role"][name].(customRole)
+		if actual, exist := current[name]; exist {
+			s.planRoleUpdate(plan, desired, actual)
+			continue
+		}
+		if actual, exist := deleted[name]; exist {
+			plan.Add(desired, "undelete", func(ctx context.Context) error {
+				return s.callIAM(ctx, func(ctx context.Context, client adminpb.IAMClient) error {
+					_, err := client.UndeleteRole(ctx, &adminpb.UndeleteRoleRequest{Name: desired.Name})
+					return err
+				})
+			})
+			s.planRoleUpdate(plan, desired, actual)
+			continue
+		}
+		s.planRoleCreate(plan, desired)
+	}
+	if s.unmanagedRoles == "" || len(s.resources["Role"]) == 0 {
+		return
+	}
+	for _, actual := range roles {
+		if _, stored := s.resources["Role"][actual.Name]; stored {
+			continue
+		}
+		switch {
+		case s.unmanagedRoles == UnmanagedDelete:
+			actual := actual
+			plan.Add(actual, "delete", func(ctx context.Context) error {
+				return s.callIAM(ctx, func(ctx context.Context, client adminpb.IAMClient) error {
+					_, err := client.DeleteRole(ctx, &adminpb.DeleteRoleRequest{Name: actual.Name})
+					return err
+				})
+			})
+		case actual.Stage != adminpb.Role_DISABLED.String():
+			disabled := actual
+			disabled.Stage = adminpb.Role_DISABLED.String()
+			s.planRoleUpdate(plan, disabled, actual)
+		}
+	}
+}
+
+func (s *scope) planRoleCreate(plan *abstract.Plan, desired customRole) {
+	plan.Add(desired, "create", func(ctx context.Context) error {
+		parent, id := splitRole(desired.Name)
+		if parent != s.parent() {
+			return fmt.Errorf("Unable to create %s outside of %s", desired.Name, s.parent())
+		}
+		return s.callIAM(ctx, func(ctx context.Context, client adminpb.IAMClient) error {
+			_, err := client.CreateRole(ctx, &adminpb.CreateRoleRequest{
+				Parent: parent,
+				RoleId: id,
+				Role:   desired.proto(),
+			})
+			return err
+		})
+	})
+}
+
+// planRoleUpdate changes the fields of actual that differ from desired
+func (s *scope) planRoleUpdate(plan *abstract.Plan, desired, actual customRole) {
+	paths := []string{}
+	if desired.Title != actual.Title {
+		paths = append(paths, "title")
+	}
+	if desired.Description != actual.Description {
+		paths = append(paths, "description")
+	}
+	if desired.Stage != actual.Stage {
+		paths = append(paths, "stage")
+	}
+	if !reflect.DeepEqual(desired.Permissions, actual.Permissions) {
+		paths = append(paths, "included_permissions")
+	}
+	if len(paths) == 0 {
+		return
+	}
+	plan.Add(desired, "update "+strings.Join(paths, " and "), func(ctx context.Context) error {
+		return s.callIAM(ctx, func(ctx context.Context, client adminpb.IAMClient) error {
+			_, err := client.UpdateRole(ctx, &adminpb.UpdateRoleRequest{
+				Name:       desired.Name,
+				Role:       desired.proto(),
+				UpdateMask: &field_mask.FieldMask{Paths: paths},
+			})
+			return err
+		})
+	})
+}
@@ -0,0 +1,208 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	overwatch "github.com/SeedJobs/devops-go-overwatch"
+	"github.com/SeedJobs/devops-go-overwatch/providers/default"
+)
+
+const (
+	scopeProject      = "Project"
+	scopeFolder       = "Folder"
+	scopeOrganization = "Organization"
+)
+
+// scopeKinds are the resource types managed in each kind of scope, service
+// accounts only exist in projects and folders are unable to hold custom roles.
+var scopeKinds = map[string][]string{
+	scopeProject:      {"ServiceAccount", "Role", "Binding"},
+	scopeFolder:       {"Binding"},
+	scopeOrganization: {"Role", "Binding"},
+}
+
+// scope is a project, folder or organization along with the
+// resources stored for it under GoogleCloudPlatform/<kind>/<id>.
+type scope struct {
+	*cloudIamManager
+	kind string
+	id   string
+	// resources are keyed by their type and then name
+	resources map[string]map[string]overwatch.IamResource
+}
+
+// state is what is currently defined within a scope
+type state struct {
+	accounts []userAccount
+	roles    []customRole
+	// deleted are the custom roles that are able to be undeleted
+	deleted  map[string]customRole
+	bindings []binding
+}
+
+func newScope(m *cloudIamManager, kind, id string) *scope {
+	return &scope{
+		cloudIamManager: m,
+		kind:            kind,
+		id:              id,
+		resources:       map[string]map[string]overwatch.IamResource{},
+	}
+}
+
+// parent is the resource name of the scope, such as projects/<id>
+func (s *scope) parent() string {
+	return strings.ToLower(s.kind) + "s/" + s.id
+}
+
+// policyPath is the Resource Manager path of the scope, folders are only part of v2
+func (s *scope) policyPath() string {
+	if s.kind == scopeFolder {
+		return "v2/" + s.parent()
+	}
+	return "v1/" + s.parent()
+}
+
+func (s *scope) manages(kind string) bool {
+	for _, k := range scopeKinds[s.kind] {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// storeDir is the directory inside the store that holds the resources of the type
+func (s *scope) storeDir(kind string) string {
+	return path.Join(s.base.Storer.GetPath(), "GoogleCloudPlatform", s.kind, s.id, kind+"s")
+}
+
+func (s *scope) loadFromDisc() error {
+	resources := map[string]map[string]overwatch.IamResource{}
+	for _, kind := range scopeKinds[s.kind] {
+		loaded, err := abstract.ReadFiles(s.storeDir(kind), transformers[kind])
+		if err != nil {
+			return err
+		}
+		for _, obj := range loaded {
+			switch res := obj.(type) {
+			case binding:
+				res.Resource = s.parent()
+				obj = res
+			case customRole:
+				if parent, _ := splitRole(res.Name); parent != s.parent() {
+					return fmt.Errorf("Role %s is stored outside of %s", res.Name, s.parent())
+				}
+			}
+			if _, ok := resources[obj.GetType()]; !ok {
+				resources[obj.GetType()] = map[string]overwatch.IamResource{}
+			}
+			resources[obj.GetType()][obj.GetName()] = obj
+		}
+	}
+	s.resources = resources
+	return nil
+}
+
+// fetch reads everything the scope manages from GCP
+func (s *scope) fetch() (*state, error) {
+	current := &state{}
+	var err error
+	if s.manages("ServiceAccount") {
+		if current.accounts, err = s.fetchServiceAccounts(); err != nil {
+			return nil, err
+		}
+	}
+	if s.manages("Role") {
+		if current.roles, current.deleted, err = s.fetchRoles(); err != nil {
+			return nil, err
+		}
+	}
+	if current.bindings, err = s.fetchBindings(); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+func (s *scope) drift(current *state) []overwatch.IamResource {
+	modified := append(s.accountDrift(current.accounts), s.roleDrift(current.roles)...)
+	return append(modified, s.bindingDrift(current.bindings)...)
+}
+
+// plan adds the changes that bring the scope in line with the store, bindings
+// are changed last so that they are able to refer to new accounts and roles.
+func (s *scope) plan(plan *abstract.Plan, current *state) {
+	s.planAccounts(plan, current.accounts)
+	s.planRoles(plan, current.roles, current.deleted)
+	s.planBindings(plan, current.bindings)
+}
+
+// discoverProjects lists the active projects under the folder or organization,
+// including those inside of any folders nested within it.
+func (m *cloudIamManager) discoverProjects(ctx context.Context, parent string) ([]string, error) {
+	client, err := m.createResourceManagerClient()
+	if err != nil {
+		return nil, err
+	}
+	kind, id := path.Split(parent)
+	filter := "parent.type:" + strings.TrimSuffix(kind, "s/") + " parent.id:" + id
+	projects := []string{}
+	token := ""
+	for {
+		var resp struct {
+			Projects []struct {
+				ProjectID      string `json:"projectId"`
+				LifecycleState string `json:"lifecycleState"`
+			} `json:"projects"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		query := url.Values{"filter": {filter}}
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+		if err := client.do(ctx, http.MethodGet, "v1/projects?"+query.Encode(), nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, p := range resp.Projects {
+			if p.LifecycleState == "ACTIVE" {
+				projects = append(projects, p.ProjectID)
+			}
+		}
+		if token = resp.NextPageToken; token == "" {
+			break
+		}
+	}
+	for {
+		var resp struct {
+			Folders []struct {
+				Name           string `json:"name"`
+				LifecycleState string `json:"lifecycleState"`
+			} `json:"folders"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		query := url.Values{"parent": {parent}}
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+		if err := client.do(ctx, http.MethodGet, "v2/folders?"+query.Encode(), nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, f := range resp.Folders {
+			if f.LifecycleState != "ACTIVE" {
+				continue
+			}
+			nested, err := m.discoverProjects(ctx, f.Name)
+			if err != nil {
+				return nil, err
+			}
+			projects = append(projects, nested...)
+		}
+		if token = resp.NextPageToken; token == "" {
+			return projects, nil
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	overwatch "github.com/SeedJobs/devops-go-overwatch"
+	"github.com/SeedJobs/devops-go-overwatch/providers/default"
+	adminpb "google.golang.org/genproto/googleapis/iam/admin/v1"
+)
+
+const (
+	// UnmanagedDisable disables accounts, and custom roles when set as
+	// UnmanagedRoles, that are not stored on Resync
+	UnmanagedDisable = "disable"
+	// UnmanagedDelete deletes accounts, and custom roles when set as
+	// UnmanagedRoles, that are not stored on Resync
+	UnmanagedDelete = "delete"
+)
+
+// readUnmanaged checks the action set as key is one of the Unmanaged actions
+func readUnmanaged(additional map[string]interface{}, key, fallback string) (string, error) {
+	action, ok := additional[key].(string)
+	if !ok {
+		return fallback, nil
+	}
+	if action != UnmanagedDisable && action != UnmanagedDelete {
+		return "", fmt.Errorf("Unknown %s action %s, expected %s or %s", key, action, UnmanagedDisable, UnmanagedDelete)
+	}
+	return action, nil
+}
+
+// restAccount is the IAM v1 REST representation of a service account,
+// the vendored gRPC client has no description or disabled state.
+type restAccount struct {
+	Email       string `json:"email"`
+	DisplayName string `json:"displayName"`
+	Description string `json:"description"`
+	Disabled    bool   `json:"disabled"`
+}
+
+// fetchServiceAccounts lists every service account of the project along with
+// their keys and the roles granted on each of them, each request has its own timeout.
+func (s *scope) fetchServiceAccounts() ([]userAccount, error) {
+	client, err := s.createRESTClient()
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	accounts := []userAccount{}
+	query := url.Values{"pageSize": {"100"}}
+	for {
+		var resp struct {
+			Accounts      []restAccount `json:"accounts"`
+			NextPageToken string        `json:"nextPageToken"`
+		}
+		path := "v1/projects/" + s.id + "/serviceAccounts?" + query.Encode()
+		pageCtx, cancel := context.WithTimeout(ctx, s.timeout)
+		err := client.do(pageCtx, http.MethodGet, path, nil, &resp)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		for _, acc := range resp.Accounts {
+			keys, err := s.fetchKeys(ctx, client, acc.Email)
+			if err != nil {
+				return nil, err
+			}
+			bindings, err := s.fetchAccountPolicy(ctx, client, acc.Email)
+			if err != nil {
+				return nil, err
+			}
+			accounts = append(accounts, userAccount{
+				Name:        acc.DisplayName,
+				Email:       acc.Email,
+				Type:        "ServiceAccount",
+				Description: acc.Description,
+				Disabled:    acc.Disabled,
+				Keys:        keys,
+				Bindings:    bindings,
+			})
+		}
+		if resp.NextPageToken == "" {
+			return accounts, nil
+		}
+		query.Set("pageToken", resp.NextPageToken)
+	}
+}
+
+// accountDrift returns the accounts that differ from the store followed by the stored
+// version of those that have been removed. Accounts that are not stored but
+// have already been disabled are left out, as that is what Resync does to them.
+func (s *scope) accountDrift(accounts []userAccount) []overwatch.IamResource {
+	modified := []overwatch.IamResource{}
+	seen := map[string]bool{}
+	for _, account := range accounts {
+		seen[account.Email] = true
+		stored, exist := s.resources["ServiceAccount"][account.Email]
+		switch {
+		case !exist && account.Disabled:
+		case !exist, !reflect.DeepEqual(stored.(userAccount).fillUnset(account), account):
+			modified = append(modified, account)
+		}
+	}
+	for _, email := range s.storedNames("ServiceAccount") {
+		if !seen[email] {
+			modified = append(modified, s.resources["ServiceAccount"][email])
+		}
+	}
+	return modified
+}
+
+// storedNames returns the names of the stored resources of the type in order
+func (s *scope) storedNames(kind string) []string {
+	names := []string{}
+	for name := range s.resources[kind] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// planAccounts adds the changes that bring the service accounts of the
+// project in line with the store. Missing accounts are created, stored
+// accounts are updated and accounts that are not stored are disabled,
+// or deleted when Unmanaged is set to delete. Nothing is disabled while no
+// accounts are stored for the project, rather than disabling every account.
+// Keys are handled by planKeys and the roles granted on each account by planAccountPolicy.
+func (s *scope) planAccounts(plan *abstract.Plan, accounts []userAccount) {
+	current := map[string]userAccount{}
+	for _, account := range accounts {
+		current[account.Email] = account
+	}
+	for _, email := range s.storedNames("ServiceAccount") {
+		desired := s.resources["ServiceAccount"][email].(userAccount)
+		actual, exist := current[email]
+		if !exist {
+			s.planCreate(plan, desired)
+			continue
+		}
+		s.planUpdate(plan, desired, actual)
+		s.planKeys(plan, desired, actual)
+		s.planAccountPolicy(plan, desired, actual)
+	}
+	if len(s.resources["ServiceAccount"]) == 0 {
+		return
+	}
+	for _, actual := range accounts {
+		if _, stored := s.resources["ServiceAccount"][actual.Email]; stored {
+			continue
+		}
+		switch {
+		case s.unmanaged == UnmanagedDelete:
+			s.planDelete(plan, actual)
+		case !actual.Disabled:
+			s.planDisable(plan, actual, true)
+		}
+	}
+}
+
+func (s *scope) planCreate(plan *abstract.Plan, desired userAccount) {
+	plan.Add(desired, "create", func(ctx context.Context) error {
+		suffix := "@" + s.id + ".iam.gserviceaccount.com"
+		if !strings.HasSuffix(desired.Email, suffix) {
+			return fmt.Errorf("Unable to create %s outside of project %s", desired.Email, s.id)
+		}
+		client, err := s.createClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+		_, err = client.CreateServiceAccount(ctx, &adminpb.CreateServiceAccountRequest{
+			Name:      "projects/" + s.id,
+			AccountId: strings.TrimSuffix(desired.Email, suffix),
+			ServiceAccount: &adminpb.ServiceAccount{
+				DisplayName: desired.Name,
+			},
+		})
+		return err
+	})
+	// The description, disabled state and policy can only be set once the account exists
+	created := userAccount{Name: desired.Name, Email: desired.Email, Type: desired.Type, Bindings: []accountBinding{}}
+	s.planUpdate(plan, desired, created)
+	s.planAccountPolicy(plan, desired, created)
+}
+
+// planUpdate changes the display name, description and disabled state of actual to match desired
+func (s *scope) planUpdate(plan *abstract.Plan, desired, actual userAccount) {
+	fields := []string{}
+	if desired.Name != actual.Name {
+		fields = append(fields, "displayName")
+	}
+	if desired.Description != actual.Description {
+		fields = append(fields, "description")
+	}
+	if len(fields) != 0 {
+		plan.Add(desired, "update "+strings.Join(fields, " and "), func(ctx context.Context) error {
+			body := map[string]interface{}{
+				"serviceAccount": restAccount{
+					Email:       desired.Email,
+					DisplayName: desired.Name,
+					Description: desired.Description,
+				},
+				"updateMask": strings.Join(fields, ","),
+			}
+			return s.callAccount(ctx, http.MethodPatch, desired.Email, "", body)
+		})
+	}
+	if desired.Disabled != actual.Disabled {
+		s.planDisable(plan, desired, desired.Disabled)
+	}
+}
+
+func (s *scope) planDisable(plan *abstract.Plan, account userAccount, disable bool) {
+	method := "enable"
+	if disable {
+		method = "disable"
+	}
+	plan.Add(account, method, func(ctx context.Context) error {
+		return s.callAccount(ctx, http.MethodPost, account.Email, ":"+method, struct{}{})
+	})
+}
+
+func (s *scope) planDelete(plan *abstract.Plan, account userAccount) {
+	plan.Add(account, "delete", func(ctx context.Context) error {
+		client, err := s.createClient()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+		return client.DeleteServiceAccount(ctx, &adminpb.DeleteServiceAccountRequest{
+			Name: "projects/" + s.id + "/serviceAccounts/" + account.Email,
+		})
+	})
+}
+
+// callAccount sends a request to the REST path of the service account,
+// suffix selects a custom method such as ":disable".
+func (s *scope) callAccount(ctx context.Context, method, email, suffix string, body interface{}) error {
+	client, err := s.createRESTClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return client.do(ctx, method, "v1/projects/"+s.id+"/serviceAccounts/"+email+suffix, body, nil)
+}
+
+// changedResources returns each resource that had a change applied to it once,
+// bindings are told apart by the project, folder or organization they belong to.
+func changedResources(changes []abstract.Change) []overwatch.IamResource {
+	resources := []overwatch.IamResource{}
+	seen := map[string]bool{}
+	for _, change := range changes {
+		key := change.Resource.GetType() + "/" + change.Resource.GetName()
+		if b, ok := change.Resource.(binding); ok {
+			key = b.Resource + "/" + key
+		}
+		if !seen[key] {
+			seen[key] = true
+			resources = append(resources, change.Resource)
+		}
+	}
+	return resources
+}
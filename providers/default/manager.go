@@ -58,6 +58,14 @@ func (m *Manager) Readconfig(conf overwatch.IamManagerConfig) error {
 			return err
 		}
 		m.Storer = storer
+	case "local":
+		location, ok := conf.Additional["Location"].(string)
+		if !ok {
+			location = conf.GitLocation
+		}
+		m.Storer = NewLocalStore(location)
+	default:
+		return fmt.Errorf("Unknown Synchro type %s", synchroType)
 	}
 	// As we don't have any data currently stored inside the Manager,
 	// Knowning if it had updated is not important
@@ -68,10 +76,17 @@ func (m *Manager) Readconfig(conf overwatch.IamManagerConfig) error {
 }
 
 func ReadFiles(dir string, transformer func([]byte) ([]overwatch.IamResource, error)) ([]overwatch.IamResource, error) {
-	if f, err := os.Stat(dir); os.IsNotExist(err) && (f != nil && !f.IsDir()) {
-		return nil, fmt.Errorf("Unable to load directory %s, %v", dir, err)
-	}
 	collection := []overwatch.IamResource{}
+	f, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err):
+		// Nothing has been stored for this resource type yet
+		return collection, nil
+	case err != nil:
+		return nil, err
+	case !f.IsDir():
+		return nil, fmt.Errorf("Unable to load directory %s, it is not a directory", dir)
+	}
 	filecollection, err := ioutil.ReadDir(dir)
 	if err != nil {
 		return nil, err
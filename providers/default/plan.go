@@ -0,0 +1,142 @@
+package abstract
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	overwatch "github.com/SeedJobs/devops-go-overwatch"
+)
+
+const (
+	// PolicyAlertOnly reports drift without changing the provider
+	PolicyAlertOnly = "alert-only"
+	// PolicyEnforce applies the stored configuration to the provider
+	PolicyEnforce = "enforce"
+)
+
+// Policy decides for each resource whether drift found on Resync
+// is corrected on the provider or only reported.
+type Policy struct {
+	// Default is used for any resource without an override
+	Default string
+	// Overrides are keyed by the resource type, such as "Repo",
+	// or the type and name of a single resource, such as "Repo/service".
+	Overrides map[string]string
+}
+
+// ReadPolicy loads the policy from Enforcement inside the additional map,
+// which is either a single policy for every resource or a map of overrides
+// where the "Default" key replaces the default. When Enforcement is not
+// defined the policy is alert-only, unless Enforce is set to true.
+func ReadPolicy(additional map[string]interface{}) (Policy, error) {
+	policy := Policy{
+		Default:   PolicyAlertOnly,
+		Overrides: map[string]string{},
+	}
+	if enforce, ok := additional["Enforce"].(bool); ok && enforce {
+		policy.Default = PolicyEnforce
+	}
+	switch enforcement := additional["Enforcement"].(type) {
+	case nil:
+	case string:
+		policy.Default = enforcement
+	case map[string]string:
+		for key, value := range enforcement {
+			if key == "Default" {
+				policy.Default = value
+				continue
+			}
+			policy.Overrides[key] = value
+		}
+	default:
+		return policy, fmt.Errorf("Enforcement must be a string or map[string]string, got %T", enforcement)
+	}
+	for _, value := range append([]string{policy.Default}, overrideValues(policy.Overrides)...) {
+		if value != PolicyAlertOnly && value != PolicyEnforce {
+			return policy, fmt.Errorf("Unknown Enforcement policy %s, expected %s or %s", value, PolicyAlertOnly, PolicyEnforce)
+		}
+	}
+	return policy, nil
+}
+
+func overrideValues(overrides map[string]string) []string {
+	values := []string{}
+	for _, value := range overrides {
+		values = append(values, value)
+	}
+	return values
+}
+
+// For returns the policy of the resource, an override for the
+// single resource beats an override for its type.
+func (p Policy) For(resource overwatch.IamResource) string {
+	if value, exist := p.Overrides[resource.GetType()+"/"+resource.GetName()]; exist {
+		return value
+	}
+	if value, exist := p.Overrides[resource.GetType()]; exist {
+		return value
+	}
+	if p.Default == "" {
+		return PolicyAlertOnly
+	}
+	return p.Default
+}
+
+// Change is a single update that brings a resource on the
+// provider back in line with the stored configuration.
+type Change struct {
+	// Resource is the stored version of the resource being changed
+	Resource overwatch.IamResource
+	// Description explains the change, such as "make private"
+	Description string
+	// Apply makes the change on the provider
+	Apply func(context.Context) error
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s/%s: %s", c.Resource.GetType(), c.Resource.GetName(), c.Description)
+}
+
+// Plan is every change needed to resync a provider, in the order they are applied.
+// Managers build a plan from the drift they find so that what would change
+// can be inspected, and the policy decides what is actually applied.
+type Plan struct {
+	Changes []Change
+}
+
+// Add appends a change for the resource to the plan
+func (p *Plan) Add(resource overwatch.IamResource, description string, apply func(context.Context) error) {
+	p.Changes = append(p.Changes, Change{
+		Resource:    resource,
+		Description: description,
+		Apply:       apply,
+	})
+}
+
+func (p *Plan) String() string {
+	lines := []string{}
+	for _, change := range p.Changes {
+		lines = append(lines, change.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Apply makes every change whose resource the policy enforces and returns
+// the changes that were applied along with the ones that were only alerted on.
+// It stops at the first change that fails, returning what was applied before it
+// along with the error of the change wrapped so that errors.Is is able to match it.
+func (p *Plan) Apply(ctx context.Context, policy Policy) ([]Change, []Change, error) {
+	applied, alerted := []Change{}, []Change{}
+	for _, change := range p.Changes {
+		if policy.For(change.Resource) != PolicyEnforce {
+			alerted = append(alerted, change)
+			continue
+		}
+		if err := change.Apply(ctx); err != nil {
+			return applied, alerted, fmt.Errorf("Unable to apply %s: %w", change, err)
+		}
+		applied = append(applied, change)
+	}
+	return applied, alerted, nil
+}
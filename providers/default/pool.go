@@ -0,0 +1,53 @@
+package abstract
+
+import (
+	"context"
+	"sync"
+)
+
+// ForEach calls fn for every index in [0, count) using at most workers goroutines.
+// The first error returned by fn cancels the context passed to the remaining calls,
+// stops any further indexes being started and is returned once all workers have finished.
+// Callers that need ordered output should store results by index.
+func ForEach(ctx context.Context, count, workers int, fn func(context.Context, int) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	indexes := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := fn(ctx, i); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+feed:
+	for i := 0; i < count; i++ {
+		select {
+		case <-ctx.Done():
+			break feed
+		case indexes <- i:
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	// Only set when the parent context was cancelled
+	return ctx.Err()
+}
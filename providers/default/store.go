@@ -0,0 +1,33 @@
+package abstract
+
+import (
+	"os"
+
+	"github.com/SeedJobs/devops-go-synchro"
+)
+
+// localStore is a synchro.Store that is backed by a plain directory
+// with no remote to sync against. It is mostly useful for running managers
+// offline or in tests where a git remote is not available.
+type localStore struct {
+	location string
+}
+
+// NewLocalStore creates a synchro.Store that reads and writes
+// directly to the given location.
+func NewLocalStore(location string) synchro.Store {
+	return &localStore{location: location}
+}
+
+// Synced ensures the directory exists, as there is no remote
+// the local copy is never reported as updated.
+func (l *localStore) Synced() (bool, error) {
+	if err := os.MkdirAll(l.location, os.ModePerm); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+func (l *localStore) GetPath() string {
+	return l.location
+}